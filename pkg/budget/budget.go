@@ -0,0 +1,154 @@
+// budget package compares the budgets of the revenue and expense accounts of a ledger with the actuals.
+//
+// The budgets are kept by the ledger, see ledger.Budget:
+//   - The amounts have the normal sign of the account type, as in the income statement.
+//   - A budget can be restricted to some dimension values, such as a department, to plan each of them apart.
+//   - The comparison rolls the budgets and the actuals up through the account hierarchy.
+package budget
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// Monthly adds a budget to the ledger for each month of the year, starting in January, in the given location.
+// The months with a zero amount are left without budget.
+func Monthly(l *ledger.Ledger, account uuid.UUID, dims ledger.Dimensions, year int, loc *time.Location, amounts [12]int) error {
+	for i, amount := range amounts {
+		if amount == 0 {
+			continue
+		}
+		from := time.Date(year, time.Month(i+1), 1, 0, 0, 0, 0, loc)
+		if err := l.AddBudget(&ledger.Budget{Account: account, Dimensions: dims, From: from, To: from.AddDate(0, 1, 0), Amount: amount}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Line is the budget and the actual of an account, including its descendants.
+type Line struct {
+	Account  ledger.Account
+	Depth    int // 0 for a root account.
+	Budget   int
+	Actual   int
+	Variance int     // Actual minus Budget.
+	Percent  float64 // The variance as a percentage of the budget, zero without budget.
+}
+
+// Favorable returns true if the actual is better than the budget: more revenue or less expense.
+func (l Line) Favorable() bool {
+	if l.Account.AccountType == ledger.AccountTypeExpense {
+		return l.Variance <= 0
+	}
+	return l.Variance >= 0
+}
+
+// Report compares the budgets with the actuals of a period.
+type Report struct {
+	From       time.Time
+	To         time.Time
+	Dimensions ledger.Dimensions
+	Lines      []Line // Each account followed by its children, in the order of the accounts of the ledger.
+	Revenue    Line   // The total of the revenue accounts, with no Account.
+	Expense    Line   // The total of the expense accounts, with no Account.
+}
+
+// Compare reports the budgets and the actual amounts posted in the range [from, to) of the revenue
+// and expense accounts with either of them, restricted to the entries with the given dimension values, if any.
+//
+// Only the budgets with a period inside the range are counted, and with dimensions,
+// only the budgets with all of them.
+func Compare(l *ledger.Ledger, from, to time.Time, dims ledger.Dimensions) (*Report, error) {
+	accounts, err := l.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	all, err := l.Budgets()
+	if err != nil {
+		return nil, err
+	}
+
+	budgets := make(map[uuid.UUID]int)
+	for _, b := range all {
+		if !b.From.Before(from) && !b.To.After(to) && b.Dimensions.Matches(dims) {
+			budgets[b.Account] += b.Amount
+		}
+	}
+
+	lines := make(map[uuid.UUID]*Line)
+	children := make(map[uuid.UUID][]uuid.UUID)
+	for _, a := range accounts {
+		if a.AccountType != ledger.AccountTypeRevenue && a.AccountType != ledger.AccountTypeExpense {
+			continue
+		}
+		entries, err := l.Entries(a.ID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		actual := 0
+		for _, e := range entries {
+			if e.Dimensions.Matches(dims) {
+				actual += e.Amount
+			}
+		}
+		lines[a.ID] = &Line{Account: a, Budget: budgets[a.ID], Actual: actual * a.AccountType.NormalSign()}
+		children[a.ParentID] = append(children[a.ParentID], a.ID)
+	}
+
+	r := &Report{From: from, To: to, Dimensions: maps.Clone(dims)}
+	r.Revenue.Account.AccountType = ledger.AccountTypeRevenue
+	r.Expense.Account.AccountType = ledger.AccountTypeExpense
+
+	// the children are rolled up into their parents,
+	// and the accounts with neither budget nor actual, in them or in their descendants, left out
+	var visit func(id uuid.UUID, depth int) *Line
+	visit = func(id uuid.UUID, depth int) *Line {
+		line := lines[id]
+		line.Depth = depth
+		i := len(r.Lines)
+		r.Lines = append(r.Lines, Line{})
+		for _, child := range children[id] {
+			c := visit(child, depth+1)
+			line.Budget += c.Budget
+			line.Actual += c.Actual
+		}
+		if line.Budget == 0 && line.Actual == 0 && len(r.Lines) == i+1 {
+			r.Lines = slices.Delete(r.Lines, i, i+1)
+			return line
+		}
+		line.variance()
+		r.Lines[i] = *line
+		return line
+	}
+	for _, a := range accounts {
+		if _, ok := lines[a.ID]; !ok {
+			continue
+		}
+		if _, ok := lines[a.ParentID]; ok {
+			continue
+		}
+		line := visit(a.ID, 0)
+		total := &r.Expense
+		if line.Account.AccountType == ledger.AccountTypeRevenue {
+			total = &r.Revenue
+		}
+		total.Budget += line.Budget
+		total.Actual += line.Actual
+	}
+	r.Revenue.variance()
+	r.Expense.variance()
+	return r, nil
+}
+
+func (l *Line) variance() {
+	l.Variance = l.Actual - l.Budget
+	l.Percent = 0
+	if l.Budget != 0 {
+		l.Percent = float64(l.Variance) / float64(l.Budget) * 100
+	}
+}
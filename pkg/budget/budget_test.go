@@ -0,0 +1,103 @@
+package budget_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tarcisio/haya/pkg/budget"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+func Test_Compare(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	l.RegisterDimension(ledger.DimensionCatalog{Dimension: ledger.DimensionDepartment, Values: []string{"eng", "sales"}})
+	jan := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	revenue := &ledger.Account{Name: "Revenue", AccountType: ledger.AccountTypeRevenue}
+	expenses := &ledger.Account{Name: "Expenses", AccountType: ledger.AccountTypeExpense}
+	l.CreateAccount(bank)
+	l.CreateAccount(revenue)
+	l.CreateAccount(expenses)
+	salaries := &ledger.Account{Name: "Salaries", ParentID: expenses.ID, AccountType: ledger.AccountTypeExpense}
+	travel := &ledger.Account{Name: "Travel", ParentID: expenses.ID, AccountType: ledger.AccountTypeExpense}
+	office := &ledger.Account{Name: "Office", ParentID: expenses.ID, AccountType: ledger.AccountTypeExpense}
+	l.CreateAccount(salaries)
+	l.CreateAccount(travel)
+	l.CreateAccount(office)
+
+	post := func(at time.Time, account *ledger.Account, amount int, department string) {
+		tx := ledger.NewTransaction(at)
+		tx.AddEntries([]ledger.Entry{
+			{Account: account.ID, Amount: amount, Dimensions: ledger.Dimensions{ledger.DimensionDepartment: department}},
+			{Account: bank.ID, Amount: -amount},
+		})
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	post(jan.AddDate(0, 0, 10), revenue, -12000, "sales")
+	post(jan.AddDate(0, 0, 25), salaries, 5000, "eng")
+	post(jan.AddDate(0, 0, 25), salaries, 3000, "sales")
+	post(jan.AddDate(0, 0, 15), travel, 900, "sales")
+	post(jan.AddDate(0, 1, 15), travel, 400, "sales")
+
+	if err := budget.Monthly(l, revenue.ID, nil, 2026, time.UTC, [12]int{10000, 10000}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	budget.Monthly(l, salaries.ID, ledger.Dimensions{ledger.DimensionDepartment: "eng"}, 2026, time.UTC, [12]int{6000, 6000})
+	budget.Monthly(l, salaries.ID, ledger.Dimensions{ledger.DimensionDepartment: "sales"}, 2026, time.UTC, [12]int{3000, 3000})
+	l.AddBudget(&ledger.Budget{Account: travel.ID, From: jan, To: jan.AddDate(0, 1, 0), Amount: 600})
+
+	// a budget counting the same entries as another is rejected
+	err := l.AddBudget(&ledger.Budget{Account: salaries.ID, From: jan, To: jan.AddDate(0, 3, 0), Amount: 1})
+	if !errors.Is(err, ledger.ErrBudgetOverlap) {
+		t.Errorf("expected ErrBudgetOverlap but got %v", err)
+	}
+	// a budget by project and one by department both count the entries with a project and a department
+	byProject := ledger.Dimensions{ledger.DimensionProject: "apollo"}
+	for i := 0; i < 20; i++ {
+		err := l.AddBudget(&ledger.Budget{Account: salaries.ID, Dimensions: byProject, From: jan, To: jan.AddDate(0, 1, 0), Amount: 1})
+		if !errors.Is(err, ledger.ErrBudgetOverlap) {
+			t.Fatalf("expected ErrBudgetOverlap for budgets with different dimensions but got %v", err)
+		}
+	}
+	if err := l.AddBudget(&ledger.Budget{Account: salaries.ID, Dimensions: byProject, From: jan.AddDate(0, 2, 0), To: jan.AddDate(0, 3, 0), Amount: 1}); err != nil {
+		t.Errorf("a budget in another period should be accepted but got %v", err)
+	}
+	if err := l.AddBudget(&ledger.Budget{Account: bank.ID, From: jan, To: jan.AddDate(0, 1, 0), Amount: 1}); err == nil {
+		t.Error("a budget for an asset account should be rejected")
+	}
+	if budgets, _ := l.Budgets(); len(budgets) != 8 {
+		t.Errorf("expected the 8 budgets to be kept by the ledger but got %d", len(budgets))
+	}
+
+	r, err := budget.Compare(l, jan, jan.AddDate(0, 1, 0), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the office has neither budget nor actual
+	if len(r.Lines) != 4 || r.Lines[0].Account.ID != revenue.ID || r.Lines[1].Account.ID != expenses.ID || r.Lines[2].Depth != 1 {
+		t.Fatalf("unexpected lines %+v", r.Lines)
+	}
+	if line := r.Lines[0]; line.Budget != 10000 || line.Actual != 12000 || line.Variance != 2000 || line.Percent != 20 || !line.Favorable() {
+		t.Errorf("unexpected revenue line %+v", line)
+	}
+	if line := r.Lines[1]; line.Budget != 9600 || line.Actual != 8900 || line.Variance != -700 || !line.Favorable() {
+		t.Errorf("expected the expenses to roll up salaries and travel, got %+v", line)
+	}
+	if line := r.Lines[3]; line.Account.ID != travel.ID || line.Variance != 300 || line.Percent != 50 || line.Favorable() {
+		t.Errorf("unexpected travel line %+v", line)
+	}
+	if r.Revenue.Actual != 12000 || r.Expense.Budget != 9600 {
+		t.Errorf("unexpected totals %+v %+v", r.Revenue, r.Expense)
+	}
+
+	// the sales department only counts its budgets and entries
+	r, _ = budget.Compare(l, jan, jan.AddDate(0, 2, 0), ledger.Dimensions{ledger.DimensionDepartment: "sales"})
+	if r.Expense.Budget != 6000 || r.Expense.Actual != 4300 || r.Revenue.Budget != 0 || r.Revenue.Actual != 12000 {
+		t.Errorf("unexpected totals for sales %+v %+v", r.Revenue, r.Expense)
+	}
+}
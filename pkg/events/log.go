@@ -0,0 +1,160 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// Event is a TransactionPosted event at its position in the log.
+// Positions start at 0 and have no gaps.
+type Event struct {
+	Position uint64
+	ledger.TransactionPosted
+}
+
+// Log is the append-only sequence of events of a Stream.
+//
+// Implementations must be safe for concurrent use.
+type Log interface {
+	Append(e ledger.TransactionPosted) (uint64, error) // Returns the position of the appended event.
+	Read(from uint64, max int) ([]Event, error)        // Returns up to max events starting at the position.
+	Len() (uint64, error)                              // Returns the position of the next event.
+}
+
+// MemoryLog is a Log that keeps the events in memory.
+type MemoryLog struct {
+	mu     sync.RWMutex
+	events []Event
+}
+
+// NewMemoryLog creates an empty in-memory log.
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{}
+}
+
+func (l *MemoryLog) Append(e ledger.TransactionPosted) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	position := uint64(len(l.events))
+	l.events = append(l.events, Event{Position: position, TransactionPosted: e})
+	return position, nil
+}
+
+func (l *MemoryLog) Read(from uint64, max int) ([]Event, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return readEvents(l.events, from, max), nil
+}
+
+func (l *MemoryLog) Len() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return uint64(len(l.events)), nil
+}
+
+// FileLog is a Log persisted in a file, one JSON encoded event per line.
+//
+// The events are kept in memory too, the file is read once when the log is opened
+// and every append is synced to disk before returning.
+type FileLog struct {
+	mu     sync.RWMutex
+	file   *os.File
+	events []Event
+}
+
+// OpenFileLog opens the log stored in the file, creating it if it does not exist.
+//
+// A last line without its newline is an append interrupted by a crash, it was never acknowledged
+// so it is removed from the file. Any other line that cannot be decoded is an error.
+func OpenFileLog(path string) (*FileLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &FileLog{file: f}
+	r := bufio.NewReader(f)
+	var size int64 // The size of the complete lines.
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				if err := f.Truncate(size); err != nil {
+					f.Close()
+					return nil, err
+				}
+			}
+			return l, nil
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("event log %s, line %d: %w", path, len(l.events)+1, err)
+		}
+		if e.Position != uint64(len(l.events)) {
+			f.Close()
+			return nil, fmt.Errorf("event log %s: expected position %d but got %d", path, len(l.events), e.Position)
+		}
+		l.events = append(l.events, e)
+		size += int64(len(line))
+	}
+}
+
+func (l *FileLog) Append(e ledger.TransactionPosted) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event := Event{Position: uint64(len(l.events)), TransactionPosted: e}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	if err := l.file.Sync(); err != nil {
+		return 0, err
+	}
+	l.events = append(l.events, event)
+	return event.Position, nil
+}
+
+func (l *FileLog) Read(from uint64, max int) ([]Event, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return readEvents(l.events, from, max), nil
+}
+
+func (l *FileLog) Len() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return uint64(len(l.events)), nil
+}
+
+// Close closes the underlying file.
+func (l *FileLog) Close() error {
+	return l.file.Close()
+}
+
+func readEvents(events []Event, from uint64, max int) []Event {
+	if from >= uint64(len(events)) {
+		return nil
+	}
+	to := uint64(len(events))
+	if max > 0 && from+uint64(max) < to {
+		to = from + uint64(max)
+	}
+	return append([]Event(nil), events[from:to]...)
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// OffsetStore keeps the position of the next event each consumer must receive.
+//
+// Implementations must be safe for concurrent use.
+// A consumer that never saved an offset starts at position 0.
+type OffsetStore interface {
+	Load(consumer string) (uint64, error)
+	Save(consumer string, offset uint64) error
+}
+
+// MemoryOffsets is an OffsetStore that keeps the offsets in memory.
+type MemoryOffsets struct {
+	mu      sync.Mutex
+	offsets map[string]uint64
+}
+
+// NewMemoryOffsets creates an empty in-memory offset store.
+func NewMemoryOffsets() *MemoryOffsets {
+	return &MemoryOffsets{offsets: make(map[string]uint64)}
+}
+
+func (s *MemoryOffsets) Load(consumer string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offsets[consumer], nil
+}
+
+func (s *MemoryOffsets) Save(consumer string, offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offsets[consumer] = offset
+	return nil
+}
+
+// FileOffsets is an OffsetStore persisted in a JSON file.
+//
+// Every save rewrites the file through a temporary file and a rename,
+// so a crash never leaves it half written.
+type FileOffsets struct {
+	mu      sync.Mutex
+	path    string
+	offsets map[string]uint64
+}
+
+// OpenFileOffsets opens the offsets stored in the file, it is created on the first save.
+func OpenFileOffsets(path string) (*FileOffsets, error) {
+	s := &FileOffsets{path: path, offsets: make(map[string]uint64)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.offsets); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileOffsets) Load(consumer string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offsets[consumer], nil
+}
+
+func (s *FileOffsets) Save(consumer string, offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.offsets[consumer]
+	s.offsets[consumer] = offset
+	if err := s.write(); err != nil {
+		if existed {
+			s.offsets[consumer] = previous
+		} else {
+			delete(s.offsets, consumer)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileOffsets) write() error {
+	data, err := json.Marshal(s.offsets)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
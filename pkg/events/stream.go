@@ -0,0 +1,246 @@
+// events package streams the TransactionPosted events of a ledger to its subscribers.
+//
+// The Stream is an in-process fan-out over an append-only Log:
+//   - Every subscriber is a named durable consumer, its offset is kept in an OffsetStore.
+//   - Delivery is at-least-once: the offset is saved only after the handler succeeds,
+//     so an event can be delivered again after a failure or a crash, but it is never skipped.
+//   - Events can be replayed from any position of the log.
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// Handler processes the events delivered to a subscriber.
+// Returning an error makes the stream deliver the same event again after the retry delay.
+type Handler interface {
+	Handle(e Event) error
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(e Event) error
+
+func (f HandlerFunc) Handle(e Event) error {
+	return f(e)
+}
+
+const (
+	DefaultRetryDelay = time.Second
+	DefaultBatchSize  = 100
+)
+
+// Stream publishes the events of a ledger to its subscribers.
+// It implements ledger.Publisher and is safe for concurrent use.
+type Stream struct {
+	RetryDelay time.Duration // How long to wait before delivering again an event that failed.
+	BatchSize  int           // How many events a subscriber reads from the log at once.
+
+	log           Log
+	offsets       OffsetStore
+	mu            sync.Mutex
+	subscriptions map[string]*Subscription
+}
+
+// NewStream creates a stream over the given log and offset store.
+func NewStream(log Log, offsets OffsetStore) *Stream {
+	return &Stream{
+		RetryDelay:    DefaultRetryDelay,
+		BatchSize:     DefaultBatchSize,
+		log:           log,
+		offsets:       offsets,
+		subscriptions: make(map[string]*Subscription),
+	}
+}
+
+// Publish appends the event to the log and wakes up the subscribers.
+func (s *Stream) Publish(e ledger.TransactionPosted) error {
+	if _, err := s.log.Append(e); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subscriptions {
+		sub.notify()
+	}
+	return nil
+}
+
+// Subscribe starts delivering events to the handler from the saved offset of the consumer.
+//
+// The events are delivered in order, one at a time, in a goroutine owned by the subscription.
+// A consumer can only have one active subscription.
+func (s *Stream) Subscribe(consumer string, h Handler) (*Subscription, error) {
+	if consumer == "" {
+		return nil, errors.New("consumer must have a name")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[consumer]; ok {
+		return nil, fmt.Errorf("consumer %q is already subscribed", consumer)
+	}
+
+	sub := &Subscription{
+		consumer: consumer,
+		stream:   s,
+		handler:  h,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	s.subscriptions[consumer] = sub
+	go sub.run()
+	return sub, nil
+}
+
+// Seek moves the saved offset of the consumer, its next subscription starts at the given position.
+// It cannot be used while the consumer is subscribed.
+func (s *Stream) Seek(consumer string, position uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[consumer]; ok {
+		return fmt.Errorf("consumer %q is subscribed", consumer)
+	}
+	return s.offsets.Save(consumer, position)
+}
+
+// Replay delivers the events from the given position to the current end of the log.
+//
+// It runs in the caller goroutine, stops at the first handler error and does not change any offset.
+func (s *Stream) Replay(from uint64, h Handler) error {
+	end, err := s.log.Len()
+	if err != nil {
+		return err
+	}
+	for from < end {
+		events, err := s.log.Read(from, s.batchSize())
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		for _, e := range events {
+			if e.Position >= end {
+				return nil
+			}
+			if err := h.Handle(e); err != nil {
+				return fmt.Errorf("event %d: %w", e.Position, err)
+			}
+			from = e.Position + 1
+		}
+	}
+	return nil
+}
+
+func (s *Stream) batchSize() int {
+	if s.BatchSize <= 0 {
+		return DefaultBatchSize
+	}
+	return s.BatchSize
+}
+
+func (s *Stream) retryDelay() time.Duration {
+	if s.RetryDelay <= 0 {
+		return DefaultRetryDelay
+	}
+	return s.RetryDelay
+}
+
+// Subscription is an active consumer of a Stream.
+type Subscription struct {
+	consumer string
+	stream   *Stream
+	handler  Handler
+	wake     chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+// Close stops the delivery and waits for the event being handled, if any.
+// The consumer offset is kept, a new subscription continues from where this one stopped.
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		close(sub.done)
+		<-sub.stopped
+
+		s := sub.stream
+		s.mu.Lock()
+		delete(s.subscriptions, sub.consumer)
+		s.mu.Unlock()
+	})
+}
+
+func (sub *Subscription) notify() {
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks for the retry delay, it returns false if the subscription is closed meanwhile.
+func (sub *Subscription) wait() bool {
+	select {
+	case <-sub.done:
+		return false
+	case <-time.After(sub.stream.retryDelay()):
+		return true
+	}
+}
+
+func (sub *Subscription) run() {
+	defer close(sub.stopped)
+	s := sub.stream
+
+	offset, err := s.offsets.Load(sub.consumer)
+	for err != nil {
+		if !sub.wait() {
+			return
+		}
+		offset, err = s.offsets.Load(sub.consumer)
+	}
+
+	for {
+		events, err := s.log.Read(offset, s.batchSize())
+		if err != nil {
+			if !sub.wait() {
+				return
+			}
+			continue
+		}
+
+		if len(events) == 0 {
+			select {
+			case <-sub.done:
+				return
+			case <-sub.wake:
+			}
+			continue
+		}
+
+		for _, e := range events {
+			select {
+			case <-sub.done:
+				return
+			default:
+			}
+
+			for sub.handler.Handle(e) != nil {
+				if !sub.wait() {
+					return
+				}
+			}
+
+			// a failed save only means the event may be delivered again after a restart
+			offset = e.Position + 1
+			s.offsets.Save(sub.consumer, offset)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package events_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tarcisio/haya/pkg/events"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// collector records the positions delivered to a handler.
+type collector struct {
+	mu        sync.Mutex
+	positions []uint64
+	received  chan struct{}
+	failOnce  map[uint64]bool
+}
+
+func newCollector() *collector {
+	return &collector{received: make(chan struct{}, 100), failOnce: make(map[uint64]bool)}
+}
+
+func (c *collector) Handle(e events.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.positions = append(c.positions, e.Position)
+	if c.failOnce[e.Position] {
+		delete(c.failOnce, e.Position)
+		return errors.New("temporary failure")
+	}
+	c.received <- struct{}{}
+	return nil
+}
+
+func (c *collector) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for event %d of %d", i+1, n)
+		}
+	}
+}
+
+func newLedger(t *testing.T, p ledger.Publisher) (*ledger.Ledger, func()) {
+	l := ledger.New(ledger.NewMemoryStorage(), ledger.WithPublisher(p))
+	cash := &ledger.Account{Name: "Cash", AccountType: ledger.AccountTypeAsset}
+	equity := &ledger.Account{Name: "Capital", AccountType: ledger.AccountTypeEquity}
+	l.CreateAccount(cash)
+	l.CreateAccount(equity)
+
+	return l, func() {
+		tx := ledger.NewTransaction(time.Now())
+		tx.AddEntries([]ledger.Entry{{Account: cash.ID, Amount: 10}, {Account: equity.ID, Amount: -10}})
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error posting: %v", err)
+		}
+	}
+}
+
+func Test_Stream(t *testing.T) {
+	dir := t.TempDir()
+	log, err := events.OpenFileLog(filepath.Join(dir, "events.log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	offsets, err := events.OpenFileOffsets(filepath.Join(dir, "offsets.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stream := events.NewStream(log, offsets)
+	stream.RetryDelay = time.Millisecond
+	_, post := newLedger(t, stream)
+
+	// the first delivery of event 1 fails and must be retried
+	c := newCollector()
+	c.failOnce[1] = true
+	sub, err := stream.Subscribe("analytics", c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := stream.Subscribe("analytics", c); err == nil {
+		t.Error("a consumer should not be subscribed twice")
+	}
+
+	post()
+	post()
+	post()
+	c.wait(t, 3)
+	sub.Close()
+
+	if want := []uint64{0, 1, 1, 2}; !slices.Equal(c.positions, want) {
+		t.Errorf("delivered positions should be %v but got %v", want, c.positions)
+	}
+
+	// reopen the log and offsets as if the process restarted, the consumer continues where it stopped
+	post()
+	log.Close()
+	log, _ = events.OpenFileLog(filepath.Join(dir, "events.log"))
+	offsets, _ = events.OpenFileOffsets(filepath.Join(dir, "offsets.json"))
+	stream = events.NewStream(log, offsets)
+	defer log.Close()
+
+	c = newCollector()
+	sub, _ = stream.Subscribe("analytics", c)
+	c.wait(t, 1)
+	sub.Close()
+	if want := []uint64{3}; !slices.Equal(c.positions, want) {
+		t.Errorf("delivered positions after restart should be %v but got %v", want, c.positions)
+	}
+
+	// replay from a position does not change the offsets
+	c = newCollector()
+	if err := stream.Replay(2, c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []uint64{2, 3}; !slices.Equal(c.positions, want) {
+		t.Errorf("replayed positions should be %v but got %v", want, c.positions)
+	}
+	if offset, _ := offsets.Load("analytics"); offset != 4 {
+		t.Errorf("offset should be 4 but got %d", offset)
+	}
+
+	// seek rewinds a durable consumer
+	if err := stream.Seek("analytics", 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c = newCollector()
+	sub, _ = stream.Subscribe("analytics", c)
+	c.wait(t, 3)
+	sub.Close()
+	if want := []uint64{1, 2, 3}; !slices.Equal(c.positions, want) {
+		t.Errorf("delivered positions after seek should be %v but got %v", want, c.positions)
+	}
+
+	// an append torn by a crash is dropped when the log is opened again, the next append takes its place
+	log.Close()
+	f, _ := os.OpenFile(filepath.Join(dir, "events.log"), os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"Position":4,"Transaction":{"Id":`)
+	f.Close()
+	log, err = events.OpenFileLog(filepath.Join(dir, "events.log"))
+	if err != nil {
+		t.Fatalf("log with a torn last line should open but got %v", err)
+	}
+	if n, _ := log.Len(); n != 4 {
+		t.Errorf("log should have 4 events but got %d", n)
+	}
+	if position, err := log.Append(ledger.TransactionPosted{}); err != nil || position != 4 {
+		t.Errorf("append should be at position 4 but got %d (%v)", position, err)
+	}
+	log.Close()
+	if log, err = events.OpenFileLog(filepath.Join(dir, "events.log")); err != nil {
+		t.Fatalf("unexpected error reopening: %v", err)
+	}
+	defer log.Close()
+	if n, _ := log.Len(); n != 5 {
+		t.Errorf("log should have 5 events after reopening but got %d", n)
+	}
+}
+
+func Test_Stream_FanOut(t *testing.T) {
+	stream := events.NewStream(events.NewMemoryLog(), events.NewMemoryOffsets())
+	_, post := newLedger(t, stream)
+
+	a, b := newCollector(), newCollector()
+	subA, _ := stream.Subscribe("a", a)
+	subB, _ := stream.Subscribe("b", b)
+	defer subA.Close()
+	defer subB.Close()
+
+	for i := 0; i < 10; i++ {
+		post()
+	}
+	a.wait(t, 10)
+	b.wait(t, 10)
+}
@@ -1,6 +1,7 @@
 package ledger
 
 import (
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,23 +22,161 @@ const (
 	AccountTypeRevenue   AccountType = "Revenue"   // Revenue accounts represent the income earned by the business.
 )
 
+// IsValid returns true if the account type is one of the known types.
+func (t AccountType) IsValid() bool {
+	switch t {
+	case AccountTypeAsset, AccountTypeExpense, AccountTypeLiability, AccountTypeEquity, AccountTypeRevenue:
+		return true
+	}
+	return false
+}
+
+// NormalSign returns the sign of the entries that increase an account of this type.
+//   - Asset and Expense accounts increase with positive amounts (debits).
+//   - Liability, Equity and Revenue accounts increase with negative amounts (credits).
+func (t AccountType) NormalSign() int {
+	switch t {
+	case AccountTypeAsset, AccountTypeExpense:
+		return 1
+	}
+	return -1
+}
+
+// AccountSubType classifies an account inside its AccountType, for the presentation of the reports.
+//
+// A contra sub-type has the normal balance opposite to its AccountType, such as the accumulated depreciation,
+// a contra asset that increases with credits, or the sales returns, a contra revenue that increases with debits.
+type AccountSubType string
+
+const (
+	SubTypeCurrentAsset        AccountSubType = "CurrentAsset"
+	SubTypeNonCurrentAsset     AccountSubType = "NonCurrentAsset"
+	SubTypeContraAsset         AccountSubType = "ContraAsset"
+	SubTypeCurrentLiability    AccountSubType = "CurrentLiability"
+	SubTypeNonCurrentLiability AccountSubType = "NonCurrentLiability"
+	SubTypeContraLiability     AccountSubType = "ContraLiability"
+	SubTypeCapital             AccountSubType = "Capital"
+	SubTypeRetainedEarnings    AccountSubType = "RetainedEarnings"
+	SubTypeContraEquity        AccountSubType = "ContraEquity"
+	SubTypeOperatingRevenue    AccountSubType = "OperatingRevenue"
+	SubTypeOtherRevenue        AccountSubType = "OtherRevenue"
+	SubTypeContraRevenue       AccountSubType = "ContraRevenue"
+	SubTypeCostOfGoodsSold     AccountSubType = "CostOfGoodsSold"
+	SubTypeOperatingExpense    AccountSubType = "OperatingExpense"
+	SubTypeOtherExpense        AccountSubType = "OtherExpense"
+	SubTypeContraExpense       AccountSubType = "ContraExpense"
+)
+
+var subTypes = map[AccountType][]AccountSubType{
+	AccountTypeAsset:     {SubTypeCurrentAsset, SubTypeNonCurrentAsset, SubTypeContraAsset},
+	AccountTypeLiability: {SubTypeCurrentLiability, SubTypeNonCurrentLiability, SubTypeContraLiability},
+	AccountTypeEquity:    {SubTypeCapital, SubTypeRetainedEarnings, SubTypeContraEquity},
+	AccountTypeRevenue:   {SubTypeOperatingRevenue, SubTypeOtherRevenue, SubTypeContraRevenue},
+	AccountTypeExpense:   {SubTypeCostOfGoodsSold, SubTypeOperatingExpense, SubTypeOtherExpense, SubTypeContraExpense},
+}
+
+// SubTypes returns the sub-types of the account type, in the order they are presented in the reports.
+func (t AccountType) SubTypes() []AccountSubType {
+	return slices.Clone(subTypes[t])
+}
+
+// IsContra returns true if the normal balance of the sub-type is opposite to its account type.
+func (s AccountSubType) IsContra() bool {
+	switch s {
+	case SubTypeContraAsset, SubTypeContraLiability, SubTypeContraEquity, SubTypeContraRevenue, SubTypeContraExpense:
+		return true
+	}
+	return false
+}
+
 // Account represents a single account in a Ledger.
 //
 // An account can be a parent account, a child account or both.
 //   - if the account is a top-level account, your **ParentID** should be the zero value of uuid.UUID.
 //   - A parent account can have multiple child accounts.
 //   - A child account can have only one parent account.
+//
+// An account that receives a lot of concurrent postings can split its balance in Shards,
+// each posting updates only one of them and the reads sum them all.
+// Zero or one shard means the balance is not split.
+//
+// The SubType, if any, must be one of the SubTypes of the AccountType.
+// A contra account has the AccountType of the accounts it offsets, usually its parent, but the opposite normal balance.
+//
+// The Status controls which entries the account accepts, see [AccountStatus].
+//
+// The Code is the account number in the chart of accounts, such as "1010", unique if not empty.
+// The Tags are sorted labels used to find accounts, and the Metadata keeps any other reference.
+//
+// The Version is incremented by the storage on every update of the account,
+// so a posting prepared with an account closed or frozen in the meantime is detected (optimistic locking).
 type Account struct {
 	ID          uuid.UUID
 	ParentID    uuid.UUID
 	Name        string
 	AccountType AccountType
+	SubType     AccountSubType
+	Shards      int
+	Limits      BalanceLimits
+	Status      AccountStatus
+	Code        string
+	Tags        []string
+	Metadata    map[string]string
+	Version     uint64
+}
+
+// NormalSign returns the sign of the entries that increase the account,
+// the one of its AccountType or the opposite for a contra account.
+func (a Account) NormalSign() int {
+	if a.SubType.IsContra() {
+		return -a.AccountType.NormalSign()
+	}
+	return a.AccountType.NormalSign()
+}
+
+// BalanceLimits constrains the balance of an account, they are enforced when posting.
+//
+// The limits apply to the natural balance of the account (see [AccountBalance.Natural]),
+// the minimum to the available balance and the maximum to the posted balance.
+// So a customer wallet kept in a Liability account that must never be negative has a Min of 0,
+// and a credit line that can go down to -1000 has a Min of -1000.
+// A nil limit is not enforced.
+type BalanceLimits struct {
+	Min *int
+	Max *int
+}
+
+// IsSet returns true if any of the limits is set.
+func (l BalanceLimits) IsSet() bool {
+	return l.Min != nil || l.Max != nil
 }
 
 // AccountBalance represents the balance of an account at a given time.
+//
+// The Version is incremented by the storage on every update of the balance,
+// it is used to detect concurrent updates (optimistic locking).
+//
+// For sharded accounts the storage keeps one balance per Shard,
+// the balance returned by the Ledger is the sum of all the shards and its Version is the sum of their versions.
+//
+// The amounts have the same sign as the entries:
+//   - Balance is the posted balance.
+//   - Pending is the sum of the entries of the holds not yet captured, voided or expired.
+//   - Available is the posted balance reduced by the pending entries that decrease the account.
 type AccountBalance struct {
 	AccountID   uuid.UUID
 	AccountType AccountType
+	SubType     AccountSubType
+	Shard       int
 	Balance     int
+	Pending     int
+	Available   int
 	Timestamp   time.Time
+	Version     uint64
+}
+
+// Natural returns the balance with the sign of the account type, positive when the account has its normal balance.
+// The balance of a contra account is positive when it is opposite to its account type.
+func (b AccountBalance) Natural() int {
+	return b.Balance * Account{AccountType: b.AccountType, SubType: b.SubType}.NormalSign()
 }
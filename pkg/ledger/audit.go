@@ -0,0 +1,138 @@
+package ledger
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditAction is the administrative operation recorded in the audit log.
+// The postings are only recorded when they close a period or reverse a transaction.
+type AuditAction string
+
+const (
+	AuditCreateAccount      AuditAction = "CreateAccount"
+	AuditRenameAccount      AuditAction = "RenameAccount"
+	AuditMoveAccount        AuditAction = "MoveAccount"
+	AuditSplitAccount       AuditAction = "SplitAccount"
+	AuditSetBalanceLimits   AuditAction = "SetBalanceLimits"
+	AuditFreezeAccount      AuditAction = "FreezeAccount"
+	AuditUnfreezeAccount    AuditAction = "UnfreezeAccount"
+	AuditCloseAccount       AuditAction = "CloseAccount"
+	AuditArchiveAccount     AuditAction = "ArchiveAccount"
+	AuditSetAccountCode     AuditAction = "SetAccountCode"
+	AuditTagAccount         AuditAction = "TagAccount"
+	AuditSetAccountMetadata AuditAction = "SetAccountMetadata"
+	AuditClassifyAccount    AuditAction = "ClassifyAccount"
+	AuditClosePeriod        AuditAction = "ClosePeriod"        // A closing transaction was posted.
+	AuditReverseTransaction AuditAction = "ReverseTransaction" // A reversal was posted, see Reverse.
+)
+
+// AuditEntity is the type of the entity changed by an audited operation.
+type AuditEntity string
+
+const (
+	AuditEntityAccount     AuditEntity = "Account"
+	AuditEntityTransaction AuditEntity = "Transaction"
+)
+
+// AuditRecord is an entry of the append-only audit log of administrative operations.
+//
+// Before and After are the JSON encoded states of the entity, Before is empty when the entity is created.
+type AuditRecord struct {
+	ID         uuid.UUID
+	Timestamp  time.Time
+	Actor      string // Empty for operations not made through As.
+	Reason     string
+	Action     AuditAction
+	EntityType AuditEntity
+	Entity     uuid.UUID
+	Before     json.RawMessage
+	After      json.RawMessage
+}
+
+// AuditQuery filters the audit log, the zero value of every field matches everything.
+type AuditQuery struct {
+	Actor  string
+	Entity uuid.UUID
+	From   time.Time // Inclusive.
+	To     time.Time // Exclusive.
+}
+
+// Matches returns true if the record is selected by the query.
+func (q AuditQuery) Matches(r AuditRecord) bool {
+	return (q.Actor == "" || r.Actor == q.Actor) &&
+		(q.Entity == uuid.Nil || r.Entity == q.Entity) &&
+		(q.From.IsZero() || !r.Timestamp.Before(q.From)) &&
+		(q.To.IsZero() || r.Timestamp.Before(q.To))
+}
+
+// As returns a view of the ledger that records the actor and the reason in the audit log
+// for every administrative operation made through it, as in:
+//
+//	l.As("alice", "ticket 1234").RenameAccount(id, "Petty cash")
+func (l *Ledger) As(actor, reason string) *Ledger {
+	c := *l
+	c.actor, c.reason = actor, reason
+	return &c
+}
+
+// AuditLog returns the audit records selected by the query, in the order they were recorded.
+func (l *Ledger) AuditLog(q AuditQuery) ([]AuditRecord, error) {
+	return l.storage.AuditLog(q)
+}
+
+// saveAccount saves the account together with the audit record of the change.
+// before is nil when the account is created.
+func (l *Ledger) saveAccount(action AuditAction, before *Account, after Account) error {
+	r, err := l.auditRecord(action, before, after)
+	if err != nil {
+		return err
+	}
+	return l.storage.SaveAccount(after, r)
+}
+
+// auditRecord returns the audit record of the change of the account.
+func (l *Ledger) auditRecord(action AuditAction, before *Account, after Account) (AuditRecord, error) {
+	r, err := l.newAuditRecord(action, AuditEntityAccount, after.ID, after)
+	if err != nil || before == nil {
+		return r, err
+	}
+	r.Before, err = json.Marshal(before)
+	return r, err
+}
+
+// transactionAudit returns the audit record of the posting of the transaction,
+// if it closes a period or reverses another transaction.
+func (l *Ledger) transactionAudit(t *Transaction) (AuditRecord, bool, error) {
+	action := AuditAction("")
+	switch {
+	case t.TransactionType == TransactionTypeClosing:
+		action = AuditClosePeriod
+	case t.Metadata[MetadataReversal] != "":
+		action = AuditReverseTransaction
+	default:
+		return AuditRecord{}, false, nil
+	}
+	r, err := l.newAuditRecord(action, AuditEntityTransaction, t.Id, t)
+	return r, err == nil, err
+}
+
+// newAuditRecord returns the audit record of the entity created or changed to the given state.
+func (l *Ledger) newAuditRecord(action AuditAction, entityType AuditEntity, entity uuid.UUID, after any) (AuditRecord, error) {
+	r := AuditRecord{
+		ID:         uuid.New(),
+		Timestamp:  time.Now(),
+		Actor:      l.actor,
+		Reason:     l.reason,
+		Action:     action,
+		EntityType: entityType,
+		Entity:     entity,
+	}
+	var err error
+	if r.After, err = json.Marshal(after); err != nil {
+		return AuditRecord{}, err
+	}
+	return r, nil
+}
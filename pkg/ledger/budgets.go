@@ -0,0 +1,70 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrBudgetOverlap is returned when a budget counts some of the same entries as another budget of the account.
+var ErrBudgetOverlap = errors.New("budget overlaps another budget of the account")
+
+// Budget is the amount planned for a revenue or expense account in the period [From, To),
+// with the normal sign of the account type, as in the income statement.
+// It can be restricted to the entries with some dimension values, such as a department, to plan each of them apart.
+type Budget struct {
+	ID         uuid.UUID
+	Account    uuid.UUID
+	Dimensions Dimensions // Restricts the budget to the entries with these values, if any.
+	From       time.Time
+	To         time.Time
+	Amount     int
+}
+
+// Overlaps returns true if an entry can be counted by both budgets: they are for the same account,
+// their periods overlap and no dimension they both set has different values.
+// So the budgets of an account in a period can be split by the values of a dimension,
+// but a budget by department and another by project would count the same entries.
+func (b Budget) Overlaps(o Budget) bool {
+	if b.Account != o.Account || !b.From.Before(o.To) || !o.From.Before(b.To) {
+		return false
+	}
+	for d, v := range b.Dimensions {
+		if ov, ok := o.Dimensions[d]; ok && ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// AddBudget validates the budget and saves it, assigning it an ID if it has none.
+// The account must be a revenue or expense account and the budget must not overlap another one of it,
+// otherwise an error wrapping ErrBudgetOverlap is returned.
+func (l *Ledger) AddBudget(b *Budget) error {
+	a, err := l.storage.Account(b.Account)
+	if err != nil {
+		return err
+	}
+	if a.AccountType != AccountTypeRevenue && a.AccountType != AccountTypeExpense {
+		return fmt.Errorf("account %s is not a revenue or expense account", a.Name)
+	}
+	if !b.From.Before(b.To) {
+		return errors.New("budget period must end after it starts")
+	}
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	b.Dimensions = maps.Clone(b.Dimensions)
+	if err := l.storage.SaveBudget(*b); err != nil {
+		return fmt.Errorf("account %s from %s to %s: %w", a.Name, b.From.Format(time.DateOnly), b.To.Format(time.DateOnly), err)
+	}
+	return nil
+}
+
+// Budgets returns the budgets of the ledger in the order they were added.
+func (l *Ledger) Budgets() ([]Budget, error) {
+	return l.storage.Budgets()
+}
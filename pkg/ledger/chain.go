@@ -0,0 +1,143 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// ErrChainBroken is wrapped by every ChainError, to be used with errors.Is.
+var ErrChainBroken = errors.New("transaction hash chain is broken")
+
+// The first byte of the canonical encoding, it changes if the encoding ever does.
+const encodingVersion = 1
+
+// Hash is the SHA-256 hash of a link of the chain.
+type Hash [sha256.Size]byte
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%x", h[:])
+}
+
+// ChainLink links a posted transaction to the one posted before it.
+// The first transaction is linked to the zero hash.
+type ChainLink struct {
+	Transaction uuid.UUID
+	Prev        Hash
+	Hash        Hash // ChainHash of Prev and the transaction.
+}
+
+// MarshalBinary returns the canonical encoding of the transaction, the same for equal transactions.
+//
+// It has the version of the encoding, the Id, the Journal, the TransactionType, the Timestamp in Unix nanoseconds,
+// the entries in their order, with their dimensions sorted by name, their description and metadata sorted by key,
+// and the metadata sorted by key.
+// Integers are big-endian or varints and strings are prefixed by their length,
+// so no two transactions have the same encoding.
+func (t *Transaction) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 64+len(t.Entries)*26)
+	b = append(b, encodingVersion)
+	b = append(b, t.Id[:]...)
+	b = append(b, t.Journal[:]...)
+	b = appendString(b, string(t.TransactionType))
+	b = binary.BigEndian.AppendUint64(b, uint64(t.Timestamp.UnixNano()))
+
+	b = binary.AppendUvarint(b, uint64(len(t.Entries)))
+	for _, e := range t.Entries {
+		b = append(b, e.Account[:]...)
+		b = binary.AppendVarint(b, int64(e.Amount))
+		dims := make(map[string]string, len(e.Dimensions))
+		for d, v := range e.Dimensions {
+			dims[string(d)] = v
+		}
+		b = appendMap(b, dims)
+		b = appendString(b, e.Description)
+		b = appendMap(b, e.Metadata)
+	}
+	return appendMap(b, t.Metadata), nil
+}
+
+// appendMap appends the number of keys and the keys and values sorted by key.
+func appendMap(b []byte, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = appendString(b, k)
+		b = appendString(b, m[k])
+	}
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// ChainHash returns the hash that chains the transaction to the previous hash,
+// the SHA-256 of the previous hash followed by the canonical encoding of the transaction.
+func ChainHash(prev Hash, t *Transaction) Hash {
+	data, _ := t.MarshalBinary()
+	h := sha256.New()
+	h.Write(prev[:])
+	h.Write(data)
+	var sum Hash
+	h.Sum(sum[:0])
+	return sum
+}
+
+// ChainError reports the first link of the chain that does not match the posted transactions.
+type ChainError struct {
+	Index       int       // The position of the transaction in posting order.
+	Transaction uuid.UUID // The transaction of the broken link, nil if the transaction is missing.
+	Reason      string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("%s at transaction %d (%s): %s", ErrChainBroken, e.Index, e.Transaction, e.Reason)
+}
+
+func (e *ChainError) Unwrap() error {
+	return ErrChainBroken
+}
+
+// VerifyChain walks the posted transactions in posting order, recomputing the hash chain,
+// and returns a ChainError for the first link that does not match. It returns nil if the chain is intact.
+func (l *Ledger) VerifyChain() error {
+	transactions, err := l.storage.Transactions()
+	if err != nil {
+		return err
+	}
+	links, err := l.storage.Links()
+	if err != nil {
+		return err
+	}
+
+	var prev Hash
+	for i, t := range transactions {
+		if i >= len(links) {
+			return &ChainError{Index: i, Transaction: t.Id, Reason: "transaction has no link"}
+		}
+		link := links[i]
+		switch {
+		case link.Transaction != t.Id:
+			return &ChainError{Index: i, Transaction: t.Id, Reason: fmt.Sprintf("link is for transaction %s", link.Transaction)}
+		case link.Prev != prev:
+			return &ChainError{Index: i, Transaction: t.Id, Reason: "previous hash does not match"}
+		case link.Hash != ChainHash(prev, t):
+			return &ChainError{Index: i, Transaction: t.Id, Reason: "transaction was altered"}
+		}
+		prev = link.Hash
+	}
+	if len(links) > len(transactions) {
+		return &ChainError{Index: len(transactions), Transaction: links[len(transactions)].Transaction, Reason: "transaction is missing"}
+	}
+	return nil
+}
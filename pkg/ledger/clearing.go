@@ -0,0 +1,75 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrClearingNotFound       = errors.New("entry is not cleared") // Returned for an entry not cleared by any reconciliation.
+	ErrReconciliationFinished = errors.New("reconciliation is finished")
+)
+
+// Clearing records that a posted entry was matched to a bank statement line by a reconciliation,
+// see the reconcile package. An entry without a clearing is uncleared.
+//
+// The Version is incremented by the storage on every update, as in AccountBalance,
+// so an entry can only be cleared by one reconciliation.
+type Clearing struct {
+	Entry          EntryRef
+	Reconciliation uuid.UUID
+	Line           int       // The index of the statement line matched to the entry.
+	Reconciled     bool      // Set when the reconciliation is finished, the entry cannot be unmatched anymore.
+	Timestamp      time.Time // When the entry was matched, or reconciled.
+	Version        uint64
+}
+
+// Clearing returns the clearing of the entry, or ErrClearingNotFound if it is uncleared.
+func (l *Ledger) Clearing(ref EntryRef) (Clearing, error) {
+	return l.storage.Clearing(ref)
+}
+
+// Clearings returns the clearings of the reconciliation, ordered by statement line.
+func (l *Ledger) Clearings(reconciliation uuid.UUID) ([]Clearing, error) {
+	return l.storage.Clearings(reconciliation)
+}
+
+// SaveClearings saves all the clearings or none of them.
+// It fails with ErrVersionConflict if any of them was changed since it was read, a new one has version 0,
+// and with ErrReconciliationFinished if any of them is of a finished reconciliation.
+func (l *Ledger) SaveClearings(clearings []Clearing) error {
+	return l.storage.SaveClearings(clearings)
+}
+
+// DeleteClearing makes the entry uncleared again.
+// It fails with ErrVersionConflict if the clearing was changed since it was read,
+// and with ErrReconciliationFinished if its reconciliation is finished.
+func (l *Ledger) DeleteClearing(c Clearing) error {
+	return l.storage.DeleteClearing(c)
+}
+
+// FinishReconciliation marks the clearings of the reconciliation as reconciled and the reconciliation as finished
+// at the given time, all at once. A finished reconciliation cannot be changed, even if it has no clearings.
+func (l *Ledger) FinishReconciliation(id uuid.UUID, at time.Time) error {
+	if at.IsZero() {
+		return errors.New("reconciliation must be finished at some time")
+	}
+	return l.retry(func() error {
+		clearings, err := l.storage.Clearings(id)
+		if err != nil {
+			return err
+		}
+		for i := range clearings {
+			clearings[i].Reconciled = true
+			clearings[i].Timestamp = at
+		}
+		return l.storage.FinishReconciliation(id, at, clearings)
+	})
+}
+
+// ReconciliationFinished returns when the reconciliation was finished, the zero time if it is not.
+func (l *Ledger) ReconciliationFinished(id uuid.UUID) (time.Time, error) {
+	return l.storage.ReconciliationFinished(id)
+}
@@ -0,0 +1,156 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDimensionNotFound is returned for a dimension without a registered catalog.
+var ErrDimensionNotFound = errors.New("dimension not found")
+
+// Dimension is an axis of management reporting that entries can be tagged with, besides their account.
+type Dimension string
+
+const (
+	DimensionDepartment Dimension = "department"
+	DimensionProject    Dimension = "project"
+	DimensionCostCenter Dimension = "cost_center"
+)
+
+// Dimensions are the values of an entry for each dimension, such as {"department": "sales"}.
+type Dimensions map[Dimension]string
+
+// Matches returns true if the dimensions have every value of the filter.
+func (d Dimensions) Matches(filter Dimensions) bool {
+	for k, v := range filter {
+		if d[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns a comparable key of the values of the given dimensions.
+func (d Dimensions) key(dims []Dimension) string {
+	var b strings.Builder
+	for _, dim := range dims {
+		b.WriteString(d[dim])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// DimensionCatalog is the list of valid values of a dimension.
+// An entry can only use a dimension with a catalog, and only the values in it.
+type DimensionCatalog struct {
+	Dimension Dimension
+	Values    []string
+}
+
+// RegisterDimension registers or replaces the catalog of the dimension.
+// Removing a value does not change the posted entries that use it.
+func (l *Ledger) RegisterDimension(c DimensionCatalog) error {
+	if c.Dimension == "" {
+		return errors.New("dimension must have a name")
+	}
+	for _, v := range c.Values {
+		if v == "" {
+			return fmt.Errorf("dimension %s has an empty value", c.Dimension)
+		}
+	}
+	c.Values = slices.Clone(c.Values)
+	slices.Sort(c.Values)
+	c.Values = slices.Compact(c.Values)
+	return l.storage.SaveDimension(c)
+}
+
+// Dimension returns the catalog of the dimension.
+func (l *Ledger) Dimension(d Dimension) (DimensionCatalog, error) {
+	return l.storage.Dimension(d)
+}
+
+// validateDimensions checks the dimensions of the entry against the registered catalogs.
+func (l *Ledger) validateDimensions(e Entry) error {
+	for d, v := range e.Dimensions {
+		c, err := l.storage.Dimension(d)
+		if err != nil {
+			return fmt.Errorf("dimension %s: %w", d, err)
+		}
+		if _, ok := slices.BinarySearch(c.Values, v); !ok {
+			return fmt.Errorf("dimension %s has no value %q", d, v)
+		}
+	}
+	return nil
+}
+
+// DimensionQuery selects and groups the entries of DimensionBalances.
+type DimensionQuery struct {
+	Accounts []uuid.UUID // All the accounts if empty.
+	Filter   Dimensions  // Only the entries with all these values.
+	GroupBy  []Dimension // The balances are split by the values of these dimensions, an entry without one has "".
+	From     time.Time   // Inclusive, zero for no limit.
+	To       time.Time   // Exclusive, zero for no limit.
+}
+
+// DimensionBalance is the balance of an account for a combination of dimension values.
+type DimensionBalance struct {
+	Account    uuid.UUID
+	Dimensions Dimensions // The values of the GroupBy dimensions.
+	Balance    int        // The sum of the entries, with their sign.
+}
+
+// DimensionBalances returns the balances of the accounts for every combination of values
+// of the GroupBy dimensions, considering only the entries that match the filter.
+// They are ordered by account, in creation order, and then by dimension values.
+func (l *Ledger) DimensionBalances(q DimensionQuery) ([]DimensionBalance, error) {
+	accounts := q.Accounts
+	if len(accounts) == 0 {
+		all, err := l.storage.Accounts()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range all {
+			accounts = append(accounts, a.ID)
+		}
+	}
+
+	var balances []DimensionBalance
+	for _, id := range accounts {
+		entries, err := l.Entries(id, q.From, q.To)
+		if err != nil {
+			return nil, err
+		}
+
+		groups := make(map[string]*DimensionBalance)
+		for _, e := range entries {
+			if !e.Dimensions.Matches(q.Filter) {
+				continue
+			}
+			key := e.Dimensions.key(q.GroupBy)
+			b, ok := groups[key]
+			if !ok {
+				b = &DimensionBalance{Account: id, Dimensions: make(Dimensions, len(q.GroupBy))}
+				for _, d := range q.GroupBy {
+					b.Dimensions[d] = e.Dimensions[d]
+				}
+				groups[key] = b
+			}
+			b.Balance += e.Amount
+		}
+
+		keys := make([]string, 0, len(groups))
+		for key := range groups {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			balances = append(balances, *groups[key])
+		}
+	}
+	return balances, nil
+}
@@ -0,0 +1,54 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotPublished is returned when a transaction was committed but its event could not be published.
+var ErrNotPublished = errors.New("transaction posted but not published")
+
+// TransactionPosted is the event published for each committed transaction.
+type TransactionPosted struct {
+	Transaction *Transaction
+	PostedAt    time.Time
+}
+
+// Publisher receives the events of a Ledger.
+//
+// It is called after the transaction is committed, so it must not block for long.
+type Publisher interface {
+	Publish(e TransactionPosted) error
+}
+
+// publish sends the event of a committed transaction to the publisher, if any.
+func (l *Ledger) publish(t *Transaction) error {
+	if l.publisher == nil {
+		return nil
+	}
+	e := TransactionPosted{Transaction: cloneTransaction(t), PostedAt: time.Now()}
+	if err := l.publisher.Publish(e); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotPublished, err)
+	}
+	return nil
+}
+
+// OutboxMessage is an event waiting in the storage outbox to be delivered.
+type OutboxMessage struct {
+	ID        uuid.UUID
+	Event     TransactionPosted
+	CreatedAt time.Time
+	SentAt    time.Time // Zero while the message is pending.
+}
+
+func newOutboxMessage(t *Transaction) OutboxMessage {
+	now := time.Now()
+	return OutboxMessage{
+		ID:        uuid.New(),
+		Event:     TransactionPosted{Transaction: cloneTransaction(t), PostedAt: now},
+		CreatedAt: now,
+	}
+}
@@ -0,0 +1,242 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrHoldNotFound   = errors.New("hold not found")
+	ErrHoldNotPending = errors.New("hold is not pending")
+	ErrHoldExpired    = errors.New("hold is expired")
+)
+
+// MetadataHold is the metadata key that links a capture transaction to the hold it captured.
+const MetadataHold = "hold"
+
+// HoldStatus represents the state of a hold.
+type HoldStatus string
+
+const (
+	HoldPending  HoldStatus = "Pending"  // The funds are reserved.
+	HoldCaptured HoldStatus = "Captured" // The hold was settled by a posted transaction.
+	HoldVoided   HoldStatus = "Voided"   // The hold was cancelled.
+	HoldExpired  HoldStatus = "Expired"  // The hold was not captured in time.
+)
+
+// Hold is a pending transaction that reserves funds before the settlement.
+//
+// While pending, its entries are counted in the Pending balance of the accounts and the ones that decrease
+// an account reduce its Available balance, but the posted Balance is not changed.
+// A hold ends when it is captured (in full or in part), voided or expired.
+type Hold struct {
+	ID          uuid.UUID // The same as the Id of the pending transaction.
+	Transaction *Transaction
+	ExpiresAt   time.Time
+	Status      HoldStatus
+	Capture     uuid.UUID // The transaction that captured the hold.
+	Version     uint64    // Incremented by the storage on every update, as in AccountBalance.
+}
+
+// amounts returns the net amount of the hold for each account.
+func (h Hold) amounts() map[uuid.UUID]int {
+	amounts := make(map[uuid.UUID]int, len(h.Transaction.Entries))
+	for _, e := range h.Transaction.Entries {
+		amounts[e.Account] += e.Amount
+	}
+	return amounts
+}
+
+// PlaceHold validates the transaction as in Post and reserves its funds until it expires.
+//
+// The funds available in the accounts are checked against their balance limits,
+// so a hold is rejected if the account does not have enough available balance.
+func (l *Ledger) PlaceHold(t *Transaction, expiresAt time.Time) (Hold, error) {
+	if err := l.validate(t); err != nil {
+		return Hold{}, err
+	}
+	if !expiresAt.After(t.Timestamp) {
+		return Hold{}, errors.New("hold must expire after the transaction timestamp")
+	}
+
+	h := Hold{ID: t.Id, Transaction: cloneTransaction(t), ExpiresAt: expiresAt, Status: HoldPending}
+	err := l.retry(func() error {
+		// checked again after a conflict, the hold may have been placed concurrently
+		if _, err := l.storage.Hold(h.ID); err == nil {
+			return ErrDuplicateTransaction
+		}
+		c, err := l.prepare(nil, []Hold{h})
+		if err != nil {
+			return err
+		}
+		return l.storage.Commit(c)
+	})
+	if err != nil {
+		return Hold{}, err
+	}
+	return l.storage.Hold(h.ID)
+}
+
+// Hold returns the hold with the given id.
+func (l *Ledger) Hold(id uuid.UUID) (Hold, error) {
+	return l.storage.Hold(id)
+}
+
+// Capture settles a pending hold, posting a transaction with the given timestamp.
+//
+//   - With no entries the hold is captured in full.
+//   - Otherwise the entries are a partial capture: they must be balanced, use only accounts of the hold,
+//     with the same sign and at most the held amount. The rest of the hold is released.
+//   - A hold can only be captured once and not after it expires, whatever the timestamp of the capture.
+//
+// The capture transaction carries the metadata of the hold and its id in MetadataHold.
+func (l *Ledger) Capture(id uuid.UUID, at time.Time, entries []Entry) (*Transaction, error) {
+	var t *Transaction
+	err := l.retry(func() error {
+		h, err := l.pendingHold(id, true)
+		if err != nil {
+			return err
+		}
+
+		if t == nil {
+			if t, err = captureTransaction(h, at, entries); err != nil {
+				return err
+			}
+			if err := l.validate(t); err != nil {
+				return err
+			}
+		}
+
+		h.Status = HoldCaptured
+		h.Capture = t.Id
+		c, err := l.prepare([]*Transaction{t}, []Hold{h})
+		if err != nil {
+			return err
+		}
+		return l.storage.Commit(c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, l.publish(t)
+}
+
+// Void cancels a pending hold, releasing its funds.
+func (l *Ledger) Void(id uuid.UUID) error {
+	return l.release(id, HoldVoided)
+}
+
+// ExpireHolds releases the pending holds that expired at the given time and returns how many were expired.
+// It is meant to be called periodically, see MaintainHolds.
+// Until it is released, an expired hold cannot be captured but its funds stay reserved.
+func (l *Ledger) ExpireHolds(now time.Time) (int, error) {
+	holds, err := l.storage.Holds(HoldPending)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, h := range holds {
+		if h.ExpiresAt.After(now) {
+			continue
+		}
+		// a hold captured or voided in the meantime is not an error
+		switch err := l.release(h.ID, HoldExpired); {
+		case err == nil:
+			expired++
+		case !errors.Is(err, ErrHoldNotPending):
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// MaintainHolds expires the holds every given duration until the context is done,
+// passing how many holds were expired, and the error if any, to the given function.
+// MaintainHolds only returns the context error.
+func (l *Ledger) MaintainHolds(ctx context.Context, every time.Duration, report func(int, error)) error {
+	for {
+		report(l.ExpireHolds(time.Now()))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(every):
+		}
+	}
+}
+
+// release ends a pending hold with the given status without posting anything.
+func (l *Ledger) release(id uuid.UUID, status HoldStatus) error {
+	return l.retry(func() error {
+		h, err := l.pendingHold(id, false)
+		if err != nil {
+			return err
+		}
+		h.Status = status
+		c, err := l.prepare(nil, []Hold{h})
+		if err != nil {
+			return err
+		}
+		return l.storage.Commit(c)
+	})
+}
+
+// pendingHold returns the hold if it is still pending, and if asked not expired by now.
+func (l *Ledger) pendingHold(id uuid.UUID, unexpired bool) (Hold, error) {
+	h, err := l.storage.Hold(id)
+	if err != nil {
+		return Hold{}, err
+	}
+	if h.Status != HoldPending {
+		return Hold{}, fmt.Errorf("%w: %s", ErrHoldNotPending, h.Status)
+	}
+	if unexpired && !time.Now().Before(h.ExpiresAt) {
+		return Hold{}, ErrHoldExpired
+	}
+	return h, nil
+}
+
+// captureTransaction builds the transaction that captures the hold with the given entries.
+func captureTransaction(h Hold, at time.Time, entries []Entry) (*Transaction, error) {
+	if entries == nil {
+		entries = h.Transaction.Entries
+	} else {
+		held := h.amounts()
+		captured := make(map[uuid.UUID]int, len(entries))
+		for _, e := range entries {
+			captured[e.Account] += e.Amount
+		}
+		for id, amount := range captured {
+			limit, ok := held[id]
+			if !ok {
+				return nil, fmt.Errorf("account %s is not part of the hold", id)
+			}
+			if amount*limit < 0 || abs(amount) > abs(limit) {
+				return nil, fmt.Errorf("capture of %d on account %s exceeds the held amount %d", amount, id, limit)
+			}
+		}
+	}
+
+	t := NewRegularTransaction(at)
+	t.Journal = h.Transaction.Journal
+	t.AddEntries(entries)
+	t.Metadata = maps.Clone(h.Transaction.Metadata)
+	if t.Metadata == nil {
+		t.Metadata = make(map[string]string, 1)
+	}
+	t.Metadata[MetadataHold] = h.ID.String()
+	return t, nil
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,247 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrAssertionFailed is wrapped by every AssertionError, to be used with errors.Is.
+var ErrAssertionFailed = errors.New("balance assertion failed")
+
+// BalanceAssertion declares the balance an account must have at a date, as the balance assertions of Beancount.
+// The Amount has the sign of the entries and is compared with the balance of the entries before the Date,
+// see [Ledger.BalanceAt].
+type BalanceAssertion struct {
+	ID      uuid.UUID
+	Account uuid.UUID
+	Date    time.Time
+	Amount  int
+}
+
+// AssertionError is returned when the balance of an account differs from a balance assertion.
+type AssertionError struct {
+	Assertion BalanceAssertion
+	Actual    int
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("account %s should have balance %d at %s but has %d", e.Assertion.Account, e.Assertion.Amount, e.Assertion.Date.Format(time.RFC3339), e.Actual)
+}
+
+func (e *AssertionError) Unwrap() error {
+	return ErrAssertionFailed
+}
+
+// AssertBalance checks the balance assertion and keeps it, to be checked again by CheckIntegrity,
+// since a transaction posted later with an earlier timestamp can break it.
+// If the assertion has no ID a new one is assigned, and if it does not hold an *AssertionError is returned.
+func (l *Ledger) AssertBalance(a *BalanceAssertion) error {
+	if a.Date.IsZero() {
+		return errors.New("balance assertion has no date")
+	}
+	if err := l.checkAssertion(*a); err != nil {
+		return err
+	}
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return l.storage.SaveAssertion(*a)
+}
+
+// Assertions returns the balance assertions of the ledger, in the order they were declared.
+func (l *Ledger) Assertions() ([]BalanceAssertion, error) {
+	return l.storage.Assertions()
+}
+
+func (l *Ledger) checkAssertion(a BalanceAssertion) error {
+	b, err := l.BalanceAt(a.Account, a.Date)
+	if err != nil {
+		return err
+	}
+	if b.Balance != a.Amount {
+		return &AssertionError{Assertion: a, Actual: b.Balance}
+	}
+	return nil
+}
+
+// DiscrepancyKind is the kind of problem found by CheckIntegrity.
+type DiscrepancyKind string
+
+const (
+	DiscrepancyBalance    DiscrepancyKind = "Balance"    // The stored posted balance differs from the sum of the entries.
+	DiscrepancyPending    DiscrepancyKind = "Pending"    // The stored pending balance differs from the pending holds.
+	DiscrepancyAvailable  DiscrepancyKind = "Available"  // The stored available balance differs from the entries and holds.
+	DiscrepancyUnbalanced DiscrepancyKind = "Unbalanced" // A stored transaction is not balanced anymore.
+	DiscrepancyAssertion  DiscrepancyKind = "Assertion"  // A balance assertion does not hold.
+)
+
+// Discrepancy is a problem found by CheckIntegrity.
+// Only the ids that apply to its Kind are set, and Expected is the value recomputed or asserted.
+type Discrepancy struct {
+	Kind        DiscrepancyKind
+	Account     uuid.UUID
+	Transaction uuid.UUID
+	Assertion   uuid.UUID
+	Expected    int
+	Actual      int
+	Message     string
+}
+
+// IntegrityReport is the result of CheckIntegrity.
+//
+// The accounts changed by a posting while they were checked are reported as Skipped instead of compared,
+// so a check running next to the postings does not report false discrepancies.
+type IntegrityReport struct {
+	CheckedAt     time.Time
+	Accounts      int
+	Transactions  int
+	Assertions    int
+	Skipped       []uuid.UUID
+	Discrepancies []Discrepancy
+}
+
+// OK returns true if no discrepancy was found.
+func (r IntegrityReport) OK() bool {
+	return len(r.Discrepancies) == 0
+}
+
+// CheckIntegrity verifies the stored data of the ledger and reports what does not add up:
+//   - Every transaction is still balanced.
+//   - The posted, pending and available balances of every account are recomputed from the entries and the pending holds.
+//   - Every balance assertion still holds.
+func (l *Ledger) CheckIntegrity() (IntegrityReport, error) {
+	r := IntegrityReport{CheckedAt: time.Now()}
+	accounts, err := l.storage.Accounts()
+	if err != nil {
+		return r, err
+	}
+	r.Accounts = len(accounts)
+
+	// the versions are read before and after the entries, an account changed in between is skipped
+	versions, err := l.balanceVersions(accounts)
+	if err != nil {
+		return r, err
+	}
+
+	transactions, err := l.storage.Transactions()
+	if err != nil {
+		return r, err
+	}
+	r.Transactions = len(transactions)
+	posted := make(map[uuid.UUID]int)
+	for _, t := range transactions {
+		if _, err := t.IsBalanced(); err != nil {
+			r.Discrepancies = append(r.Discrepancies, Discrepancy{Kind: DiscrepancyUnbalanced, Transaction: t.Id, Message: err.Error()})
+		}
+		for _, e := range t.Entries {
+			posted[e.Account] += e.Amount
+		}
+	}
+
+	holds, err := l.storage.Holds(HoldPending)
+	if err != nil {
+		return r, err
+	}
+	pending := make(map[uuid.UUID]int)
+	decreases := make(map[uuid.UUID]int)
+	signs := make(map[uuid.UUID]int, len(accounts))
+	for _, a := range accounts {
+		signs[a.ID] = a.NormalSign()
+	}
+	for _, h := range holds {
+		for id, amount := range h.amounts() {
+			pending[id] += amount
+			if amount*signs[id] < 0 {
+				decreases[id] += amount
+			}
+		}
+	}
+
+	for _, a := range accounts {
+		shards, err := l.storage.Balances(a.ID)
+		if err != nil {
+			return r, err
+		}
+		b := sumShards(a, shards)
+		if b.Version != versions[a.ID] {
+			r.Skipped = append(r.Skipped, a.ID)
+			continue
+		}
+
+		for _, c := range []struct {
+			kind             DiscrepancyKind
+			name             string
+			expected, actual int
+		}{
+			{DiscrepancyBalance, "posted", posted[a.ID], b.Balance},
+			{DiscrepancyPending, "pending", pending[a.ID], b.Pending},
+			{DiscrepancyAvailable, "available", posted[a.ID] + decreases[a.ID], b.Available},
+		} {
+			if c.expected != c.actual {
+				r.Discrepancies = append(r.Discrepancies, Discrepancy{
+					Kind:     c.kind,
+					Account:  a.ID,
+					Expected: c.expected,
+					Actual:   c.actual,
+					Message:  fmt.Sprintf("account %s (%s) has %s balance %d but should have %d", a.Name, a.ID, c.name, c.actual, c.expected),
+				})
+			}
+		}
+	}
+
+	assertions, err := l.storage.Assertions()
+	if err != nil {
+		return r, err
+	}
+	r.Assertions = len(assertions)
+	for _, a := range assertions {
+		err := l.checkAssertion(a)
+		var assertionErr *AssertionError
+		switch {
+		case errors.As(err, &assertionErr):
+			r.Discrepancies = append(r.Discrepancies, Discrepancy{
+				Kind:      DiscrepancyAssertion,
+				Account:   a.Account,
+				Assertion: a.ID,
+				Expected:  a.Amount,
+				Actual:    assertionErr.Actual,
+				Message:   err.Error(),
+			})
+		case err != nil:
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// balanceVersions returns the version of the balance of every account.
+func (l *Ledger) balanceVersions(accounts []Account) (map[uuid.UUID]uint64, error) {
+	versions := make(map[uuid.UUID]uint64, len(accounts))
+	for _, a := range accounts {
+		shards, err := l.storage.Balances(a.ID)
+		if err != nil {
+			return nil, err
+		}
+		versions[a.ID] = sumShards(a, shards).Version
+	}
+	return versions, nil
+}
+
+// MonitorIntegrity runs CheckIntegrity every given duration until the context is done,
+// passing every report, or the error that stopped the check, to the given function.
+// MonitorIntegrity only returns the context error.
+func (l *Ledger) MonitorIntegrity(ctx context.Context, every time.Duration, report func(IntegrityReport, error)) error {
+	for {
+		report(l.CheckIntegrity())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(every):
+		}
+	}
+}
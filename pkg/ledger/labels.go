@@ -0,0 +1,138 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateAccountCode is returned when an account code is already used by another account.
+var ErrDuplicateAccountCode = errors.New("account code already used")
+
+// normalizeLabels checks the code and the tags of the account, and sorts and dedupes its tags.
+func (a *Account) normalizeLabels() error {
+	if a.Code != strings.TrimSpace(a.Code) || strings.ContainsAny(a.Code, " \t\n") {
+		return fmt.Errorf("account code %q cannot have spaces", a.Code)
+	}
+	for _, tag := range a.Tags {
+		if tag == "" || tag != strings.TrimSpace(tag) {
+			return fmt.Errorf("invalid account tag %q", tag)
+		}
+	}
+	a.Tags = slices.Clone(a.Tags)
+	slices.Sort(a.Tags)
+	a.Tags = slices.Compact(a.Tags)
+	return nil
+}
+
+// HasTag returns true if the account has the tag.
+func (a Account) HasTag(tag string) bool {
+	_, ok := slices.BinarySearch(a.Tags, tag)
+	return ok
+}
+
+// cloneAccount returns a copy of the account that shares nothing with the original.
+func cloneAccount(a Account) Account {
+	a.Tags = slices.Clone(a.Tags)
+	a.Metadata = maps.Clone(a.Metadata)
+	return a
+}
+
+// AccountByCode returns the account with the given code.
+func (l *Ledger) AccountByCode(code string) (Account, error) {
+	return l.storage.AccountByCode(code)
+}
+
+// AccountsByTag returns the accounts with the given tag, in creation order.
+func (l *Ledger) AccountsByTag(tag string) ([]Account, error) {
+	accounts, err := l.storage.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(accounts, func(a Account) bool { return !a.HasTag(tag) }), nil
+}
+
+// SetAccountCode changes the code of the account, it must not be used by any other account.
+// An empty code removes it.
+func (l *Ledger) SetAccountCode(id uuid.UUID, code string) error {
+	return l.updateAccount(id, AuditSetAccountCode, func(a *Account) error {
+		a.Code = code
+		return nil
+	})
+}
+
+// TagAccount adds the tags to the account.
+func (l *Ledger) TagAccount(id uuid.UUID, tags ...string) error {
+	return l.updateAccount(id, AuditTagAccount, func(a *Account) error {
+		a.Tags = append(a.Tags, tags...)
+		return nil
+	})
+}
+
+// UntagAccount removes the tags from the account.
+func (l *Ledger) UntagAccount(id uuid.UUID, tags ...string) error {
+	return l.updateAccount(id, AuditTagAccount, func(a *Account) error {
+		a.Tags = slices.DeleteFunc(a.Tags, func(tag string) bool { return slices.Contains(tags, tag) })
+		return nil
+	})
+}
+
+// SetAccountMetadata sets the metadata of the account, an empty value removes the key.
+func (l *Ledger) SetAccountMetadata(id uuid.UUID, metadata map[string]string) error {
+	return l.updateAccount(id, AuditSetAccountMetadata, func(a *Account) error {
+		if a.Metadata == nil {
+			a.Metadata = make(map[string]string, len(metadata))
+		}
+		for k, v := range metadata {
+			if v == "" {
+				delete(a.Metadata, k)
+			} else {
+				a.Metadata[k] = v
+			}
+		}
+		return nil
+	})
+}
+
+// ClassifyAccount changes the sub-type of the account, an empty sub-type removes it.
+// Changing whether the account is contra changes its natural balance and how its limits apply.
+func (l *Ledger) ClassifyAccount(id uuid.UUID, subType AccountSubType) error {
+	return l.updateAccount(id, AuditClassifyAccount, func(a *Account) error {
+		a.SubType = subType
+		return nil
+	})
+}
+
+func (a Account) validateSubType() error {
+	if a.SubType != "" && !slices.Contains(subTypes[a.AccountType], a.SubType) {
+		return fmt.Errorf("sub-type %s is not a sub-type of %s", a.SubType, a.AccountType)
+	}
+	return nil
+}
+
+// updateAccount applies the change to the account and saves it with its audit record.
+// The account is read again and the change applied again if the account was changed in the meantime,
+// so the change never writes back a stale status or label.
+func (l *Ledger) updateAccount(id uuid.UUID, action AuditAction, change func(a *Account) error) error {
+	return l.retry(func() error {
+		a, err := l.storage.Account(id)
+		if err != nil {
+			return err
+		}
+		before := cloneAccount(a)
+		if err := change(&a); err != nil {
+			return err
+		}
+		if err := a.validateSubType(); err != nil {
+			return err
+		}
+		if err := a.normalizeLabels(); err != nil {
+			return err
+		}
+		return l.saveAccount(action, &before, a)
+	})
+}
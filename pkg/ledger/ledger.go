@@ -2,3 +2,234 @@
 // It is responsible for managing transactions and account balances.
 // It is also responsible for storing transactions in a storage engine.
 package ledger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ledger is the entry point to post transactions and query account balances.
+//
+// It validates the transactions and keeps the account balances up to date,
+// the persistence is delegated to a Storage engine.
+type Ledger struct {
+	storage    Storage
+	publisher  Publisher
+	outbox     bool
+	maxRetries int
+	actor      string // Recorded in the audit log, see As.
+	reason     string
+
+	snapshotInterval time.Duration
+}
+
+// Option configures optional behaviour of a Ledger.
+type Option func(*Ledger)
+
+// WithPublisher makes the ledger publish a TransactionPosted event for each committed transaction.
+func WithPublisher(p Publisher) Option {
+	return func(l *Ledger) {
+		l.publisher = p
+	}
+}
+
+// WithOutbox makes the ledger write a TransactionPosted event to the storage outbox in the same commit
+// as the transaction, so no event is lost if the process crashes after posting.
+// The events are delivered later by a relay, see the outbox package.
+func WithOutbox() Option {
+	return func(l *Ledger) {
+		l.outbox = true
+	}
+}
+
+// DefaultMaxRetries is how many times a posting is retried after a concurrent update, unless WithMaxRetries is used.
+const DefaultMaxRetries = 100
+
+// WithMaxRetries sets how many times a posting is retried when it conflicts with a concurrent update.
+func WithMaxRetries(n int) Option {
+	return func(l *Ledger) {
+		l.maxRetries = n
+	}
+}
+
+// New creates a new ledger backed by the given storage engine.
+func New(storage Storage, options ...Option) *Ledger {
+	l := &Ledger{storage: storage, maxRetries: DefaultMaxRetries, snapshotInterval: DefaultSnapshotInterval}
+	for _, option := range options {
+		option(l)
+	}
+	return l
+}
+
+// CreateAccount registers a new account in the ledger.
+//
+//   - If the account has no ID, a new one is assigned.
+//   - The account is created active.
+//   - The Code, if any, must not be used by another account.
+//   - A child account must have the same AccountType as its parent, its SubType can differ.
+func (l *Ledger) CreateAccount(a *Account) error {
+	if a.Name == "" {
+		return errors.New("account must have a name")
+	}
+	if !a.AccountType.IsValid() {
+		return fmt.Errorf("invalid account type %q", a.AccountType)
+	}
+	if err := a.validateSubType(); err != nil {
+		return err
+	}
+	if a.Shards < 0 {
+		return errors.New("account shards cannot be negative")
+	}
+	if err := a.Limits.validate(); err != nil {
+		return err
+	}
+	if !a.Status.IsActive() {
+		return fmt.Errorf("account must be created active, not %s", a.Status)
+	}
+	if err := a.normalizeLabels(); err != nil {
+		return err
+	}
+	if a.ParentID != uuid.Nil {
+		parent, err := l.storage.Account(a.ParentID)
+		if err != nil {
+			return fmt.Errorf("parent account: %w", err)
+		}
+		if parent.AccountType != a.AccountType {
+			return fmt.Errorf("account type %s differs from parent account type %s", a.AccountType, parent.AccountType)
+		}
+	}
+
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	a.Status = AccountStatusActive
+	a.Version = 0
+	// the storage only saves an account with version 0 if it does not exist yet
+	if err := l.saveAccount(AuditCreateAccount, nil, *a); errors.Is(err, ErrVersionConflict) {
+		return ErrDuplicateAccount
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RenameAccount changes the name of the account.
+func (l *Ledger) RenameAccount(id uuid.UUID, name string) error {
+	if name == "" {
+		return errors.New("account must have a name")
+	}
+	return l.updateAccount(id, AuditRenameAccount, func(a *Account) error {
+		a.Name = name
+		return nil
+	})
+}
+
+// MoveAccount changes the parent of the account, a nil parent makes it a root account.
+// The new parent must have the same AccountType and cannot be the account itself or one of its descendants.
+func (l *Ledger) MoveAccount(id, parentID uuid.UUID) error {
+	return l.updateAccount(id, AuditMoveAccount, func(a *Account) error {
+		for p := parentID; p != uuid.Nil; {
+			if p == id {
+				return errors.New("account cannot be moved under itself")
+			}
+			parent, err := l.storage.Account(p)
+			if err != nil {
+				return fmt.Errorf("parent account: %w", err)
+			}
+			if parent.AccountType != a.AccountType {
+				return fmt.Errorf("account type %s differs from parent account type %s", a.AccountType, parent.AccountType)
+			}
+			p = parent.ParentID
+		}
+		a.ParentID = parentID
+		return nil
+	})
+}
+
+// SplitAccount changes the number of balance shards of the account.
+//
+// It can be done at any time, the balances already in the existing shards are kept
+// and still summed by the reads even if the number of shards is reduced.
+func (l *Ledger) SplitAccount(id uuid.UUID, shards int) error {
+	if shards < 1 {
+		return errors.New("account must have at least one shard")
+	}
+	return l.updateAccount(id, AuditSplitAccount, func(a *Account) error {
+		a.Shards = shards
+		return nil
+	})
+}
+
+// Account returns the account with the given id.
+func (l *Ledger) Account(id uuid.UUID) (Account, error) {
+	return l.storage.Account(id)
+}
+
+// Accounts returns all the accounts of the ledger.
+func (l *Ledger) Accounts() ([]Account, error) {
+	return l.storage.Accounts()
+}
+
+// Transaction returns the posted transaction with the given id.
+func (l *Ledger) Transaction(id uuid.UUID) (*Transaction, error) {
+	return l.storage.Transaction(id)
+}
+
+// Balance returns the current balance of the account, summing all its shards.
+func (l *Ledger) Balance(id uuid.UUID) (AccountBalance, error) {
+	a, err := l.storage.Account(id)
+	if err != nil {
+		return AccountBalance{}, err
+	}
+	shards, err := l.storage.Balances(id)
+	if err != nil {
+		return AccountBalance{}, err
+	}
+	return sumShards(a, shards), nil
+}
+
+// sumShards returns the balance of the account from the balances of its shards.
+func sumShards(a Account, shards []AccountBalance) AccountBalance {
+	b := AccountBalance{AccountID: a.ID, AccountType: a.AccountType, SubType: a.SubType}
+	for _, shard := range shards {
+		b.Balance += shard.Balance
+		b.Pending += shard.Pending
+		b.Available += shard.Available
+		b.Version += shard.Version
+		if shard.Timestamp.After(b.Timestamp) {
+			b.Timestamp = shard.Timestamp
+		}
+	}
+	return b
+}
+
+// BalanceAt returns the balance of the account considering only the entries before the given time.
+// It starts from the latest balance snapshot before that time, if any, see RefreshSnapshots.
+func (l *Ledger) BalanceAt(id uuid.UUID, at time.Time) (AccountBalance, error) {
+	a, err := l.storage.Account(id)
+	if err != nil {
+		return AccountBalance{}, err
+	}
+	balance, err := l.balanceBefore(id, at)
+	if err != nil {
+		return AccountBalance{}, err
+	}
+	return AccountBalance{AccountID: a.ID, AccountType: a.AccountType, SubType: a.SubType, Balance: balance, Timestamp: at}, nil
+}
+
+// Entries returns the posted entries of the account with a timestamp in the range [from, to).
+// A zero from or to leaves the range open on that side.
+func (l *Ledger) Entries(account uuid.UUID, from, to time.Time) ([]PostedEntry, error) {
+	if _, err := l.storage.Account(account); err != nil {
+		return nil, err
+	}
+	return l.storage.Entries(account, from, to)
+}
+
+// SearchEntries returns the posted entries with the given value in their metadata, in posting order.
+func (l *Ledger) SearchEntries(key, value string) ([]PostedEntry, error) {
+	return l.storage.EntriesByMetadata(key, value)
+}
@@ -0,0 +1,1427 @@
+package ledger_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+func Test_Ledger(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	now := time.Now()
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	rent := &ledger.Account{Name: "Rent", AccountType: ledger.AccountTypeExpense}
+	for _, a := range []*ledger.Account{bank, rent} {
+		if err := l.CreateAccount(a); err != nil {
+			t.Fatalf("unexpected error creating account: %v", err)
+		}
+	}
+
+	// test that a child account must have the same type as its parent
+	child := &ledger.Account{Name: "Office", ParentID: rent.ID, AccountType: ledger.AccountTypeAsset}
+	if err := l.CreateAccount(child); err == nil {
+		t.Error("child account with a different type should be rejected")
+	}
+
+	// test posting a balanced transaction
+	transaction := ledger.NewTransaction(now.Add(-time.Hour))
+	transaction.AddEntries([]ledger.Entry{
+		{Account: bank.ID, Amount: -100},
+		{Account: rent.ID, Amount: 100},
+	})
+	if err := l.Post(transaction); err != nil {
+		t.Fatalf("unexpected error posting: %v", err)
+	}
+	if transaction.Id == uuid.Nil {
+		t.Error("posted transaction should receive an id")
+	}
+
+	// test that the same transaction cannot be posted twice
+	if err := l.Post(transaction); !errors.Is(err, ledger.ErrDuplicateTransaction) {
+		t.Errorf("posting twice should return ErrDuplicateTransaction but got %v", err)
+	}
+
+	// test that unbalanced transactions and unknown accounts are rejected
+	unbalanced := ledger.NewTransaction(now)
+	unbalanced.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: -100}, {Account: rent.ID, Amount: 99}})
+	if err := l.Post(unbalanced); err == nil {
+		t.Error("unbalanced transaction should be rejected")
+	}
+	unknown := ledger.NewTransaction(now)
+	unknown.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: -100}, {Account: uuid.New(), Amount: 100}})
+	if err := l.Post(unknown); !errors.Is(err, ledger.ErrAccountNotFound) {
+		t.Errorf("transaction with unknown account should return ErrAccountNotFound but got %v", err)
+	}
+
+	// test the balances
+	second := ledger.NewTransaction(now)
+	second.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: -50}, {Account: rent.ID, Amount: 50}})
+	if err := l.Post(second); err != nil {
+		t.Fatalf("unexpected error posting: %v", err)
+	}
+	if b, _ := l.Balance(bank.ID); b.Balance != -150 || b.AccountType != ledger.AccountTypeAsset {
+		t.Errorf("bank balance should be -150 but got %d", b.Balance)
+	}
+	if b, _ := l.BalanceAt(rent.ID, now); b.Balance != 100 {
+		t.Errorf("rent balance before now should be 100 but got %d", b.Balance)
+	}
+
+	// test the stored transaction cannot be changed through the posted one
+	second.Entries[0].Amount = -1
+	if stored, _ := l.Transaction(second.Id); stored.Entries[0].Amount != -50 {
+		t.Error("stored transaction should not share entries with the posted one")
+	}
+
+	if entries, _ := l.Entries(rent.ID, now, time.Time{}); len(entries) != 1 || entries[0].Transaction != second.Id {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+func Test_ConcurrentPosting(t *testing.T) {
+
+	storage := ledger.NewMemoryStorage()
+	l := ledger.New(storage)
+	now := time.Now()
+
+	// a few hot accounts receive transfers from many workers at the same time
+	accounts := make([]*ledger.Account, 4)
+	for i := range accounts {
+		accounts[i] = &ledger.Account{Name: "Hot", AccountType: ledger.AccountTypeAsset}
+		l.CreateAccount(accounts[i])
+	}
+
+	const workers, postings = 8, 200
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*postings)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < postings; i++ {
+				from, to := accounts[(w+i)%len(accounts)], accounts[(w+i+1)%len(accounts)]
+				tx := ledger.NewTransaction(now)
+				tx.AddEntries([]ledger.Entry{{Account: from.ID, Amount: -(i + 1)}, {Account: to.ID, Amount: i + 1}})
+				if err := l.Post(tx); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error posting concurrently: %v", err)
+	}
+
+	// no update can be lost: every balance must match the sum of its entries and all balances must add up to zero
+	transactions, _ := storage.Transactions()
+	if len(transactions) != workers*postings {
+		t.Errorf("should have %d transactions but got %d", workers*postings, len(transactions))
+	}
+	var total int
+	for _, a := range accounts {
+		b, _ := l.Balance(a.ID)
+		entries, _ := l.Entries(a.ID, time.Time{}, time.Time{})
+		var sum int
+		for _, e := range entries {
+			sum += e.Amount
+		}
+		if b.Balance != sum {
+			t.Errorf("account balance %d differs from the sum of its entries %d", b.Balance, sum)
+		}
+		if b.Version != uint64(len(entries)) {
+			t.Errorf("account version should be %d but got %d", len(entries), b.Version)
+		}
+		total += b.Balance
+	}
+	if total != 0 {
+		t.Errorf("balances should add up to 0 but got %d", total)
+	}
+
+	// a commit with a stale version is rejected by the storage
+	stale, _ := storage.Balance(accounts[0].ID, 0)
+	stale.Version--
+	if err := storage.Commit(ledger.Commit{Balances: []ledger.AccountBalance{stale}}); !errors.Is(err, ledger.ErrVersionConflict) {
+		t.Errorf("stale commit should return ErrVersionConflict but got %v", err)
+	}
+}
+
+func Test_Sharding(t *testing.T) {
+
+	storage := ledger.NewMemoryStorage()
+	l := ledger.New(storage)
+	now := time.Now()
+
+	fees := &ledger.Account{Name: "Fees", AccountType: ledger.AccountTypeRevenue, Shards: 8}
+	l.CreateAccount(fees)
+	customers := make([]*ledger.Account, 16)
+	for i := range customers {
+		customers[i] = &ledger.Account{Name: "Customer", AccountType: ledger.AccountTypeAsset}
+		l.CreateAccount(customers[i])
+	}
+
+	// every customer pays a fee at the same time
+	var wg sync.WaitGroup
+	for _, c := range customers {
+		wg.Add(1)
+		go func(c *ledger.Account) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				tx := ledger.NewTransaction(now)
+				tx.AddEntries([]ledger.Entry{{Account: c.ID, Amount: 3}, {Account: fees.ID, Amount: -3}})
+				if err := l.Post(tx); err != nil {
+					t.Errorf("unexpected error posting: %v", err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	// the postings are spread across the shards and the reads sum them
+	shards, _ := storage.Balances(fees.ID)
+	if len(shards) < 2 || len(shards) > 8 {
+		t.Errorf("postings should be spread across the 8 shards but got %d shards", len(shards))
+	}
+	if b, _ := l.Balance(fees.ID); b.Balance != -3*50*len(customers) || b.Version != uint64(50*len(customers)) {
+		t.Errorf("fees balance should be %d but got %d (version %d)", -3*50*len(customers), b.Balance, b.Version)
+	}
+
+	// reducing the shards keeps the balance of the shards no longer written
+	if err := l.SplitAccount(fees.ID, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tx := ledger.NewTransaction(now)
+	tx.AddEntries([]ledger.Entry{{Account: customers[0].ID, Amount: 1}, {Account: fees.ID, Amount: -1}})
+	l.Post(tx)
+	if b, _ := l.Balance(fees.ID); b.Balance != -3*50*len(customers)-1 {
+		t.Errorf("fees balance should be %d but got %d", -3*50*len(customers)-1, b.Balance)
+	}
+}
+
+func Test_PostBatch(t *testing.T) {
+
+	storage := ledger.NewMemoryStorage()
+	l := ledger.New(storage)
+	now := time.Now()
+
+	payroll := &ledger.Account{Name: "Payroll", AccountType: ledger.AccountTypeExpense}
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(payroll)
+	l.CreateAccount(bank)
+
+	batch := make([]*ledger.Transaction, 100)
+	for i := range batch {
+		batch[i] = ledger.NewTransaction(now)
+		batch[i].AddEntries([]ledger.Entry{{Account: payroll.ID, Amount: 10}, {Account: bank.ID, Amount: -10}})
+	}
+
+	// one invalid transaction in the middle rejects the whole batch
+	batch[40].Entries[0].Amount = 11
+	batch[70].Entries[1].Account = uuid.New()
+	err := l.PostBatch(batch)
+	var batchErr *ledger.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("invalid batch should return a BatchError but got %v", err)
+	}
+	if len(batchErr.Failures) != 2 || batchErr.Failures[0].Index != 40 || batchErr.Failures[1].Index != 70 {
+		t.Errorf("unexpected failures %+v", batchErr.Failures)
+	}
+	if !errors.Is(err, ledger.ErrAccountNotFound) {
+		t.Error("batch error should wrap the errors of the failed transactions")
+	}
+	if transactions, _ := storage.Transactions(); len(transactions) != 0 {
+		t.Errorf("nothing should be posted but got %d transactions", len(transactions))
+	}
+
+	// a valid batch is committed at once, with a single balance update per account
+	batch[40].Entries[0].Amount = 10
+	batch[70].Entries[1].Account = bank.ID
+	if err := l.PostBatch(batch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if transactions, _ := storage.Transactions(); len(transactions) != 100 {
+		t.Errorf("should post 100 transactions but got %d", len(transactions))
+	}
+	if b, _ := l.Balance(bank.ID); b.Balance != -1000 || b.Version != 1 {
+		t.Errorf("bank balance should be -1000 in a single update but got %d (version %d)", b.Balance, b.Version)
+	}
+
+	// a transaction repeated in the batch or already posted is rejected
+	again := ledger.NewTransaction(now)
+	again.AddEntries([]ledger.Entry{{Account: payroll.ID, Amount: 10}, {Account: bank.ID, Amount: -10}})
+	err = l.PostBatch([]*ledger.Transaction{again, batch[0], again})
+	if !errors.As(err, &batchErr) || len(batchErr.Failures) != 2 || !errors.Is(err, ledger.ErrDuplicateTransaction) {
+		t.Errorf("duplicated transactions should be rejected but got %v", err)
+	}
+
+	// the limit failure reports the transaction that takes the account beyond its limit
+	zero := 0
+	wallet := &ledger.Account{Name: "Wallet", AccountType: ledger.AccountTypeLiability, Limits: ledger.BalanceLimits{Min: &zero}}
+	l.CreateAccount(wallet)
+	limited := make([]*ledger.Transaction, 4)
+	for i, amount := range []int{-100, 60, 30, 60} {
+		limited[i] = ledger.NewTransaction(now)
+		limited[i].AddEntries([]ledger.Entry{{Account: wallet.ID, Amount: amount}, {Account: bank.ID, Amount: -amount}})
+	}
+	err = l.PostBatch(limited)
+	var limitErr *ledger.BalanceLimitError
+	if !errors.As(err, &batchErr) || len(batchErr.Failures) != 1 || batchErr.Failures[0].Index != 3 || !errors.As(err, &limitErr) || limitErr.Shortfall != 50 {
+		t.Errorf("limit failure should report transaction 3 but got %v", err)
+	}
+
+	// the rejected transactions are not given an Id
+	for i, tx := range limited {
+		if tx.Id != uuid.Nil {
+			t.Errorf("rejected transaction %d should have no Id but got %s", i, tx.Id)
+		}
+	}
+	limited[3].Entries[0].Amount, limited[3].Entries[1].Amount = 10, -10
+	if err := l.PostBatch(limited); err != nil || limited[3].Id == uuid.Nil {
+		t.Errorf("posted transactions should have an Id (%v)", err)
+	}
+}
+
+func Test_BalanceLimits(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	now := time.Now()
+	zero := 0
+
+	// the wallet is a liability of the business towards the customer and must never be negative
+	wallet := &ledger.Account{Name: "Wallet", AccountType: ledger.AccountTypeLiability, Shards: 4, Limits: ledger.BalanceLimits{Min: &zero}}
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(wallet)
+	l.CreateAccount(bank)
+
+	deposit := ledger.NewTransaction(now)
+	deposit.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 1000}, {Account: wallet.ID, Amount: -1000}})
+	if err := l.Post(deposit); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// many concurrent withdrawals, only the ones covered by the balance can succeed
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var succeeded int
+	var limitErrs []*ledger.BalanceLimitError
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := ledger.NewTransaction(now)
+			tx.AddEntries([]ledger.Entry{{Account: wallet.ID, Amount: 150}, {Account: bank.ID, Amount: -150}})
+			err := l.Post(tx)
+			mu.Lock()
+			defer mu.Unlock()
+			var limitErr *ledger.BalanceLimitError
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.As(err, &limitErr):
+				limitErrs = append(limitErrs, limitErr)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 6 {
+		t.Errorf("6 withdrawals should succeed but got %d", succeeded)
+	}
+	if b, _ := l.Balance(wallet.ID); b.Natural() != 100 {
+		t.Errorf("wallet balance should be 100 but got %d", b.Natural())
+	}
+	for _, e := range limitErrs {
+		if e.Account != wallet.ID || !e.Minimum || e.Shortfall != 50 || !errors.Is(e, ledger.ErrBalanceLimit) {
+			t.Errorf("unexpected limit error %+v", e)
+		}
+	}
+
+	// a credit line can go down to its limit
+	limit := -500
+	card := &ledger.Account{Name: "Card", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(card)
+	if err := l.SetBalanceLimits(card.ID, ledger.BalanceLimits{Min: &limit}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spend := ledger.NewTransaction(now)
+	spend.AddEntries([]ledger.Entry{{Account: card.ID, Amount: -500}, {Account: bank.ID, Amount: 500}})
+	if err := l.Post(spend); err != nil {
+		t.Errorf("spending up to the limit should succeed but got %v", err)
+	}
+	spend = ledger.NewTransaction(now)
+	spend.AddEntries([]ledger.Entry{{Account: card.ID, Amount: -1}, {Account: bank.ID, Amount: 1}})
+	if err := l.Post(spend); !errors.Is(err, ledger.ErrBalanceLimit) {
+		t.Errorf("spending beyond the limit should fail but got %v", err)
+	}
+
+	// limits with the minimum above the maximum are rejected
+	if err := l.SetBalanceLimits(card.ID, ledger.BalanceLimits{Min: &zero, Max: &limit}); err == nil {
+		t.Error("inconsistent limits should be rejected")
+	}
+}
+
+func Test_Holds(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	now := time.Now()
+	zero := 0
+
+	wallet := &ledger.Account{Name: "Wallet", AccountType: ledger.AccountTypeLiability, Limits: ledger.BalanceLimits{Min: &zero}}
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(wallet)
+	l.CreateAccount(bank)
+
+	deposit := ledger.NewTransaction(now)
+	deposit.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 1000}, {Account: wallet.ID, Amount: -1000}})
+	l.Post(deposit)
+
+	payment := func(amount int) *ledger.Transaction {
+		tx := ledger.NewTransaction(now)
+		tx.AddEntries([]ledger.Entry{{Account: wallet.ID, Amount: amount}, {Account: bank.ID, Amount: -amount}})
+		return tx
+	}
+
+	// a hold reduces the available balance but not the posted one
+	hold, err := l.PlaceHold(payment(600), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error placing hold: %v", err)
+	}
+	if b, _ := l.Balance(wallet.ID); b.Natural() != 1000 || b.Available != -400 || b.Pending != 600 {
+		t.Errorf("unexpected wallet balance %+v", b)
+	}
+
+	// the held funds cannot be spent
+	if err := l.Post(payment(500)); !errors.Is(err, ledger.ErrBalanceLimit) {
+		t.Errorf("spending held funds should fail but got %v", err)
+	}
+	if _, err := l.PlaceHold(payment(500), now.Add(time.Hour)); !errors.Is(err, ledger.ErrBalanceLimit) {
+		t.Errorf("holding held funds should fail but got %v", err)
+	}
+
+	// a partial capture posts the captured amount and releases the rest
+	if _, err := l.Capture(hold.ID, now, []ledger.Entry{{Account: wallet.ID, Amount: 700}, {Account: bank.ID, Amount: -700}}); err == nil {
+		t.Error("capturing more than the hold should fail")
+	}
+	captured, err := l.Capture(hold.ID, now, []ledger.Entry{{Account: wallet.ID, Amount: 450}, {Account: bank.ID, Amount: -450}})
+	if err != nil {
+		t.Fatalf("unexpected error capturing: %v", err)
+	}
+	if captured.Metadata[ledger.MetadataHold] != hold.ID.String() {
+		t.Errorf("capture should reference the hold but got %v", captured.Metadata)
+	}
+	if b, _ := l.Balance(wallet.ID); b.Natural() != 550 || b.Available != -550 || b.Pending != 0 {
+		t.Errorf("unexpected wallet balance after capture %+v", b)
+	}
+	if h, _ := l.Hold(hold.ID); h.Status != ledger.HoldCaptured || h.Capture != captured.Id {
+		t.Errorf("unexpected hold after capture %+v", h)
+	}
+	if _, err := l.Capture(hold.ID, now, nil); !errors.Is(err, ledger.ErrHoldNotPending) {
+		t.Errorf("capturing twice should fail but got %v", err)
+	}
+
+	// a voided hold releases its funds
+	voided, _ := l.PlaceHold(payment(300), now.Add(time.Hour))
+	if err := l.Void(voided.ID); err != nil {
+		t.Fatalf("unexpected error voiding: %v", err)
+	}
+	if b, _ := l.Balance(wallet.ID); b.Available != -550 || b.Pending != 0 {
+		t.Errorf("unexpected wallet balance after void %+v", b)
+	}
+
+	// expired holds cannot be captured, even with an earlier timestamp, and are released by ExpireHolds
+	expiring, _ := l.PlaceHold(payment(200), time.Now().Add(10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	if _, err := l.Capture(expiring.ID, now, nil); !errors.Is(err, ledger.ErrHoldExpired) {
+		t.Errorf("capturing an expired hold should fail but got %v", err)
+	}
+	if n, err := l.ExpireHolds(time.Now()); n != 1 || err != nil {
+		t.Errorf("1 hold should expire but got %d (%v)", n, err)
+	}
+	if h, _ := l.Hold(expiring.ID); h.Status != ledger.HoldExpired {
+		t.Errorf("hold should be expired but got %s", h.Status)
+	}
+	if b, _ := l.Balance(wallet.ID); b.Natural() != 550 || b.Available != -550 || b.Pending != 0 {
+		t.Errorf("unexpected wallet balance after expiry %+v", b)
+	}
+	if _, err := l.Hold(uuid.New()); !errors.Is(err, ledger.ErrHoldNotFound) {
+		t.Errorf("unknown hold should not be found but got %v", err)
+	}
+
+	// MaintainHolds expires the holds in the background
+	background, _ := l.PlaceHold(payment(100), time.Now().Add(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	expired := 0
+	err = l.MaintainHolds(ctx, time.Millisecond, func(n int, err error) {
+		if err != nil {
+			t.Errorf("unexpected error expiring: %v", err)
+		}
+		if expired += n; expired > 0 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) || expired != 1 {
+		t.Errorf("1 hold should expire in the background but got %d (%v)", expired, err)
+	}
+	if b, _ := l.Balance(wallet.ID); b.Available != -550 || b.Pending != 0 {
+		t.Errorf("unexpected wallet balance after background expiry %+v", b)
+	}
+	if h, _ := l.Hold(background.ID); h.Status != ledger.HoldExpired {
+		t.Errorf("hold should be expired but got %s", h.Status)
+	}
+}
+
+// tamperedStorage alters the transactions read from the storage, as someone changing the database would.
+type tamperedStorage struct {
+	*ledger.MemoryStorage
+	tamper func(ts []*ledger.Transaction) []*ledger.Transaction
+}
+
+func (s *tamperedStorage) Transactions() ([]*ledger.Transaction, error) {
+	ts, err := s.MemoryStorage.Transactions()
+	if err != nil || s.tamper == nil {
+		return ts, err
+	}
+	return s.tamper(ts), nil
+}
+
+func Test_VerifyChain(t *testing.T) {
+
+	storage := &tamperedStorage{MemoryStorage: ledger.NewMemoryStorage()}
+	l := ledger.New(storage)
+	now := time.Now()
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	equity := &ledger.Account{Name: "Capital", AccountType: ledger.AccountTypeEquity}
+	l.CreateAccount(bank)
+	l.CreateAccount(equity)
+
+	if err := l.VerifyChain(); err != nil {
+		t.Errorf("an empty chain should be intact but got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := ledger.NewTransaction(now)
+			tx.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 10}, {Account: equity.ID, Amount: -10}})
+			tx.Metadata = map[string]string{"memo": "capital"}
+			if err := l.Post(tx); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	links, _ := storage.Links()
+	if len(links) != 20 || links[0].Prev != (ledger.Hash{}) || links[1].Prev != links[0].Hash {
+		t.Fatalf("unexpected links %v", links)
+	}
+	if err := l.VerifyChain(); err != nil {
+		t.Errorf("chain should be intact but got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		index  int
+		tamper func(ts []*ledger.Transaction) []*ledger.Transaction
+	}{
+		{"amount", 5, func(ts []*ledger.Transaction) []*ledger.Transaction {
+			ts[5].Entries[0].Amount, ts[5].Entries[1].Amount = 1000, -1000
+			return ts
+		}},
+		{"metadata", 7, func(ts []*ledger.Transaction) []*ledger.Transaction {
+			ts[7].Metadata["memo"] = "loan"
+			return ts
+		}},
+		{"entries order", 3, func(ts []*ledger.Transaction) []*ledger.Transaction {
+			ts[3].Entries[0], ts[3].Entries[1] = ts[3].Entries[1], ts[3].Entries[0]
+			return ts
+		}},
+		{"deleted", 12, func(ts []*ledger.Transaction) []*ledger.Transaction {
+			return append(ts[:12], ts[13:]...)
+		}},
+		{"reordered", 2, func(ts []*ledger.Transaction) []*ledger.Transaction {
+			ts[2], ts[9] = ts[9], ts[2]
+			return ts
+		}},
+	}
+	for _, test := range tests {
+		storage.tamper = test.tamper
+		var chainErr *ledger.ChainError
+		if err := l.VerifyChain(); !errors.As(err, &chainErr) || chainErr.Index != test.index || !errors.Is(err, ledger.ErrChainBroken) {
+			t.Errorf("%s: expected a broken link at %d but got %v", test.name, test.index, err)
+		}
+	}
+}
+
+func Test_AuditLog(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	start := time.Now()
+
+	assets := &ledger.Account{Name: "Assets", AccountType: ledger.AccountTypeAsset}
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	rent := &ledger.Account{Name: "Rent", AccountType: ledger.AccountTypeExpense}
+	l.CreateAccount(assets)
+	l.CreateAccount(bank)
+	l.CreateAccount(rent)
+
+	alice := l.As("alice", "chart of accounts review")
+	if err := alice.RenameAccount(bank.ID, "Checking"); err != nil {
+		t.Fatalf("unexpected error renaming: %v", err)
+	}
+	if err := alice.MoveAccount(bank.ID, assets.ID); err != nil {
+		t.Fatalf("unexpected error moving: %v", err)
+	}
+	if err := l.As("bob", "").SplitAccount(bank.ID, 4); err != nil {
+		t.Fatalf("unexpected error splitting: %v", err)
+	}
+
+	// invalid moves are rejected and not recorded
+	if err := alice.MoveAccount(assets.ID, bank.ID); err == nil {
+		t.Error("moving an account under its descendant should fail")
+	}
+	if err := alice.MoveAccount(bank.ID, rent.ID); err == nil {
+		t.Error("moving an account under a parent of another type should fail")
+	}
+
+	if a, _ := l.Account(bank.ID); a.Name != "Checking" || a.ParentID != assets.ID || a.Shards != 4 {
+		t.Errorf("unexpected account %+v", a)
+	}
+
+	all, _ := l.AuditLog(ledger.AuditQuery{})
+	if len(all) != 6 {
+		t.Fatalf("audit log should have 6 records but got %d", len(all))
+	}
+	if all[0].Action != ledger.AuditCreateAccount || all[0].Before != nil || all[0].Actor != "" {
+		t.Errorf("unexpected creation record %+v", all[0])
+	}
+
+	byAlice, _ := l.AuditLog(ledger.AuditQuery{Actor: "alice"})
+	if len(byAlice) != 2 || byAlice[0].Action != ledger.AuditRenameAccount || byAlice[1].Action != ledger.AuditMoveAccount || byAlice[0].Reason != "chart of accounts review" {
+		t.Fatalf("unexpected records of alice %+v", byAlice)
+	}
+	var before, after ledger.Account
+	json.Unmarshal(byAlice[0].Before, &before)
+	json.Unmarshal(byAlice[0].After, &after)
+	if before.Name != "Bank" || after.Name != "Checking" || byAlice[0].Entity != bank.ID || byAlice[0].EntityType != ledger.AuditEntityAccount {
+		t.Errorf("rename should record the state before and after but got %s and %s", byAlice[0].Before, byAlice[0].After)
+	}
+
+	if records, _ := l.AuditLog(ledger.AuditQuery{Entity: bank.ID}); len(records) != 4 {
+		t.Errorf("bank should have 4 records but got %d", len(records))
+	}
+	if records, _ := l.AuditLog(ledger.AuditQuery{From: start, To: start}); len(records) != 0 {
+		t.Errorf("empty time range should have no records but got %d", len(records))
+	}
+	if records, _ := l.AuditLog(ledger.AuditQuery{Actor: "bob", From: start, To: time.Now().Add(time.Second)}); len(records) != 1 || records[0].Action != ledger.AuditSplitAccount {
+		t.Errorf("unexpected records of bob %+v", records)
+	}
+
+	// regular postings are not audited, reversals and period closings are
+	journal := uuid.New()
+	payment := ledger.NewTransaction(start)
+	payment.Journal = journal
+	payment.AddEntries([]ledger.Entry{{Account: rent.ID, Amount: 900}, {Account: bank.ID, Amount: -900}})
+	l.Post(payment)
+	carol := l.As("carol", "duplicated payment")
+	reversal, err := carol.Reverse(payment.Id, start)
+	if err != nil {
+		t.Fatalf("unexpected error reversing: %v", err)
+	}
+	if reversal.Journal != journal || reversal.Entries[0].Amount != -900 || reversal.Metadata[ledger.MetadataReversal] != payment.Id.String() {
+		t.Errorf("unexpected reversal %+v", reversal)
+	}
+	if _, err := carol.Reverse(payment.Id, start); !errors.Is(err, ledger.ErrDuplicateTransaction) {
+		t.Errorf("reversing twice should fail but got %v", err)
+	}
+	closing := ledger.NewClosingTransaction(start)
+	closing.AddEntries([]ledger.Entry{{Account: rent.ID, Amount: -900}, {Account: rent.ID, Amount: 900}})
+	if err := l.As("dave", "year end").Post(closing); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	if records, _ := l.AuditLog(ledger.AuditQuery{Actor: "carol"}); len(records) != 1 || records[0].Action != ledger.AuditReverseTransaction || records[0].Entity != reversal.Id || records[0].EntityType != ledger.AuditEntityTransaction || records[0].Reason != "duplicated payment" {
+		t.Errorf("unexpected records of carol %+v", records)
+	}
+	var posted ledger.Transaction
+	records, _ := l.AuditLog(ledger.AuditQuery{Actor: "dave"})
+	if len(records) != 1 || records[0].Action != ledger.AuditClosePeriod || json.Unmarshal(records[0].After, &posted) != nil || posted.Id != closing.Id {
+		t.Errorf("unexpected records of dave %+v", records)
+	}
+	if all, _ := l.AuditLog(ledger.AuditQuery{}); len(all) != 8 {
+		t.Errorf("audit log should have 8 records but got %d", len(all))
+	}
+}
+
+func Test_AccountLifecycle(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	now := time.Now()
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	old := &ledger.Account{Name: "Old bank", AccountType: ledger.AccountTypeAsset}
+	equity := &ledger.Account{Name: "Capital", AccountType: ledger.AccountTypeEquity}
+	for _, a := range []*ledger.Account{bank, old, equity} {
+		l.CreateAccount(a)
+	}
+	if a, _ := l.Account(bank.ID); a.Status != ledger.AccountStatusActive {
+		t.Errorf("account should be created active but got %s", a.Status)
+	}
+	if err := l.CreateAccount(&ledger.Account{Name: "Closed", AccountType: ledger.AccountTypeAsset, Status: ledger.AccountStatusClosed}); err == nil {
+		t.Error("account should not be created closed")
+	}
+
+	move := func(from, to uuid.UUID, amount int) error {
+		tx := ledger.NewTransaction(now)
+		tx.AddEntries([]ledger.Entry{{Account: to, Amount: amount}, {Account: from, Amount: -amount}})
+		return l.Post(tx)
+	}
+	move(equity.ID, old.ID, 300)
+
+	// a frozen account rejects the frozen direction only
+	if err := l.FreezeAccount(old.ID, true, false); err != nil {
+		t.Fatalf("unexpected error freezing: %v", err)
+	}
+	if err := move(equity.ID, old.ID, 10); !errors.Is(err, ledger.ErrAccountFrozen) {
+		t.Errorf("debit to a frozen account should fail but got %v", err)
+	}
+	if err := move(old.ID, bank.ID, 10); err != nil {
+		t.Errorf("credit to an account with frozen debits should succeed but got %v", err)
+	}
+	if _, err := l.CloseAccount(old.ID, now, bank.ID); err == nil {
+		t.Error("frozen account should not be closed")
+	}
+	l.UnfreezeAccount(old.ID)
+
+	// closing requires a zero balance or a sweep
+	if _, err := l.CloseAccount(old.ID, now, uuid.Nil); !errors.Is(err, ledger.ErrAccountNotEmpty) {
+		t.Errorf("closing an account with balance should fail but got %v", err)
+	}
+	sweep, err := l.CloseAccount(old.ID, now, bank.ID)
+	if err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+	if sweep == nil || sweep.Metadata[ledger.MetadataSweep] != old.ID.String() {
+		t.Errorf("unexpected sweep transaction %+v", sweep)
+	}
+	if b, _ := l.Balance(old.ID); b.Balance != 0 {
+		t.Errorf("closed account should have no balance but got %d", b.Balance)
+	}
+	if b, _ := l.Balance(bank.ID); b.Balance != 300 {
+		t.Errorf("bank should have the swept balance 300 but got %d", b.Balance)
+	}
+
+	// a closed account rejects every entry, posted or held
+	if err := move(bank.ID, old.ID, 1); !errors.Is(err, ledger.ErrAccountClosed) {
+		t.Errorf("debit to a closed account should fail but got %v", err)
+	}
+	hold := ledger.NewTransaction(now)
+	hold.AddEntries([]ledger.Entry{{Account: old.ID, Amount: 1}, {Account: bank.ID, Amount: -1}})
+	if _, err := l.PlaceHold(hold, now.Add(time.Hour)); !errors.Is(err, ledger.ErrAccountClosed) {
+		t.Errorf("hold on a closed account should fail but got %v", err)
+	}
+	if err := l.FreezeAccount(old.ID, true, true); !errors.Is(err, ledger.ErrAccountClosed) {
+		t.Errorf("closed account should not be frozen but got %v", err)
+	}
+
+	// an empty account is closed without a sweep
+	empty := &ledger.Account{Name: "Empty", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(empty)
+	if sweep, err := l.CloseAccount(empty.ID, now, bank.ID); sweep != nil || err != nil {
+		t.Errorf("empty account should be closed without a sweep but got %v (%v)", sweep, err)
+	}
+
+	if err := l.ArchiveAccount(bank.ID); err == nil {
+		t.Error("active account should not be archived")
+	}
+	if err := l.ArchiveAccount(old.ID); err != nil {
+		t.Errorf("unexpected error archiving: %v", err)
+	}
+
+	if accounts, _ := l.AccountsWithStatus(ledger.AccountStatusActive); len(accounts) != 2 {
+		t.Errorf("2 accounts should be active but got %d", len(accounts))
+	}
+	closed, _ := l.AccountsWithStatus(ledger.AccountStatusClosed, ledger.AccountStatusArchived)
+	if len(closed) != 2 || closed[0].ID != old.ID || closed[1].ID != empty.ID {
+		t.Errorf("unexpected closed accounts %+v", closed)
+	}
+	if records, _ := l.AuditLog(ledger.AuditQuery{Entity: old.ID}); len(records) != 5 || records[4].Action != ledger.AuditArchiveAccount {
+		t.Errorf("lifecycle changes should be audited but got %d records", len(records))
+	}
+}
+
+func Test_AccountLabels(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset, Code: "1010", Tags: []string{"cash", "bank", "cash"}}
+	petty := &ledger.Account{Name: "Petty cash", AccountType: ledger.AccountTypeAsset, Code: "1020", Tags: []string{"cash"}}
+	rent := &ledger.Account{Name: "Rent", AccountType: ledger.AccountTypeExpense, Metadata: map[string]string{"cost_center": "HQ"}}
+	for _, a := range []*ledger.Account{bank, petty, rent} {
+		if err := l.CreateAccount(a); err != nil {
+			t.Fatalf("unexpected error creating %s: %v", a.Name, err)
+		}
+	}
+	if err := l.CreateAccount(&ledger.Account{Name: "Other", AccountType: ledger.AccountTypeAsset, Code: "1010"}); !errors.Is(err, ledger.ErrDuplicateAccountCode) {
+		t.Errorf("duplicate code should be rejected but got %v", err)
+	}
+	if err := l.CreateAccount(&ledger.Account{Name: "Other", AccountType: ledger.AccountTypeAsset, Code: "10 10"}); err == nil {
+		t.Error("code with spaces should be rejected")
+	}
+
+	if a, err := l.AccountByCode("1010"); err != nil || a.ID != bank.ID || len(a.Tags) != 2 || a.Tags[0] != "bank" {
+		t.Errorf("unexpected account by code %+v (%v)", a, err)
+	}
+	if _, err := l.AccountByCode("9999"); !errors.Is(err, ledger.ErrAccountNotFound) {
+		t.Errorf("unknown code should not be found but got %v", err)
+	}
+	if cash, _ := l.AccountsByTag("cash"); len(cash) != 2 || cash[0].ID != bank.ID || cash[1].ID != petty.ID {
+		t.Errorf("unexpected accounts tagged cash %+v", cash)
+	}
+
+	// a code can move to another account once it is free
+	if err := l.SetAccountCode(petty.ID, "1010"); !errors.Is(err, ledger.ErrDuplicateAccountCode) {
+		t.Errorf("used code should be rejected but got %v", err)
+	}
+	l.SetAccountCode(bank.ID, "1000")
+	if err := l.SetAccountCode(petty.ID, "1010"); err != nil {
+		t.Errorf("free code should be accepted but got %v", err)
+	}
+	if a, _ := l.AccountByCode("1010"); a.ID != petty.ID {
+		t.Errorf("code 1010 should belong to petty cash but got %s", a.Name)
+	}
+
+	l.UntagAccount(petty.ID, "cash")
+	l.TagAccount(rent.ID, "office")
+	if cash, _ := l.AccountsByTag("cash"); len(cash) != 1 {
+		t.Errorf("1 account should be tagged cash but got %d", len(cash))
+	}
+	l.SetAccountMetadata(rent.ID, map[string]string{"cost_center": "", "vendor": "landlord"})
+	if a, _ := l.Account(rent.ID); len(a.Metadata) != 1 || a.Metadata["vendor"] != "landlord" || !a.HasTag("office") {
+		t.Errorf("unexpected rent account %+v", a)
+	}
+
+	// the stored account is not changed through the returned one
+	a, _ := l.Account(rent.ID)
+	a.Metadata["vendor"] = "changed"
+	if a, _ := l.Account(rent.ID); a.Metadata["vendor"] != "landlord" {
+		t.Error("stored account should not share its metadata")
+	}
+
+	if err := l.ClassifyAccount(rent.ID, ledger.SubTypeCurrentAsset); err == nil {
+		t.Error("sub-type of another account type should be rejected")
+	}
+	if err := l.ClassifyAccount(rent.ID, ledger.SubTypeOperatingExpense); err != nil {
+		t.Errorf("unexpected error classifying: %v", err)
+	}
+}
+
+func Test_Dimensions(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	now := time.Now()
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	travel := &ledger.Account{Name: "Travel", AccountType: ledger.AccountTypeExpense}
+	l.CreateAccount(bank)
+	l.CreateAccount(travel)
+	l.RegisterDimension(ledger.DimensionCatalog{Dimension: ledger.DimensionDepartment, Values: []string{"sales", "engineering"}})
+	l.RegisterDimension(ledger.DimensionCatalog{Dimension: ledger.DimensionProject, Values: []string{"apollo", "gemini"}})
+
+	spend := func(amount int, dims ledger.Dimensions) error {
+		tx := ledger.NewTransaction(now)
+		tx.AddEntries([]ledger.Entry{{Account: travel.ID, Amount: amount, Dimensions: dims}, {Account: bank.ID, Amount: -amount}})
+		return l.Post(tx)
+	}
+	for _, s := range []struct {
+		amount int
+		dims   ledger.Dimensions
+	}{
+		{100, ledger.Dimensions{ledger.DimensionDepartment: "sales", ledger.DimensionProject: "apollo"}},
+		{200, ledger.Dimensions{ledger.DimensionDepartment: "sales", ledger.DimensionProject: "gemini"}},
+		{400, ledger.Dimensions{ledger.DimensionDepartment: "engineering", ledger.DimensionProject: "apollo"}},
+		{800, nil},
+	} {
+		if err := spend(s.amount, s.dims); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the dimensions are validated against the catalogs
+	if err := spend(1, ledger.Dimensions{ledger.DimensionCostCenter: "HQ"}); !errors.Is(err, ledger.ErrDimensionNotFound) {
+		t.Errorf("dimension without a catalog should be rejected but got %v", err)
+	}
+	if err := spend(1, ledger.Dimensions{ledger.DimensionDepartment: "legal"}); err == nil {
+		t.Error("value not in the catalog should be rejected")
+	}
+
+	byDepartment, _ := l.DimensionBalances(ledger.DimensionQuery{Accounts: []uuid.UUID{travel.ID}, GroupBy: []ledger.Dimension{ledger.DimensionDepartment}})
+	want := map[string]int{"": 800, "engineering": 400, "sales": 300}
+	if len(byDepartment) != 3 {
+		t.Fatalf("expected 3 balances by department but got %+v", byDepartment)
+	}
+	for _, b := range byDepartment {
+		if want[b.Dimensions[ledger.DimensionDepartment]] != b.Balance {
+			t.Errorf("unexpected balance %+v", b)
+		}
+	}
+	if byDepartment[0].Dimensions[ledger.DimensionDepartment] != "" || byDepartment[1].Dimensions[ledger.DimensionDepartment] != "engineering" {
+		t.Errorf("balances should be ordered by dimension value %+v", byDepartment)
+	}
+
+	apollo, _ := l.DimensionBalances(ledger.DimensionQuery{
+		Filter:  ledger.Dimensions{ledger.DimensionProject: "apollo"},
+		GroupBy: []ledger.Dimension{ledger.DimensionDepartment, ledger.DimensionProject},
+	})
+	if len(apollo) != 2 || apollo[0].Account != travel.ID || apollo[0].Balance != 400 || apollo[1].Balance != 100 {
+		t.Errorf("unexpected balances of project apollo %+v", apollo)
+	}
+
+	total, _ := l.DimensionBalances(ledger.DimensionQuery{Accounts: []uuid.UUID{travel.ID}})
+	if len(total) != 1 || total[0].Balance != 1500 {
+		t.Errorf("ungrouped balance should be 1500 but got %+v", total)
+	}
+}
+
+func Test_EntryMetadata(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	now := time.Now()
+
+	receivable := &ledger.Account{Name: "Receivable", AccountType: ledger.AccountTypeAsset}
+	sales := &ledger.Account{Name: "Sales", AccountType: ledger.AccountTypeRevenue}
+	l.CreateAccount(receivable)
+	l.CreateAccount(sales)
+
+	invoice := ledger.NewTransaction(now)
+	invoice.AddEntries([]ledger.Entry{
+		{Account: receivable.ID, Amount: 300, Description: "Invoice 42", Metadata: map[string]string{"invoice": "42"}},
+		{Account: sales.ID, Amount: -100, Description: "Widget", Metadata: map[string]string{"invoice": "42", "sku": "W-1"}},
+		{Account: sales.ID, Amount: -200, Description: "Gadget", Metadata: map[string]string{"invoice": "42", "sku": "G-7"}},
+	})
+	if err := l.Post(invoice); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the caller cannot change the posted entries through the transaction
+	invoice.Entries[1].Metadata["sku"] = "changed"
+
+	entries, _ := l.Entries(sales.ID, time.Time{}, time.Time{})
+	if len(entries) != 2 || entries[0].Description != "Widget" || entries[0].Metadata["sku"] != "W-1" {
+		t.Errorf("entries should keep their description and metadata but got %+v", entries)
+	}
+
+	if found, _ := l.SearchEntries("sku", "G-7"); len(found) != 1 || found[0].Index != 2 || found[0].Amount != -200 {
+		t.Errorf("unexpected entries with sku G-7 %+v", found)
+	}
+	if found, _ := l.SearchEntries("invoice", "42"); len(found) != 3 {
+		t.Errorf("3 entries should have invoice 42 but got %d", len(found))
+	}
+	if found, _ := l.SearchEntries("sku", "changed"); len(found) != 0 {
+		t.Errorf("no entry should have sku changed but got %d", len(found))
+	}
+	if err := l.VerifyChain(); err != nil {
+		t.Errorf("chain should be intact but got %v", err)
+	}
+}
+
+func Test_QueryTransactions(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	journal := uuid.New()
+
+	assets := &ledger.Account{Name: "Assets", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(assets)
+	bank := &ledger.Account{Name: "Bank", ParentID: assets.ID, AccountType: ledger.AccountTypeAsset}
+	vendors := &ledger.Account{Name: "Vendors", AccountType: ledger.AccountTypeLiability}
+	l.CreateAccount(bank)
+	l.CreateAccount(vendors)
+
+	// 20 payouts, alternating between two vendors, of 100 to 2000
+	for i := 0; i < 20; i++ {
+		tx := ledger.NewTransaction(start.AddDate(0, 0, i*7))
+		tx.Journal = journal
+		vendor := []string{"acme", "globex"}[i%2]
+		tx.AddEntries([]ledger.Entry{
+			{Account: vendors.ID, Amount: 100 * (i + 1), Description: "Payout to " + vendor},
+			{Account: bank.ID, Amount: -100 * (i + 1)},
+		})
+		tx.Metadata = map[string]string{"vendor": vendor}
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	other := ledger.NewClosingTransaction(start)
+	other.AddEntries([]ledger.Entry{{Account: vendors.ID, Amount: 5000}, {Account: bank.ID, Amount: -5000}})
+	l.Post(other)
+
+	// all payouts to acme in the quarter over 1000
+	minimum := 1000
+	page, err := l.QueryTransactions(ledger.TransactionQuery{
+		Accounts:  []uuid.UUID{assets.ID},
+		Journals:  []uuid.UUID{journal},
+		From:      start,
+		To:        start.AddDate(0, 3, 0),
+		MinAmount: &minimum,
+		Metadata:  []ledger.MetadataPredicate{{Key: "vendor", Op: ledger.MetadataEquals, Value: "acme"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the quarter has the first 14 weeks, acme has the odd amounts 1100 and 1300
+	if len(page.Transactions) != 2 || page.Transactions[0].TotalIncreases() != 1100 || page.Transactions[1].TotalIncreases() != 1300 || page.Next != "" {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	if page, _ := l.QueryTransactions(ledger.TransactionQuery{Types: []ledger.TransactionType{ledger.TransactionTypeClosing}}); len(page.Transactions) != 1 || page.Transactions[0].Id != other.Id {
+		t.Errorf("unexpected closing transactions %+v", page)
+	}
+	if page, _ := l.QueryTransactions(ledger.TransactionQuery{Text: "GLOBEX"}); len(page.Transactions) != 10 {
+		t.Errorf("10 transactions should mention globex but got %d", len(page.Transactions))
+	}
+
+	// the pages follow each other with no gap or overlap
+	q := ledger.TransactionQuery{Journals: []uuid.UUID{journal}, SortBy: ledger.SortByAmount, Descending: true, Limit: 6}
+	var amounts []int
+	for {
+		page, err := l.QueryTransactions(q)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, tx := range page.Transactions {
+			amounts = append(amounts, tx.TotalIncreases())
+		}
+		if page.Next == "" {
+			break
+		}
+		q.After = page.Next
+	}
+	if len(amounts) != 20 || amounts[0] != 2000 || amounts[19] != 100 {
+		t.Fatalf("pages should have the 20 payouts by descending amount but got %v", amounts)
+	}
+	for i := 1; i < len(amounts); i++ {
+		if amounts[i] >= amounts[i-1] {
+			t.Errorf("amounts should be descending %v", amounts)
+			break
+		}
+	}
+
+	if _, err := l.QueryTransactions(ledger.TransactionQuery{After: "garbage"}); !errors.Is(err, ledger.ErrInvalidCursor) {
+		t.Errorf("invalid cursor should be rejected but got %v", err)
+	}
+
+	// a vendor only in the entries is not different from it
+	tagged := ledger.NewTransaction(start)
+	tagged.AddEntries([]ledger.Entry{
+		{Account: vendors.ID, Amount: 70, Metadata: map[string]string{"vendor": "acme"}},
+		{Account: bank.ID, Amount: -70, Metadata: map[string]string{"vendor": "acme"}},
+	})
+	l.Post(tagged)
+	notAcme := []ledger.MetadataPredicate{{Key: "vendor", Op: ledger.MetadataNotEquals, Value: "acme"}}
+	page, _ = l.QueryTransactions(ledger.TransactionQuery{Metadata: notAcme})
+	// the 10 globex payouts and the closing transaction without vendor
+	if len(page.Transactions) != 11 || slices.ContainsFunc(page.Transactions, func(tx *ledger.Transaction) bool { return tx.Id == tagged.Id }) {
+		t.Errorf("expected the 11 transactions without the vendor acme but got %d", len(page.Transactions))
+	}
+}
+
+// racingStorage runs a function once before committing a posting, after the posting was prepared,
+// as another request would do concurrently. raceSave does the same before saving an account.
+type racingStorage struct {
+	*ledger.MemoryStorage
+	race     func()
+	raceSave func()
+}
+
+func (s *racingStorage) SaveAccount(a ledger.Account, audit ledger.AuditRecord) error {
+	if race := s.raceSave; race != nil {
+		s.raceSave = nil
+		race()
+	}
+	return s.MemoryStorage.SaveAccount(a, audit)
+}
+
+func (s *racingStorage) Commit(c ledger.Commit) error {
+	if race := s.race; race != nil && len(c.Updates) == 0 {
+		s.race = nil
+		race()
+	}
+	return s.MemoryStorage.Commit(c)
+}
+
+func Test_CloseAccountConcurrently(t *testing.T) {
+
+	storage := &racingStorage{MemoryStorage: ledger.NewMemoryStorage()}
+	l := ledger.New(storage)
+	now := time.Now()
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	wallet := &ledger.Account{Name: "Wallet", AccountType: ledger.AccountTypeAsset, Shards: 4}
+	card := &ledger.Account{Name: "Card", AccountType: ledger.AccountTypeAsset}
+	for _, a := range []*ledger.Account{bank, wallet, card} {
+		l.CreateAccount(a)
+	}
+	deposit := func(account *ledger.Account) error {
+		tx := ledger.NewTransaction(now)
+		tx.AddEntries([]ledger.Entry{{Account: account.ID, Amount: 10}, {Account: bank.ID, Amount: -10}})
+		return l.Post(tx)
+	}
+
+	// the wallet is closed after the deposit was prepared and before it is committed
+	storage.race = func() {
+		if _, err := l.CloseAccount(wallet.ID, now, bank.ID); err != nil {
+			t.Errorf("unexpected error closing: %v", err)
+		}
+	}
+	if err := deposit(wallet); !errors.Is(err, ledger.ErrAccountClosed) {
+		t.Errorf("deposit to the account closed in the meantime should be rejected but got %v", err)
+	}
+	if b, _ := l.Balance(wallet.ID); b.Balance != 0 {
+		t.Errorf("closed account should have a zero balance but has %d", b.Balance)
+	}
+
+	// the same for an account frozen in the meantime
+	storage.race = func() {
+		if err := l.FreezeAccount(card.ID, true, false); err != nil {
+			t.Errorf("unexpected error freezing: %v", err)
+		}
+	}
+	if err := deposit(card); !errors.Is(err, ledger.ErrAccountFrozen) {
+		t.Errorf("deposit to the account frozen in the meantime should be rejected but got %v", err)
+	}
+
+	// postings racing with the closing are either swept or rejected
+	l.UnfreezeAccount(card.ID)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := deposit(card); err != nil && !errors.Is(err, ledger.ErrAccountClosed) {
+					t.Errorf("unexpected error posting: %v", err)
+				}
+			}
+		}()
+	}
+	if _, err := l.CloseAccount(card.ID, now, bank.ID); err != nil {
+		t.Errorf("unexpected error closing: %v", err)
+	}
+	wg.Wait()
+	if b, _ := l.Balance(card.ID); b.Balance != 0 {
+		t.Errorf("closed account should have a zero balance but has %d", b.Balance)
+	}
+
+	// a rename racing with the closing is applied again to the closed account, it does not reopen it
+	savings := &ledger.Account{Name: "Savings", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(savings)
+	storage.raceSave = func() {
+		if _, err := l.CloseAccount(savings.ID, now, bank.ID); err != nil {
+			t.Errorf("unexpected error closing: %v", err)
+		}
+	}
+	if err := l.RenameAccount(savings.ID, "Old savings"); err != nil {
+		t.Errorf("unexpected error renaming: %v", err)
+	}
+	if a, _ := l.Account(savings.ID); a.Status != ledger.AccountStatusClosed || a.Name != "Old savings" {
+		t.Errorf("account should be closed and renamed but got %s %q", a.Status, a.Name)
+	}
+
+	// the same for a freeze racing with the closing, it fails instead
+	storage.raceSave = func() {
+		if err := l.ArchiveAccount(savings.ID); err != nil {
+			t.Errorf("unexpected error archiving: %v", err)
+		}
+	}
+	if err := l.FreezeAccount(savings.ID, true, true); !errors.Is(err, ledger.ErrAccountClosed) {
+		t.Errorf("freezing an account archived in the meantime should fail but got %v", err)
+	}
+
+	// two accounts created with the same id, only the first one is kept
+	first := &ledger.Account{ID: uuid.New(), Name: "First", AccountType: ledger.AccountTypeAsset}
+	second := &ledger.Account{ID: first.ID, Name: "Second", AccountType: ledger.AccountTypeAsset}
+	storage.raceSave = func() {
+		if err := l.CreateAccount(first); err != nil {
+			t.Errorf("unexpected error creating: %v", err)
+		}
+	}
+	if err := l.CreateAccount(second); !errors.Is(err, ledger.ErrDuplicateAccount) {
+		t.Errorf("creating an account with the same id should fail but got %v", err)
+	}
+	if a, _ := l.Account(first.ID); a.Name != "First" {
+		t.Errorf("first account should be kept but got %q", a.Name)
+	}
+}
+
+func Test_PlaceHoldConcurrently(t *testing.T) {
+
+	storage := &racingStorage{MemoryStorage: ledger.NewMemoryStorage()}
+	l := ledger.New(storage)
+	now := time.Now()
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	card := &ledger.Account{Name: "Card", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(bank)
+	l.CreateAccount(card)
+	payment := func(id uuid.UUID) *ledger.Transaction {
+		tx := ledger.NewTransaction(now)
+		tx.Id = id
+		tx.AddEntries([]ledger.Entry{{Account: card.ID, Amount: 10}, {Account: bank.ID, Amount: -10}})
+		return tx
+	}
+
+	// the same hold is placed after the hold was prepared and before it is committed
+	id := uuid.New()
+	storage.race = func() {
+		if _, err := l.PlaceHold(payment(id), now.Add(time.Hour)); err != nil {
+			t.Errorf("unexpected error placing hold: %v", err)
+		}
+	}
+	if _, err := l.PlaceHold(payment(id), now.Add(time.Hour)); !errors.Is(err, ledger.ErrDuplicateTransaction) {
+		t.Errorf("hold placed in the meantime should be a duplicate but got %v", err)
+	}
+
+	// many concurrent placements of the same hold, only one succeeds
+	id = uuid.New()
+	var wg sync.WaitGroup
+	var placed atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch _, err := l.PlaceHold(payment(id), now.Add(time.Hour)); {
+			case err == nil:
+				placed.Add(1)
+			case !errors.Is(err, ledger.ErrDuplicateTransaction):
+				t.Errorf("unexpected error placing hold: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if placed.Load() != 1 {
+		t.Errorf("only one hold should be placed but got %d", placed.Load())
+	}
+	if b, _ := l.Balance(card.ID); b.Pending != 20 {
+		t.Errorf("pending balance should be 20 but got %d", b.Pending)
+	}
+}
+
+// countingStorage counts the entries read, to check the balance snapshots spare reading them.
+// It can also fail the reads of an account, and run a function once after reading entries.
+type countingStorage struct {
+	*ledger.MemoryStorage
+	read  int
+	fail  uuid.UUID
+	after func()
+}
+
+func (s *countingStorage) Entries(account uuid.UUID, from, to time.Time) ([]ledger.PostedEntry, error) {
+	if account == s.fail {
+		return nil, errors.New("storage is down")
+	}
+	entries, err := s.MemoryStorage.Entries(account, from, to)
+	s.read += len(entries)
+	if after := s.after; after != nil {
+		s.after = nil
+		after()
+	}
+	return entries, err
+}
+
+func Test_BalanceSnapshots(t *testing.T) {
+
+	storage := &countingStorage{MemoryStorage: ledger.NewMemoryStorage()}
+	l := ledger.New(storage)
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	sales := &ledger.Account{Name: "Sales", AccountType: ledger.AccountTypeRevenue}
+	idle := &ledger.Account{Name: "Idle", AccountType: ledger.AccountTypeAsset}
+	for _, a := range []*ledger.Account{bank, sales, idle} {
+		l.CreateAccount(a)
+	}
+	sale := func(at time.Time, amount int) {
+		tx := ledger.NewTransaction(at)
+		tx.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: amount}, {Account: sales.ID, Amount: -amount}})
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// 3 sales a day for 30 days
+	for day := 0; day < 30; day++ {
+		for hour := 9; hour < 12; hour++ {
+			sale(start.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour), 10)
+		}
+	}
+
+	// one snapshot a day for each of the two accounts, none for the idle account
+	n, err := l.RefreshSnapshots(start.AddDate(0, 0, 20).Add(time.Hour))
+	if err != nil || n != 40 {
+		t.Fatalf("expected 40 snapshots but got %d, %v", n, err)
+	}
+	if n, _ := l.RefreshSnapshots(start.AddDate(0, 0, 20)); n != 0 {
+		t.Errorf("snapshots already built should not be built again, got %d", n)
+	}
+
+	// the balance at noon of day 10 reads only the 3 sales of that day after the snapshot
+	storage.read = 0
+	b, err := l.BalanceAt(bank.ID, start.AddDate(0, 0, 10).Add(12*time.Hour))
+	if err != nil || b.Balance != 330 || storage.read != 3 {
+		t.Errorf("expected balance 330 reading 3 entries but got %d reading %d, %v", b.Balance, storage.read, err)
+	}
+	// after the last snapshot the entries are summed as before
+	if b, _ := l.BalanceAt(sales.ID, start.AddDate(0, 0, 30)); b.Balance != -900 {
+		t.Errorf("expected balance -900 but got %d", b.Balance)
+	}
+
+	// a backdated sale invalidates the snapshots after it
+	sale(start.AddDate(0, 0, 5).Add(10*time.Hour), 1000)
+	if b, _ := l.BalanceAt(bank.ID, start.AddDate(0, 0, 10)); b.Balance != 1300 {
+		t.Errorf("expected balance 1300 after the backdated sale but got %d", b.Balance)
+	}
+	if b, _ := l.BalanceAt(bank.ID, start.AddDate(0, 0, 5)); b.Balance != 150 {
+		t.Errorf("the snapshot before the backdated sale should be kept, expected 150 but got %d", b.Balance)
+	}
+	if n, _ := l.RefreshSnapshots(start.AddDate(0, 0, 20)); n != 30 {
+		t.Errorf("expected the 15 days after the backdated sale to be built again for both accounts, got %d", n)
+	}
+	storage.read = 0
+	if b, _ := l.BalanceAt(bank.ID, start.AddDate(0, 0, 15)); b.Balance != 1450 || storage.read != 0 {
+		t.Errorf("expected balance 1450 from the snapshot but got %d reading %d entries", b.Balance, storage.read)
+	}
+
+	// a sale posted during the refresh after the new snapshots does not abort it, a backdated one is retried
+	storage.after = func() { sale(start.AddDate(0, 0, 29), 5) }
+	if n, err := l.RefreshSnapshots(start.AddDate(0, 0, 22)); n != 4 || err != nil {
+		t.Errorf("expected 4 snapshots but got %d, %v", n, err)
+	}
+	storage.after = func() { sale(start.AddDate(0, 0, 22).Add(time.Hour), 7) }
+	if n, err := l.RefreshSnapshots(start.AddDate(0, 0, 24)); n != 4 || err != nil {
+		t.Errorf("expected 4 snapshots but got %d, %v", n, err)
+	}
+	if b, _ := l.BalanceAt(bank.ID, start.AddDate(0, 0, 24)); b.Balance != 1727 {
+		t.Errorf("expected balance 1727 with the sale posted during the refresh but got %d", b.Balance)
+	}
+
+	// an account that fails does not stop the others
+	storage.fail = bank.ID
+	n, err = l.RefreshSnapshots(start.AddDate(0, 0, 26))
+	if n != 2 || err == nil || !strings.Contains(err.Error(), bank.ID.String()) {
+		t.Errorf("expected the 2 sales snapshots and the bank error but got %d, %v", n, err)
+	}
+
+	// the background maintenance reports every refresh
+	ctx, cancel := context.WithCancel(context.Background())
+	reports := 0
+	err = l.MaintainSnapshots(ctx, time.Millisecond, func(n int, err error) {
+		if reports++; reports == 2 {
+			cancel()
+		}
+		if err == nil {
+			t.Error("the error of the bank should be reported")
+		}
+	})
+	if !errors.Is(err, context.Canceled) || reports != 2 {
+		t.Errorf("expected 2 reports and the context error but got %d, %v", reports, err)
+	}
+}
+
+func Test_CheckIntegrity(t *testing.T) {
+
+	storage := ledger.NewMemoryStorage()
+	l := ledger.New(storage)
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	sales := &ledger.Account{Name: "Sales", AccountType: ledger.AccountTypeRevenue}
+	for _, a := range []*ledger.Account{bank, sales} {
+		l.CreateAccount(a)
+	}
+	for day := 0; day < 10; day++ {
+		tx := ledger.NewTransaction(start.AddDate(0, 0, day))
+		tx.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 100}, {Account: sales.ID, Amount: -100}})
+		l.Post(tx)
+	}
+	hold := ledger.NewTransaction(start.AddDate(0, 0, 10))
+	hold.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: -30}, {Account: sales.ID, Amount: 30}})
+	if _, err := l.PlaceHold(hold, start.AddDate(0, 0, 20)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the assertions are checked when declared
+	assertion := &ledger.BalanceAssertion{Account: bank.ID, Date: start.AddDate(0, 0, 5), Amount: 500}
+	if err := l.AssertBalance(assertion); err != nil || assertion.ID == uuid.Nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var assertionErr *ledger.AssertionError
+	err := l.AssertBalance(&ledger.BalanceAssertion{Account: sales.ID, Date: start.AddDate(0, 0, 5), Amount: -400})
+	if !errors.As(err, &assertionErr) || assertionErr.Actual != -500 || !errors.Is(err, ledger.ErrAssertionFailed) {
+		t.Errorf("expected an assertion error with the actual balance -500 but got %v", err)
+	}
+	if assertions, _ := l.Assertions(); len(assertions) != 1 {
+		t.Errorf("only the assertion that holds should be kept, got %d", len(assertions))
+	}
+
+	r, err := l.CheckIntegrity()
+	if err != nil || !r.OK() || r.Accounts != 2 || r.Transactions != 10 || r.Assertions != 1 {
+		t.Fatalf("expected no discrepancy but got %+v, %v", r, err)
+	}
+
+	// a backdated transaction breaks the assertion
+	backdated := ledger.NewTransaction(start.AddDate(0, 0, 1))
+	backdated.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 50}, {Account: sales.ID, Amount: -50}})
+	l.Post(backdated)
+	// an unbalanced transaction and a wrong balance written behind the ledger's back
+	unbalanced := ledger.NewTransaction(start.AddDate(0, 0, 2))
+	unbalanced.Id = uuid.New()
+	unbalanced.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 7}})
+	balance, _ := storage.Balance(sales.ID, 0)
+	balance.Balance -= 1000
+	if err := storage.Commit(ledger.Commit{Transactions: []*ledger.Transaction{unbalanced}, Balances: []ledger.AccountBalance{balance}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r, err = l.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kinds := map[ledger.DiscrepancyKind]ledger.Discrepancy{}
+	for _, d := range r.Discrepancies {
+		kinds[d.Kind] = d
+	}
+	if d := kinds[ledger.DiscrepancyUnbalanced]; d.Transaction != unbalanced.Id {
+		t.Errorf("expected the unbalanced transaction to be reported, got %+v", r.Discrepancies)
+	}
+	if d := kinds[ledger.DiscrepancyAssertion]; d.Assertion != assertion.ID || d.Expected != 500 || d.Actual != 557 {
+		t.Errorf("expected the broken assertion to be reported, got %+v", d)
+	}
+	if d := kinds[ledger.DiscrepancyBalance]; d.Account != sales.ID || d.Expected != -1050 || d.Actual != -2050 {
+		t.Errorf("expected the wrong sales balance to be reported, got %+v", d)
+	}
+	// the stored bank balances miss the unbalanced entry, and only the posted sales balance was changed
+	if len(r.Discrepancies) != 5 {
+		t.Errorf("expected 5 discrepancies but got %+v", r.Discrepancies)
+	}
+	data, err := json.Marshal(r)
+	if err != nil || !strings.Contains(string(data), `"Kind":"Unbalanced"`) {
+		t.Errorf("the report should be encoded as JSON, got %s, %v", data, err)
+	}
+}
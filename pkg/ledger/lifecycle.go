@@ -0,0 +1,204 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAccountFrozen   = errors.New("account is frozen")
+	ErrAccountClosed   = errors.New("account is closed")
+	ErrAccountNotEmpty = errors.New("account balance is not zero")
+)
+
+// MetadataSweep is the metadata key of the transaction that swept the balance of a closed account, with its id.
+const MetadataSweep = "sweep"
+
+// AccountStatus represents the lifecycle state of an account.
+//
+//	Active <-> DebitsFrozen, CreditsFrozen, Frozen
+//	Active -> Closed -> Archived
+//
+// An empty status is the same as Active.
+type AccountStatus string
+
+const (
+	AccountStatusActive        AccountStatus = "Active"        // The account accepts any entry.
+	AccountStatusDebitsFrozen  AccountStatus = "DebitsFrozen"  // The account rejects debits (positive amounts).
+	AccountStatusCreditsFrozen AccountStatus = "CreditsFrozen" // The account rejects credits (negative amounts).
+	AccountStatusFrozen        AccountStatus = "Frozen"        // The account rejects every entry.
+	AccountStatusClosed        AccountStatus = "Closed"        // The account has a zero balance and rejects every entry.
+	AccountStatusArchived      AccountStatus = "Archived"      // A closed account hidden from the usual listings.
+)
+
+// IsActive returns true if the account accepts every entry.
+func (s AccountStatus) IsActive() bool {
+	return s == "" || s == AccountStatusActive
+}
+
+// IsFrozen returns true if the account rejects the debits, the credits or both.
+func (s AccountStatus) IsFrozen() bool {
+	return s == AccountStatusDebitsFrozen || s == AccountStatusCreditsFrozen || s == AccountStatusFrozen
+}
+
+// IsClosed returns true if the account is closed or archived.
+func (s AccountStatus) IsClosed() bool {
+	return s == AccountStatusClosed || s == AccountStatusArchived
+}
+
+// accepts returns an error if an entry with the amount cannot be posted to the account.
+func (a Account) accepts(amount int) error {
+	switch s := a.Status; {
+	case s.IsClosed():
+		return fmt.Errorf("account %s (%s): %w", a.Name, a.ID, ErrAccountClosed)
+	case s == AccountStatusFrozen, s == AccountStatusDebitsFrozen && amount > 0, s == AccountStatusCreditsFrozen && amount < 0:
+		return fmt.Errorf("account %s (%s) is %s: %w", a.Name, a.ID, s, ErrAccountFrozen)
+	}
+	return nil
+}
+
+// FreezeAccount stops the account from receiving debits, credits or both, until it is unfrozen.
+// Pending holds can still be captured only if the account accepts their entries.
+func (l *Ledger) FreezeAccount(id uuid.UUID, debits, credits bool) error {
+	status := AccountStatusFrozen
+	switch {
+	case !debits && !credits:
+		return errors.New("nothing to freeze")
+	case !credits:
+		status = AccountStatusDebitsFrozen
+	case !debits:
+		status = AccountStatusCreditsFrozen
+	}
+
+	return l.updateAccount(id, AuditFreezeAccount, func(a *Account) error {
+		if a.Status.IsClosed() {
+			return fmt.Errorf("account %s (%s): %w", a.Name, a.ID, ErrAccountClosed)
+		}
+		a.Status = status
+		return nil
+	})
+}
+
+// UnfreezeAccount makes a frozen account active again.
+func (l *Ledger) UnfreezeAccount(id uuid.UUID) error {
+	return l.updateAccount(id, AuditUnfreezeAccount, func(a *Account) error {
+		if !a.Status.IsFrozen() {
+			return fmt.Errorf("account %s (%s) is not frozen", a.Name, a.ID)
+		}
+		a.Status = AccountStatusActive
+		return nil
+	})
+}
+
+// CloseAccount closes an active account, it rejects every entry after that.
+//
+// The account must have no pending holds. If its balance is not zero, a transaction with the given timestamp
+// sweeps the balance to the sweepTo account and is returned, otherwise closing fails with ErrAccountNotEmpty.
+// A nil sweepTo never sweeps.
+//
+// The sweep and the status are committed together with all the shards of the account, so a posting made
+// in the meantime is either swept too or rejected because the account is closed.
+func (l *Ledger) CloseAccount(id uuid.UUID, at time.Time, sweepTo uuid.UUID) (*Transaction, error) {
+	var sweep *Transaction
+	err := l.retry(func() error {
+		a, err := l.storage.Account(id)
+		if err != nil {
+			return err
+		}
+		if !a.Status.IsActive() {
+			return fmt.Errorf("account %s (%s) is %s, only active accounts can be closed", a.Name, a.ID, a.Status)
+		}
+		shards, err := l.storage.Balances(id)
+		if err != nil {
+			return err
+		}
+		// the shards with no entries yet are in the commit too, with version 0
+		for shard := 0; shard < max(a.Shards, 1); shard++ {
+			if !slices.ContainsFunc(shards, func(b AccountBalance) bool { return b.Shard == shard }) {
+				empty, err := l.storage.Balance(id, shard)
+				if err != nil {
+					return err
+				}
+				shards = append(shards, empty)
+			}
+		}
+		b := sumShards(a, shards)
+		if b.Pending != 0 {
+			return fmt.Errorf("account %s (%s) has pending holds of %d", a.Name, a.ID, b.Pending)
+		}
+
+		var c Commit
+		sweep = nil
+		if b.Balance != 0 {
+			if sweepTo == uuid.Nil {
+				return fmt.Errorf("account %s (%s) has balance %d: %w", a.Name, a.ID, b.Natural(), ErrAccountNotEmpty)
+			}
+			if sweepTo == id {
+				return errors.New("account cannot be swept to itself")
+			}
+			sweep = NewRegularTransaction(at)
+			sweep.AddEntries([]Entry{{Account: id, Amount: -b.Balance}, {Account: sweepTo, Amount: b.Balance}})
+			sweep.Metadata = map[string]string{MetadataSweep: id.String()}
+			if err := l.validate(sweep); err != nil {
+				return fmt.Errorf("sweeping account %s (%s): %w", a.Name, a.ID, err)
+			}
+			if c, err = l.prepare([]*Transaction{sweep}, nil); err != nil {
+				return fmt.Errorf("sweeping account %s (%s): %w", a.Name, a.ID, err)
+			}
+		}
+
+		// every shard is in the commit, a posting to any of them since they were read is a conflict
+		for _, shard := range shards {
+			if !slices.ContainsFunc(c.Balances, func(b AccountBalance) bool { return b.AccountID == id && b.Shard == shard.Shard }) {
+				c.Balances = append(c.Balances, shard)
+			}
+		}
+		before := a
+		a.Status = AccountStatusClosed
+		r, err := l.auditRecord(AuditCloseAccount, &before, a)
+		if err != nil {
+			return err
+		}
+		c.Updates = []Account{a}
+		c.Audit = append(c.Audit, r)
+		return l.storage.Commit(c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sweep != nil {
+		return sweep, l.publish(sweep)
+	}
+	return nil, nil
+}
+
+// ArchiveAccount archives a closed account.
+func (l *Ledger) ArchiveAccount(id uuid.UUID) error {
+	return l.updateAccount(id, AuditArchiveAccount, func(a *Account) error {
+		if a.Status != AccountStatusClosed {
+			return fmt.Errorf("account %s (%s) is not closed", a.Name, a.ID)
+		}
+		a.Status = AccountStatusArchived
+		return nil
+	})
+}
+
+// AccountsWithStatus returns the accounts in any of the given states, in creation order.
+// AccountStatusActive also selects the accounts with an empty status.
+func (l *Ledger) AccountsWithStatus(statuses ...AccountStatus) ([]Account, error) {
+	accounts, err := l.storage.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(accounts, func(a Account) bool {
+		status := a.Status
+		if status == "" {
+			status = AccountStatusActive
+		}
+		return !slices.Contains(statuses, status)
+	}), nil
+}
@@ -0,0 +1,70 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ErrBalanceLimit is wrapped by every BalanceLimitError, to be used with errors.Is.
+var ErrBalanceLimit = errors.New("account balance limit exceeded")
+
+// BalanceLimitError is returned when a posting would take the balance of an account beyond its limits.
+type BalanceLimitError struct {
+	Account   uuid.UUID
+	Name      string
+	Balance   int // The natural balance (available for the minimum, posted for the maximum) the account would have.
+	Limit     int
+	Minimum   bool // True if the minimum limit was exceeded, false for the maximum.
+	Shortfall int  // How much the balance goes beyond the limit, always positive.
+}
+
+func (e *BalanceLimitError) Error() string {
+	bound := "maximum"
+	if e.Minimum {
+		bound = "minimum"
+	}
+	return fmt.Sprintf("account %s (%s) would have balance %d, %d beyond its %s of %d", e.Name, e.Account, e.Balance, e.Shortfall, bound, e.Limit)
+}
+
+func (e *BalanceLimitError) Unwrap() error {
+	return ErrBalanceLimit
+}
+
+// SetBalanceLimits changes the balance limits of the account.
+// The new limits only apply to the next postings, the current balance is not checked.
+func (l *Ledger) SetBalanceLimits(id uuid.UUID, limits BalanceLimits) error {
+	if err := limits.validate(); err != nil {
+		return err
+	}
+	return l.updateAccount(id, AuditSetBalanceLimits, func(a *Account) error {
+		a.Limits = limits
+		return nil
+	})
+}
+
+func (l BalanceLimits) validate() error {
+	if l.Min != nil && l.Max != nil && *l.Min > *l.Max {
+		return fmt.Errorf("minimum balance %d is greater than the maximum %d", *l.Min, *l.Max)
+	}
+	return nil
+}
+
+// check returns an error if the change takes the balance beyond the limits of the account.
+//
+//   - The minimum is checked against the available balance, so the funds on hold cannot be spent.
+//   - The maximum is checked against the posted balance.
+//   - Only changes that move the balance away from the limit are rejected,
+//     so an account already beyond its limits can still be brought back.
+func (l BalanceLimits) check(a Account, current AccountBalance, change balanceChange) error {
+	sign := a.NormalSign()
+
+	if available := (current.Available + change.available) * sign; l.Min != nil && available < *l.Min && change.available*sign < 0 {
+		return &BalanceLimitError{Account: a.ID, Name: a.Name, Balance: available, Limit: *l.Min, Minimum: true, Shortfall: *l.Min - available}
+	}
+	if posted := (current.Balance + change.posted) * sign; l.Max != nil && posted > *l.Max && change.posted*sign > 0 {
+		return &BalanceLimitError{Account: a.ID, Name: a.Name, Balance: posted, Limit: *l.Max, Shortfall: posted - *l.Max}
+	}
+	return nil
+}
@@ -0,0 +1,573 @@
+package ledger
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStorage is a Storage that keeps everything in memory.
+//
+// It is useful for tests and for short-lived ledgers, nothing is persisted.
+type MemoryStorage struct {
+	mu           sync.RWMutex
+	accounts     map[uuid.UUID]Account
+	order        []uuid.UUID                          // Accounts in creation order.
+	codes        map[string]uuid.UUID                 // Accounts by code.
+	balances     map[uuid.UUID]map[int]AccountBalance // Account shards by account.
+	transactions map[uuid.UUID]*Transaction
+	journal      []*Transaction // Transactions in posting order.
+	entries      map[uuid.UUID][]PostedEntry
+	holds        map[uuid.UUID]Hold
+	snapshots    map[uuid.UUID][]AccountBalance // Balance snapshots by account, sorted by timestamp.
+	assertions   []BalanceAssertion
+	budgets      []Budget
+	clearings    map[EntryRef]Clearing
+	finished     map[uuid.UUID]time.Time // When the reconciliations were finished.
+	dimensions   map[Dimension]DimensionCatalog
+	links        []ChainLink
+	audit        []AuditRecord
+	outbox       []OutboxMessage
+	sent         int // Messages before this index were all sent.
+}
+
+// NewMemoryStorage creates an empty in-memory storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		accounts:     make(map[uuid.UUID]Account),
+		codes:        make(map[string]uuid.UUID),
+		balances:     make(map[uuid.UUID]map[int]AccountBalance),
+		transactions: make(map[uuid.UUID]*Transaction),
+		entries:      make(map[uuid.UUID][]PostedEntry),
+		holds:        make(map[uuid.UUID]Hold),
+		snapshots:    make(map[uuid.UUID][]AccountBalance),
+		clearings:    make(map[EntryRef]Clearing),
+		finished:     make(map[uuid.UUID]time.Time),
+		dimensions:   make(map[Dimension]DimensionCatalog),
+	}
+}
+
+func (s *MemoryStorage) SaveAccount(a Account, audit AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.accounts[a.ID]
+	if old.Version != a.Version {
+		return ErrVersionConflict
+	}
+	if id, ok := s.codes[a.Code]; ok && id != a.ID {
+		return ErrDuplicateAccountCode
+	}
+	if !ok {
+		s.order = append(s.order, a.ID)
+	}
+	s.putAccount(old, a)
+	s.audit = append(s.audit, audit)
+	return nil
+}
+
+// putAccount stores the account with its version incremented, replacing the old one.
+func (s *MemoryStorage) putAccount(old, a Account) {
+	delete(s.codes, old.Code)
+	if a.Code != "" {
+		s.codes[a.Code] = a.ID
+	}
+	a.Version = old.Version + 1
+	s.accounts[a.ID] = cloneAccount(a)
+}
+
+func (s *MemoryStorage) AuditLog(q AuditQuery) ([]AuditRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var records []AuditRecord
+	for _, r := range s.audit {
+		if q.Matches(r) {
+			r.Before, r.After = slices.Clone(r.Before), slices.Clone(r.After)
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (s *MemoryStorage) Account(id uuid.UUID) (Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.accounts[id]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return cloneAccount(a), nil
+}
+
+func (s *MemoryStorage) AccountByCode(code string) (Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.codes[code]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return cloneAccount(s.accounts[id]), nil
+}
+
+func (s *MemoryStorage) Accounts() ([]Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	accounts := make([]Account, 0, len(s.order))
+	for _, id := range s.order {
+		accounts = append(accounts, cloneAccount(s.accounts[id]))
+	}
+	return accounts, nil
+}
+
+func (s *MemoryStorage) Balance(account uuid.UUID, shard int) (AccountBalance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.balances[account][shard]
+	if !ok {
+		b = AccountBalance{AccountID: account, Shard: shard}
+	}
+	return b, nil
+}
+
+func (s *MemoryStorage) Balances(account uuid.UUID) ([]AccountBalance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	balances := make([]AccountBalance, 0, len(s.balances[account]))
+	for _, b := range s.balances[account] {
+		balances = append(balances, b)
+	}
+	slices.SortFunc(balances, func(a, b AccountBalance) int { return a.Shard - b.Shard })
+	return balances, nil
+}
+
+func (s *MemoryStorage) Commit(c Commit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range c.Transactions {
+		if _, ok := s.transactions[t.Id]; ok {
+			return ErrDuplicateTransaction
+		}
+	}
+	for _, b := range c.Balances {
+		if s.balances[b.AccountID][b.Shard].Version != b.Version {
+			return ErrVersionConflict
+		}
+	}
+	for _, h := range c.Holds {
+		if s.holds[h.ID].Version != h.Version {
+			return ErrVersionConflict
+		}
+	}
+	for _, a := range slices.Concat(c.Accounts, c.Updates) {
+		if s.accounts[a.ID].Version != a.Version {
+			return ErrVersionConflict
+		}
+	}
+	for _, a := range c.Updates {
+		if id, ok := s.codes[a.Code]; ok && id != a.ID {
+			return ErrDuplicateAccountCode
+		}
+	}
+
+	for _, t := range c.Transactions {
+		t = cloneTransaction(t)
+		s.transactions[t.Id] = t
+		s.journal = append(s.journal, t)
+		s.links = append(s.links, s.link(t))
+		for i, e := range t.Entries {
+			s.entries[e.Account] = append(s.entries[e.Account], PostedEntry{
+				EntryRef:  EntryRef{Transaction: t.Id, Index: i},
+				Entry:     e,
+				Timestamp: t.Timestamp,
+			})
+			s.invalidateSnapshots(e.Account, t.Timestamp)
+		}
+	}
+	for _, b := range c.Balances {
+		b.Version++
+		if s.balances[b.AccountID] == nil {
+			s.balances[b.AccountID] = make(map[int]AccountBalance)
+		}
+		s.balances[b.AccountID][b.Shard] = b
+	}
+	for _, h := range c.Holds {
+		h.Version++
+		h.Transaction = cloneTransaction(h.Transaction)
+		s.holds[h.ID] = h
+	}
+	for _, a := range c.Updates {
+		s.putAccount(s.accounts[a.ID], a)
+	}
+	s.audit = append(s.audit, c.Audit...)
+	s.outbox = append(s.outbox, c.Outbox...)
+	return nil
+}
+
+// link chains the transaction to the last posted one.
+func (s *MemoryStorage) link(t *Transaction) ChainLink {
+	var prev Hash
+	if len(s.links) > 0 {
+		prev = s.links[len(s.links)-1].Hash
+	}
+	return ChainLink{Transaction: t.Id, Prev: prev, Hash: ChainHash(prev, t)}
+}
+
+func (s *MemoryStorage) Links() ([]ChainLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.links), nil
+}
+
+// invalidateSnapshots deletes the snapshots of the account after the given time.
+func (s *MemoryStorage) invalidateSnapshots(account uuid.UUID, at time.Time) {
+	snapshots := s.snapshots[account]
+	i, _ := slices.BinarySearchFunc(snapshots, at, func(b AccountBalance, at time.Time) int {
+		if b.Timestamp.After(at) {
+			return 1
+		}
+		return -1
+	})
+	s.snapshots[account] = snapshots[:i]
+}
+
+func (s *MemoryStorage) Snapshot(account uuid.UUID, at time.Time) (AccountBalance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshots := s.snapshots[account]
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !snapshots[i].Timestamp.After(at) {
+			return snapshots[i], nil
+		}
+	}
+	return AccountBalance{}, ErrSnapshotNotFound
+}
+
+func (s *MemoryStorage) SaveSnapshots(b SnapshotBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := s.snapshots[b.Account]
+	if !b.Base.Timestamp.IsZero() {
+		i, found := slices.BinarySearchFunc(saved, b.Base.Timestamp, func(b AccountBalance, at time.Time) int { return b.Timestamp.Compare(at) })
+		if !found || saved[i].Balance != b.Base.Balance {
+			return ErrVersionConflict
+		}
+	}
+	entries := 0
+	for _, e := range s.entries[b.Account] {
+		if !e.Timestamp.Before(b.Base.Timestamp) && e.Timestamp.Before(b.To) {
+			entries++
+		}
+	}
+	if entries != b.Entries {
+		return ErrVersionConflict
+	}
+
+	for _, snapshot := range b.Snapshots {
+		i, found := slices.BinarySearchFunc(saved, snapshot.Timestamp, func(b AccountBalance, at time.Time) int { return b.Timestamp.Compare(at) })
+		if found {
+			saved[i] = snapshot
+		} else {
+			saved = slices.Insert(saved, i, snapshot)
+		}
+	}
+	s.snapshots[b.Account] = saved
+	return nil
+}
+
+func (s *MemoryStorage) SaveAssertion(a BalanceAssertion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := slices.IndexFunc(s.assertions, func(b BalanceAssertion) bool { return b.ID == a.ID }); i >= 0 {
+		s.assertions[i] = a
+	} else {
+		s.assertions = append(s.assertions, a)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Assertions() ([]BalanceAssertion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.assertions), nil
+}
+
+func (s *MemoryStorage) SaveBudget(b Budget) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.budgets {
+		if o.ID != b.ID && b.Overlaps(o) {
+			return ErrBudgetOverlap
+		}
+	}
+	b.Dimensions = maps.Clone(b.Dimensions)
+	if i := slices.IndexFunc(s.budgets, func(o Budget) bool { return o.ID == b.ID }); i >= 0 {
+		s.budgets[i] = b
+	} else {
+		s.budgets = append(s.budgets, b)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Budgets() ([]Budget, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	budgets := make([]Budget, len(s.budgets))
+	for i, b := range s.budgets {
+		b.Dimensions = maps.Clone(b.Dimensions)
+		budgets[i] = b
+	}
+	return budgets, nil
+}
+
+func (s *MemoryStorage) Clearing(ref EntryRef) (Clearing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.clearings[ref]
+	if !ok {
+		return Clearing{}, ErrClearingNotFound
+	}
+	return c, nil
+}
+
+func (s *MemoryStorage) Clearings(reconciliation uuid.UUID) ([]Clearing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var clearings []Clearing
+	for _, c := range s.clearings {
+		if c.Reconciliation == reconciliation {
+			clearings = append(clearings, c)
+		}
+	}
+	slices.SortFunc(clearings, func(a, b Clearing) int { return a.Line - b.Line })
+	return clearings, nil
+}
+
+func (s *MemoryStorage) SaveClearings(clearings []Clearing) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveClearings(clearings)
+}
+
+func (s *MemoryStorage) saveClearings(clearings []Clearing) error {
+	for _, c := range clearings {
+		if err := s.checkClearing(c); err != nil {
+			return err
+		}
+	}
+	for _, c := range clearings {
+		c.Version++
+		s.clearings[c.Entry] = c
+	}
+	return nil
+}
+
+// checkClearing checks the clearing can replace the stored one.
+func (s *MemoryStorage) checkClearing(c Clearing) error {
+	stored := s.clearings[c.Entry]
+	if stored.Version != c.Version {
+		return ErrVersionConflict
+	}
+	if _, ok := s.finished[c.Reconciliation]; ok {
+		return ErrReconciliationFinished
+	}
+	if _, ok := s.finished[stored.Reconciliation]; ok && stored.Version > 0 {
+		return ErrReconciliationFinished
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteClearing(c Clearing) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clearings[c.Entry]; !ok {
+		return ErrClearingNotFound
+	}
+	if err := s.checkClearing(c); err != nil {
+		return err
+	}
+	delete(s.clearings, c.Entry)
+	return nil
+}
+
+func (s *MemoryStorage) FinishReconciliation(id uuid.UUID, at time.Time, clearings []Clearing) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.finished[id]; ok {
+		return ErrReconciliationFinished
+	}
+	if err := s.saveClearings(clearings); err != nil {
+		return err
+	}
+	s.finished[id] = at
+	return nil
+}
+
+func (s *MemoryStorage) ReconciliationFinished(id uuid.UUID) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.finished[id], nil
+}
+
+func (s *MemoryStorage) Hold(id uuid.UUID) (Hold, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.holds[id]
+	if !ok {
+		return Hold{}, ErrHoldNotFound
+	}
+	h.Transaction = cloneTransaction(h.Transaction)
+	return h, nil
+}
+
+func (s *MemoryStorage) Holds(status HoldStatus) ([]Hold, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var holds []Hold
+	for _, h := range s.holds {
+		if h.Status == status {
+			h.Transaction = cloneTransaction(h.Transaction)
+			holds = append(holds, h)
+		}
+	}
+	slices.SortFunc(holds, func(a, b Hold) int { return a.ExpiresAt.Compare(b.ExpiresAt) })
+	return holds, nil
+}
+
+func (s *MemoryStorage) Transaction(id uuid.UUID) (*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.transactions[id]
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+	return cloneTransaction(t), nil
+}
+
+func (s *MemoryStorage) Transactions() ([]*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	transactions := make([]*Transaction, len(s.journal))
+	for i, t := range s.journal {
+		transactions[i] = cloneTransaction(t)
+	}
+	return transactions, nil
+}
+
+func (s *MemoryStorage) Entries(account uuid.UUID, from, to time.Time) ([]PostedEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []PostedEntry
+	for _, e := range s.entries[account] {
+		if !from.IsZero() && e.Timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !e.Timestamp.Before(to) {
+			continue
+		}
+		e.Entry = e.Entry.clone()
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *MemoryStorage) EntriesByMetadata(key, value string) ([]PostedEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []PostedEntry
+	for _, t := range s.journal {
+		for i, e := range t.Entries {
+			if v, ok := e.Metadata[key]; ok && v == value {
+				entries = append(entries, PostedEntry{EntryRef: EntryRef{Transaction: t.Id, Index: i}, Entry: e.clone(), Timestamp: t.Timestamp})
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (s *MemoryStorage) QueryTransactions(q TransactionQuery) (TransactionPage, error) {
+	isAfter, err := q.IsAfterFunc()
+	if err != nil {
+		return TransactionPage{}, err
+	}
+
+	s.mu.RLock()
+	var selected []*Transaction
+	for _, t := range s.journal {
+		if q.Matches(t) && isAfter(t) {
+			selected = append(selected, t)
+		}
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(selected, q.Compare)
+	limit := q.Limit
+	if limit == 0 {
+		limit = DefaultQueryLimit
+	}
+
+	var page TransactionPage
+	if len(selected) > limit {
+		selected = selected[:limit]
+		page.Next = q.Cursor(selected[limit-1])
+	}
+	for _, t := range selected {
+		page.Transactions = append(page.Transactions, cloneTransaction(t))
+	}
+	return page, nil
+}
+
+func (s *MemoryStorage) SaveDimension(c DimensionCatalog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Values = slices.Clone(c.Values)
+	s.dimensions[c.Dimension] = c
+	return nil
+}
+
+func (s *MemoryStorage) Dimension(d Dimension) (DimensionCatalog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.dimensions[d]
+	if !ok {
+		return DimensionCatalog{}, ErrDimensionNotFound
+	}
+	c.Values = slices.Clone(c.Values)
+	return c, nil
+}
+
+func (s *MemoryStorage) PendingOutbox(max int) ([]OutboxMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var pending []OutboxMessage
+	for _, m := range s.outbox[s.sent:] {
+		if max > 0 && len(pending) == max {
+			break
+		}
+		if m.SentAt.IsZero() {
+			m.Event.Transaction = cloneTransaction(m.Event.Transaction)
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func (s *MemoryStorage) MarkOutboxSent(ids []uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	marked := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		marked[id] = true
+	}
+	for i := s.sent; i < len(s.outbox); i++ {
+		if marked[s.outbox[i].ID] {
+			s.outbox[i].SentAt = at
+		}
+	}
+	for s.sent < len(s.outbox) && !s.outbox[s.sent].SentAt.IsZero() {
+		s.sent++
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MetadataReversal is the metadata key that links a reversal to the transaction it reverses, see Reverse.
+const MetadataReversal = "reverses"
+
+// Post validates the transaction and commits it, updating the balances of its accounts.
+//
+//   - If the transaction has no Id, a new one is assigned.
+//   - The transaction must be balanced, have a timestamp and all its accounts must exist and accept its entries,
+//     see [AccountStatus].
+//   - The dimensions of the entries must be in the registered catalogs.
+//   - The balances of the accounts must stay inside their limits, otherwise a *BalanceLimitError is returned.
+//   - A transaction can only be posted once.
+//   - If a publisher is set and it fails, the transaction stays posted and an error wrapping ErrNotPublished is returned.
+//
+// Post is safe for concurrent use. The balances are updated with optimistic locking:
+// if another posting changed one of the accounts in the meantime, the commit is retried with fresh balances.
+func (l *Ledger) Post(t *Transaction) error {
+	if err := l.validate(t); err != nil {
+		return err
+	}
+	return l.commit([]*Transaction{t})
+}
+
+// PostBatch validates all the transactions and commits them together, all of them are posted or none is.
+//
+// Each transaction is validated as in Post, and if any of them is invalid nothing is posted
+// and a *BatchError reporting every invalid transaction is returned.
+// A batch that would take an account beyond its limits also returns a *BatchError,
+// with the *BalanceLimitError of the first transaction that takes it there.
+// The balance of an account touched by many transactions of the batch is updated only once.
+//
+// The transactions without an Id are assigned one, but only if the batch is posted.
+func (l *Ledger) PostBatch(transactions []*Transaction) (err error) {
+	if len(transactions) == 0 {
+		return errors.New("batch has no transactions")
+	}
+
+	var assigned []*Transaction
+	for _, t := range transactions {
+		if t.Id == uuid.Nil {
+			assigned = append(assigned, t)
+		}
+	}
+	defer func() {
+		if err != nil && !errors.Is(err, ErrNotPublished) {
+			for _, t := range assigned {
+				t.Id = uuid.Nil
+			}
+		}
+	}()
+
+	batchErr := &BatchError{}
+	seen := make(map[uuid.UUID]bool, len(transactions))
+	for i, t := range transactions {
+		err := l.validate(t)
+		if err == nil && seen[t.Id] {
+			err = ErrDuplicateTransaction
+		}
+		if err != nil {
+			batchErr.Failures = append(batchErr.Failures, BatchFailure{Index: i, Err: err})
+			continue
+		}
+		seen[t.Id] = true
+	}
+	if len(batchErr.Failures) > 0 {
+		return batchErr
+	}
+
+	err = l.commit(transactions)
+	var limitErr *BalanceLimitError
+	if errors.As(err, &limitErr) {
+		return l.limitFailure(transactions, limitErr)
+	}
+	return err
+}
+
+// limitFailure finds the first transaction of the batch that takes the account of the error beyond its limits,
+// by preparing the batch up to each transaction.
+func (l *Ledger) limitFailure(transactions []*Transaction, limitErr *BalanceLimitError) error {
+	for i := range transactions {
+		_, err := l.prepare(transactions[:i+1], nil)
+		var e *BalanceLimitError
+		if errors.As(err, &e) && e.Account == limitErr.Account {
+			return &BatchError{Failures: []BatchFailure{{Index: i, Err: e}}}
+		}
+	}
+	return &BatchError{Failures: []BatchFailure{{Index: len(transactions) - 1, Err: limitErr}}}
+}
+
+// Reverse posts a transaction with the opposite entries of the given one, at the given timestamp, and returns it.
+//
+//   - The reversal has the journal and the metadata of the transaction, and its id in MetadataReversal.
+//   - Its id only depends on the reversed transaction, so a transaction can only be reversed once,
+//     reversing it again fails with ErrDuplicateTransaction.
+//   - The reversal is recorded in the audit log, with the actor and the reason of the ledger, see As.
+func (l *Ledger) Reverse(id uuid.UUID, at time.Time) (*Transaction, error) {
+	t, err := l.storage.Transaction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	reversal := NewRegularTransaction(at)
+	reversal.Id = uuid.NewSHA1(id, []byte(MetadataReversal))
+	reversal.Journal = t.Journal
+	for _, e := range t.Entries {
+		e.Amount = -e.Amount
+		reversal.Entries = append(reversal.Entries, e)
+	}
+	reversal.Metadata = maps.Clone(t.Metadata)
+	if reversal.Metadata == nil {
+		reversal.Metadata = make(map[string]string, 1)
+	}
+	reversal.Metadata[MetadataReversal] = id.String()
+
+	// a reversal that failed to be published is still posted
+	err = l.Post(reversal)
+	if err != nil && !errors.Is(err, ErrNotPublished) {
+		return nil, err
+	}
+	return reversal, err
+}
+
+// BatchFailure is a transaction of a batch that failed validation or took an account beyond its limits.
+type BatchFailure struct {
+	Index int // The position of the transaction in the batch.
+	Err   error
+}
+
+// BatchError is returned by PostBatch when some of the transactions cannot be posted, nothing of the batch is posted.
+type BatchError struct {
+	Failures []BatchFailure
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = fmt.Sprintf("transaction %d: %v", f.Index, f.Err)
+	}
+	return fmt.Sprintf("%d invalid transactions in batch: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the failed transactions, so errors.Is and errors.As can inspect them.
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// validate checks the transaction can be posted, assigning it an Id if it has none.
+func (l *Ledger) validate(t *Transaction) error {
+	if _, err := t.IsBalanced(); err != nil {
+		return err
+	}
+	if t.Timestamp.IsZero() {
+		return errors.New("transaction has no timestamp")
+	}
+	for _, e := range t.Entries {
+		a, err := l.storage.Account(e.Account)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", e.Account, err)
+		}
+		if err := a.accepts(e.Amount); err != nil {
+			return err
+		}
+		if err := l.validateDimensions(e); err != nil {
+			return err
+		}
+	}
+
+	if t.Id == uuid.Nil {
+		t.Id = uuid.New()
+	} else if _, err := l.storage.Transaction(t.Id); err == nil {
+		return ErrDuplicateTransaction
+	}
+	return nil
+}
+
+// commit writes the validated transactions in a single storage commit, retrying on concurrent updates,
+// and publishes their events.
+func (l *Ledger) commit(transactions []*Transaction) error {
+	err := l.retry(func() error {
+		c, err := l.prepare(transactions, nil)
+		if err != nil {
+			return err
+		}
+		return l.storage.Commit(c)
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, t := range transactions {
+		if err := l.publish(t); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// balanceChange is the change a commit makes to the balance of an account.
+type balanceChange struct {
+	posted    int
+	pending   int
+	available int
+	timestamp time.Time
+}
+
+// prepare reads the current balances of the accounts touched by the transactions and holds,
+// and builds the commit that posts the transactions and places or releases the holds.
+//
+//   - A hold with status HoldPending is placed, any other status releases it.
+//   - The balances keep the version they were read with, so the storage can detect concurrent updates.
+func (l *Ledger) prepare(transactions []*Transaction, holds []Hold) (Commit, error) {
+	accounts := make(map[uuid.UUID]Account)
+	changes := make(map[uuid.UUID]*balanceChange)
+	change := func(id uuid.UUID) (*balanceChange, Account, error) {
+		a, ok := accounts[id]
+		if !ok {
+			var err error
+			if a, err = l.storage.Account(id); err != nil {
+				return nil, a, fmt.Errorf("account %s: %w", id, err)
+			}
+			accounts[id] = a
+			changes[id] = &balanceChange{}
+		}
+		return changes[id], a, nil
+	}
+
+	for _, t := range transactions {
+		for _, e := range t.Entries {
+			c, a, err := change(e.Account)
+			if err != nil {
+				return Commit{}, err
+			}
+			// checked again with the account read for this commit, in case it was closed after the validation
+			if err := a.accepts(e.Amount); err != nil {
+				return Commit{}, err
+			}
+			c.posted += e.Amount
+			c.available += e.Amount
+			if t.Timestamp.After(c.timestamp) {
+				c.timestamp = t.Timestamp
+			}
+		}
+	}
+
+	for _, h := range holds {
+		sign := -1
+		if h.Status == HoldPending {
+			sign = 1
+		}
+		for id, amount := range h.amounts() {
+			c, a, err := change(id)
+			if err != nil {
+				return Commit{}, err
+			}
+			if err := a.accepts(amount); err != nil && h.Status == HoldPending {
+				return Commit{}, err
+			}
+			c.pending += sign * amount
+			// only the pending amounts that decrease the account reduce what is available
+			if amount*a.NormalSign() < 0 {
+				c.available += sign * amount
+			}
+		}
+	}
+
+	balances := make([]AccountBalance, 0, len(changes))
+	for id, change := range changes {
+		a := accounts[id]
+		shard := 0
+		if a.Shards > 1 {
+			shard = rand.IntN(a.Shards)
+		}
+
+		// an account with limits takes all its shards in the commit, so the limits are checked
+		// against a total that no concurrent posting can change without a version conflict
+		var shards []AccountBalance
+		var err error
+		if a.Limits.IsSet() {
+			if shards, err = l.storage.Balances(id); err != nil {
+				return Commit{}, err
+			}
+			if err := a.Limits.check(a, sumShards(a, shards), *change); err != nil {
+				return Commit{}, err
+			}
+		}
+		i := slices.IndexFunc(shards, func(b AccountBalance) bool { return b.Shard == shard })
+		if i < 0 {
+			b, err := l.storage.Balance(id, shard)
+			if err != nil {
+				return Commit{}, err
+			}
+			shards = append(shards, b)
+			i = len(shards) - 1
+		}
+
+		b := &shards[i]
+		b.AccountID = a.ID
+		b.AccountType = a.AccountType
+		b.SubType = a.SubType
+		b.Balance += change.posted
+		b.Pending += change.pending
+		b.Available += change.available
+		if change.timestamp.After(b.Timestamp) {
+			b.Timestamp = change.timestamp
+		}
+		balances = append(balances, shards...)
+	}
+
+	c := Commit{
+		Transactions: transactions,
+		Balances:     balances,
+		Holds:        holds,
+	}
+	// closing a period and reversing a transaction are recorded in the audit log with the posting
+	for _, t := range transactions {
+		r, ok, err := l.transactionAudit(t)
+		if err != nil {
+			return Commit{}, err
+		}
+		if ok {
+			c.Audit = append(c.Audit, r)
+		}
+	}
+	// the accounts are checked again by the storage, in case they were closed or frozen after being read
+	for _, a := range accounts {
+		c.Accounts = append(c.Accounts, a)
+	}
+	if l.outbox {
+		for _, t := range transactions {
+			c.Outbox = append(c.Outbox, newOutboxMessage(t))
+		}
+	}
+	return c, nil
+}
+
+// retry runs the function until it does not fail with ErrVersionConflict, up to the maximum number of retries.
+// It waits a random and growing delay between the attempts so the competing postings spread out.
+func (l *Ledger) retry(f func() error) error {
+	var err error
+	for attempt := 0; attempt <= l.maxRetries; attempt++ {
+		if err = f(); !errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+		time.Sleep(time.Duration(rand.Int64N(int64(attempt+1) * int64(50*time.Microsecond))))
+	}
+	return fmt.Errorf("giving up after %d retries: %w", l.maxRetries, err)
+}
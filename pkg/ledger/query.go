@@ -0,0 +1,243 @@
+package ledger
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultQueryLimit is the page size of a TransactionQuery without a Limit.
+const DefaultQueryLimit = 100
+
+var ErrInvalidCursor = errors.New("invalid query cursor")
+
+// SortField is the order of the results of a TransactionQuery, ties are broken by the transaction Id.
+type SortField string
+
+const (
+	SortByTimestamp SortField = "timestamp"
+	SortByAmount    SortField = "amount"
+)
+
+// MetadataOp is the comparison of a MetadataPredicate.
+type MetadataOp string
+
+const (
+	MetadataEquals    MetadataOp = "="
+	MetadataNotEquals MetadataOp = "!="
+	MetadataContains  MetadataOp = "~" // Case-insensitive substring.
+	MetadataExists    MetadataOp = "?"
+)
+
+// MetadataPredicate is a condition on a metadata key. A transaction satisfies it if its metadata,
+// or the metadata of any of its entries, does.
+// MetadataNotEquals is the opposite: neither the transaction nor any of its entries has the key with the value,
+// so it is also satisfied by a missing key.
+type MetadataPredicate struct {
+	Key   string
+	Op    MetadataOp
+	Value string
+}
+
+func (p MetadataPredicate) matches(t *Transaction) bool {
+	if p.Op == MetadataNotEquals {
+		equals := MetadataPredicate{Key: p.Key, Op: MetadataEquals, Value: p.Value}
+		return !equals.matches(t)
+	}
+	return p.matchesMap(t.Metadata) || slices.ContainsFunc(t.Entries, func(e Entry) bool { return p.matchesMap(e.Metadata) })
+}
+
+func (p MetadataPredicate) matchesMap(metadata map[string]string) bool {
+	v, ok := metadata[p.Key]
+	switch p.Op {
+	case MetadataEquals:
+		return ok && v == p.Value
+	case MetadataContains:
+		return ok && strings.Contains(strings.ToLower(v), strings.ToLower(p.Value))
+	case MetadataExists:
+		return ok
+	}
+	return false
+}
+
+// TransactionQuery selects posted transactions, the zero value of every filter matches everything.
+//
+// The amount of a transaction is the sum of its increases, see [Transaction.TotalIncreases].
+type TransactionQuery struct {
+	Accounts  []uuid.UUID // Transactions with an entry in any of the accounts or their descendants.
+	Journals  []uuid.UUID
+	Types     []TransactionType
+	From      time.Time // Inclusive.
+	To        time.Time // Exclusive.
+	MinAmount *int      // Inclusive.
+	MaxAmount *int      // Inclusive.
+	Metadata  []MetadataPredicate
+	Text      string // Case-insensitive text in the metadata values or the entry descriptions.
+
+	SortBy     SortField // SortByTimestamp if empty.
+	Descending bool
+	Limit      int    // DefaultQueryLimit if zero.
+	After      string // The cursor of the previous page, empty for the first one.
+}
+
+// TransactionPage is a page of the results of a TransactionQuery.
+type TransactionPage struct {
+	Transactions []*Transaction
+	Next         string // The cursor of the next page, empty if this is the last one.
+}
+
+// QueryTransactions returns a page of the posted transactions selected by the query.
+func (l *Ledger) QueryTransactions(q TransactionQuery) (TransactionPage, error) {
+	if q.SortBy != "" && q.SortBy != SortByTimestamp && q.SortBy != SortByAmount {
+		return TransactionPage{}, fmt.Errorf("unknown sort field %q", q.SortBy)
+	}
+	if q.Limit < 0 {
+		return TransactionPage{}, errors.New("query limit cannot be negative")
+	}
+	if _, _, err := q.cursor(); err != nil {
+		return TransactionPage{}, err
+	}
+
+	if len(q.Accounts) > 0 {
+		accounts, err := l.storage.Accounts()
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		q.Accounts = descendants(accounts, q.Accounts)
+	}
+	return l.storage.QueryTransactions(q)
+}
+
+// descendants returns the accounts and all their descendants.
+func descendants(accounts []Account, roots []uuid.UUID) []uuid.UUID {
+	children := make(map[uuid.UUID][]uuid.UUID)
+	for _, a := range accounts {
+		children[a.ParentID] = append(children[a.ParentID], a.ID)
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	var all []uuid.UUID
+	for queue := slices.Clone(roots); len(queue) > 0; queue = queue[1:] {
+		id := queue[0]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		all = append(all, id)
+		queue = append(queue, children[id]...)
+	}
+	return all
+}
+
+// Matches returns true if the transaction passes the filters of the query.
+// The Accounts are matched as they are, the Ledger expands them with their descendants before calling the storage.
+func (q TransactionQuery) Matches(t *Transaction) bool {
+	if len(q.Journals) > 0 && !slices.Contains(q.Journals, t.Journal) {
+		return false
+	}
+	if len(q.Types) > 0 && !slices.Contains(q.Types, t.TransactionType) {
+		return false
+	}
+	if (!q.From.IsZero() && t.Timestamp.Before(q.From)) || (!q.To.IsZero() && !t.Timestamp.Before(q.To)) {
+		return false
+	}
+	amount := t.TotalIncreases()
+	if (q.MinAmount != nil && amount < *q.MinAmount) || (q.MaxAmount != nil && amount > *q.MaxAmount) {
+		return false
+	}
+	if len(q.Accounts) > 0 && !slices.ContainsFunc(t.Entries, func(e Entry) bool { return slices.Contains(q.Accounts, e.Account) }) {
+		return false
+	}
+	for _, p := range q.Metadata {
+		if !p.matches(t) {
+			return false
+		}
+	}
+	return q.Text == "" || containsText(t, strings.ToLower(q.Text))
+}
+
+func containsText(t *Transaction, text string) bool {
+	in := func(s string) bool { return strings.Contains(strings.ToLower(s), text) }
+	for _, v := range t.Metadata {
+		if in(v) {
+			return true
+		}
+	}
+	for _, e := range t.Entries {
+		if in(e.Description) {
+			return true
+		}
+		for _, v := range e.Metadata {
+			if in(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortKey returns the value of the sort field of the transaction.
+func (q TransactionQuery) sortKey(t *Transaction) int64 {
+	if q.SortBy == SortByAmount {
+		return int64(t.TotalIncreases())
+	}
+	return t.Timestamp.UnixNano()
+}
+
+// Compare orders two transactions by the sort of the query.
+func (q TransactionQuery) Compare(a, b *Transaction) int {
+	c := cmp.Or(cmp.Compare(q.sortKey(a), q.sortKey(b)), strings.Compare(a.Id.String(), b.Id.String()))
+	if q.Descending {
+		return -c
+	}
+	return c
+}
+
+// Cursor returns the cursor of the page that starts after the transaction.
+func (q TransactionQuery) Cursor(t *Transaction) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(q.sortKey(t), 10) + ":" + t.Id.String()))
+}
+
+// IsAfterFunc decodes the cursor of the query and returns a function that is true for the transactions
+// that come after it, or for every transaction if there is no cursor.
+func (q TransactionQuery) IsAfterFunc() (func(t *Transaction) bool, error) {
+	if q.After == "" {
+		return func(*Transaction) bool { return true }, nil
+	}
+	key, id, err := q.cursor()
+	if err != nil {
+		return nil, err
+	}
+	cursor := id.String()
+	return func(t *Transaction) bool {
+		c := cmp.Or(cmp.Compare(q.sortKey(t), key), strings.Compare(t.Id.String(), cursor))
+		if q.Descending {
+			return c < 0
+		}
+		return c > 0
+	}, nil
+}
+
+func (q TransactionQuery) cursor() (int64, uuid.UUID, error) {
+	if q.After == "" {
+		return 0, uuid.Nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.After)
+	if err != nil {
+		return 0, uuid.Nil, ErrInvalidCursor
+	}
+	k, i, ok := strings.Cut(string(data), ":")
+	key, err1 := strconv.ParseInt(k, 10, 64)
+	id, err2 := uuid.Parse(i)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, uuid.Nil, ErrInvalidCursor
+	}
+	return key, id, nil
+}
@@ -0,0 +1,152 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrSnapshotNotFound is returned by the storage when an account has no snapshot before the given time.
+var ErrSnapshotNotFound = errors.New("balance snapshot not found")
+
+// DefaultSnapshotInterval is the interval between balance snapshots, unless WithSnapshotInterval is used.
+const DefaultSnapshotInterval = 24 * time.Hour
+
+// WithSnapshotInterval sets the interval between the balance snapshots built by RefreshSnapshots.
+// The snapshots are taken at the multiples of the interval since the zero time, so daily snapshots are at midnight UTC.
+func WithSnapshotInterval(d time.Duration) Option {
+	return func(l *Ledger) {
+		l.snapshotInterval = d
+	}
+}
+
+// RefreshSnapshots builds the missing balance snapshots of every account up to the given time
+// and returns how many were written. It is meant to be called periodically, see MaintainSnapshots.
+//
+// A snapshot is the AccountBalance of an account at the start of an interval,
+// so BalanceAt only reads the latest snapshot and sums the entries after it.
+//   - The Timestamp of a snapshot is the start of the interval and its Balance sums the entries before it.
+//   - Only the intervals with entries get a snapshot, a quiet account does not get one every day.
+//   - A transaction posted with a timestamp before some snapshots of its accounts invalidates them,
+//     they are built again by the next refresh.
+//   - The snapshots of an account are discarded and built again if a transaction posted in the meantime
+//     has an entry before the end of the new snapshots, the postings after it do not matter.
+//
+// An account that fails does not stop the refresh of the others, the errors are joined.
+func (l *Ledger) RefreshSnapshots(until time.Time) (int, error) {
+	accounts, err := l.storage.Accounts()
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	var errs []error
+	for _, a := range accounts {
+		err := l.retry(func() error {
+			n, err := l.refreshSnapshots(a, until)
+			if err == nil {
+				written += n
+			}
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("account %s: %w", a.ID, err))
+		}
+	}
+	return written, errors.Join(errs...)
+}
+
+// SnapshotBatch is the snapshots of an account built from its Base snapshot and its entries in [Base.Timestamp, To).
+//
+// The storage rejects the batch if it is stale: if the Base is not stored anymore with the same balance,
+// or if the account does not have exactly the given number of Entries in the range.
+// A Base with a zero Timestamp is the start of the account, before any entry.
+type SnapshotBatch struct {
+	Account   uuid.UUID
+	Base      AccountBalance
+	To        time.Time
+	Entries   int
+	Snapshots []AccountBalance
+}
+
+// refreshSnapshots builds the snapshots of the account after its latest one, up to the given time.
+func (l *Ledger) refreshSnapshots(a Account, until time.Time) (int, error) {
+	interval := l.snapshotInterval
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+
+	latest, err := l.storage.Snapshot(a.ID, until)
+	if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+		return 0, err
+	}
+	last := until.Truncate(interval)
+	if !last.After(latest.Timestamp) {
+		return 0, nil
+	}
+	entries, err := l.storage.Entries(a.ID, latest.Timestamp, last)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	// the entries are in posting order, a backdated one can come after later ones
+	slices.SortStableFunc(entries, func(x, y PostedEntry) int { return x.Timestamp.Compare(y.Timestamp) })
+	balance := latest.Balance
+	var snapshots []AccountBalance
+	for i, e := range entries {
+		balance += e.Amount
+		start := e.Timestamp.Truncate(interval).Add(interval)
+		if i == len(entries)-1 || !entries[i+1].Timestamp.Before(start) {
+			snapshots = append(snapshots, AccountBalance{
+				AccountID:   a.ID,
+				AccountType: a.AccountType,
+				SubType:     a.SubType,
+				Balance:     balance,
+				Timestamp:   start,
+			})
+		}
+	}
+	batch := SnapshotBatch{Account: a.ID, Base: latest, To: last, Entries: len(entries), Snapshots: snapshots}
+	if err := l.storage.SaveSnapshots(batch); err != nil {
+		return 0, err
+	}
+	return len(snapshots), nil
+}
+
+// MaintainSnapshots refreshes the balance snapshots every given duration until the context is done,
+// passing how many snapshots were written, and the error of the refresh if any, to the given function.
+// The failed accounts are retried at the next refresh, MaintainSnapshots only returns the context error.
+func (l *Ledger) MaintainSnapshots(ctx context.Context, every time.Duration, report func(int, error)) error {
+	for {
+		report(l.RefreshSnapshots(time.Now()))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(every):
+		}
+	}
+}
+
+// balanceBefore returns the posted balance of the account with the entries before the given time,
+// from its latest snapshot and the entries after it.
+func (l *Ledger) balanceBefore(id uuid.UUID, at time.Time) (int, error) {
+	snapshot, err := l.storage.Snapshot(id, at)
+	if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+		return 0, err
+	}
+	entries, err := l.storage.Entries(id, snapshot.Timestamp, at)
+	if err != nil {
+		return 0, err
+	}
+
+	balance := snapshot.Balance
+	for _, e := range entries {
+		balance += e.Amount
+	}
+	return balance, nil
+}
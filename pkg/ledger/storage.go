@@ -0,0 +1,119 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAccountNotFound      = errors.New("account not found")
+	ErrDuplicateAccount     = errors.New("account already exists")
+	ErrTransactionNotFound  = errors.New("transaction not found")
+	ErrDuplicateTransaction = errors.New("transaction already posted")
+	ErrVersionConflict      = errors.New("account balance changed by a concurrent update")
+)
+
+// Storage is the interface implemented by the storage engines of a Ledger.
+//
+// Implementations must be safe for concurrent use.
+//   - Lookups of missing accounts, transactions, holds and dimensions return ErrAccountNotFound, ErrTransactionNotFound,
+//     ErrHoldNotFound and ErrDimensionNotFound.
+//   - Balance returns a zero balance for account shards that have no entries yet.
+//   - Balances returns every stored shard of the account, whatever the current number of shards of the account is.
+//   - Commit must write all the changes or none of them, and SaveAccount must write the account and its audit record.
+//   - SaveAccount must fail with ErrDuplicateAccountCode if another account has the same non-empty Code.
+//   - The audit log is append-only, the records are never changed or removed.
+//   - QueryTransactions must select the transactions as TransactionQuery.Matches, sorted as TransactionQuery.Compare
+//     and paginated with TransactionQuery.Cursor and IsAfterFunc,
+//     the accounts of the query already include their descendants.
+//   - Commit must fail with ErrVersionConflict if the stored version of any of the balances differs
+//     from the version in the commit, and store the balances with their version incremented.
+//     The same applies to the holds, a hold with version 0 must not exist yet,
+//     and to the accounts and the updated accounts, only the updated ones are written.
+//   - SaveAccount must fail with ErrVersionConflict if the stored version of the account differs from its version,
+//     an account with version 0 must not exist yet.
+//   - SaveAccount and Commit store the accounts with their version incremented.
+//   - Commit must link every transaction to the previous one with ChainHash, in posting order,
+//     and Links must return the links in the same order as Transactions.
+//   - Commit must delete the snapshots of the accounts of every transaction with a Timestamp after the transaction.
+//   - Snapshot returns the latest snapshot of the account with a Timestamp not after the given time,
+//     or ErrSnapshotNotFound.
+//   - SaveSnapshots must fail with ErrVersionConflict if the batch is stale, see SnapshotBatch,
+//     and replace the snapshots with the same Timestamp.
+//   - SaveAssertion replaces the balance assertion with the same ID, if any.
+//   - SaveBudget must fail with ErrBudgetOverlap if the budget overlaps another one, see Budget.Overlaps,
+//     and replace the budget with the same ID, if any.
+//   - SaveClearings and DeleteClearing must fail with ErrVersionConflict as Commit does for the holds,
+//     and the missing clearings are returned as ErrClearingNotFound.
+//   - SaveClearings, DeleteClearing and FinishReconciliation must fail with ErrReconciliationFinished
+//     if the reconciliation of a clearing is finished, and FinishReconciliation saves the clearings as SaveClearings.
+type Storage interface {
+	SaveAccount(a Account, audit AuditRecord) error
+	Account(id uuid.UUID) (Account, error)
+	AccountByCode(code string) (Account, error)
+	Accounts() ([]Account, error)
+
+	Balance(account uuid.UUID, shard int) (AccountBalance, error)
+	Balances(account uuid.UUID) ([]AccountBalance, error)
+
+	Commit(c Commit) error
+	Transaction(id uuid.UUID) (*Transaction, error)
+	Transactions() ([]*Transaction, error) // In posting order.
+	Entries(account uuid.UUID, from, to time.Time) ([]PostedEntry, error)
+	EntriesByMetadata(key, value string) ([]PostedEntry, error) // In posting order.
+	QueryTransactions(q TransactionQuery) (TransactionPage, error)
+	Links() ([]ChainLink, error) // In posting order.
+
+	SaveDimension(c DimensionCatalog) error
+	Dimension(d Dimension) (DimensionCatalog, error)
+
+	Snapshot(account uuid.UUID, at time.Time) (AccountBalance, error)
+	SaveSnapshots(b SnapshotBatch) error
+
+	SaveAssertion(a BalanceAssertion) error
+	Assertions() ([]BalanceAssertion, error) // In the order they were saved.
+
+	SaveBudget(b Budget) error
+	Budgets() ([]Budget, error) // In the order they were saved.
+
+	Clearing(ref EntryRef) (Clearing, error)
+	Clearings(reconciliation uuid.UUID) ([]Clearing, error) // Ordered by Line.
+	SaveClearings(c []Clearing) error
+	DeleteClearing(c Clearing) error
+	FinishReconciliation(id uuid.UUID, at time.Time, clearings []Clearing) error
+	ReconciliationFinished(id uuid.UUID) (time.Time, error) // The zero time if not finished.
+
+	Hold(id uuid.UUID) (Hold, error)
+	Holds(status HoldStatus) ([]Hold, error)
+
+	AuditLog(q AuditQuery) ([]AuditRecord, error) // In the order they were recorded.
+
+	PendingOutbox(max int) ([]OutboxMessage, error) // Oldest first.
+	MarkOutboxSent(ids []uuid.UUID, at time.Time) error
+}
+
+// Commit is the set of changes written atomically by a Storage.
+type Commit struct {
+	Transactions []*Transaction
+	Balances     []AccountBalance // The new balances of the accounts, with the version they were read with.
+	Holds        []Hold           // The holds placed or released, with the version they were read with.
+	Accounts     []Account        // The accounts the commit was prepared with, with the version they were read with.
+	Updates      []Account        // The accounts changed by the commit, with the version they were read with.
+	Audit        []AuditRecord    // The audit records of the Updates and of the audited transactions.
+	Outbox       []OutboxMessage  // The events to be delivered once the commit succeeds.
+}
+
+// EntryRef identifies an entry of a posted transaction by its position in the transaction.
+type EntryRef struct {
+	Transaction uuid.UUID
+	Index       int
+}
+
+// PostedEntry represents an entry of a posted transaction.
+type PostedEntry struct {
+	EntryRef
+	Entry
+	Timestamp time.Time
+}
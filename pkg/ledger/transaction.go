@@ -2,6 +2,7 @@ package ledger
 
 import (
 	"errors"
+	"maps"
 	"time"
 
 	"github.com/google/uuid"
@@ -41,9 +42,16 @@ type Transaction struct {
 //   - The account type determines if the amount is a debit or a credit.
 //   - Sistematicaly it is better to use positive and negative numbers to avoid confusion or complexity.
 //   - It is easier to calculate the total amount of increases and decreases in a transaction.
+//
+// The Dimensions tag the entry for management reporting, such as the department or the project,
+// each one must have a registered catalog with the value, see [DimensionCatalog].
+// The Description and the Metadata annotate the line itself, such as an invoice line id, a SKU or a counterparty.
 type Entry struct {
-	Account uuid.UUID
-	Amount  int // The amount can be positive or negative.
+	Account     uuid.UUID
+	Amount      int // The amount can be positive or negative.
+	Dimensions  Dimensions
+	Description string
+	Metadata    map[string]string
 }
 
 // NewTransaction creates a new regular transaction with the given timestamp.
@@ -137,3 +145,22 @@ func (t *Transaction) AddEntry(e Entry) {
 func (t *Transaction) AddEntries(entries []Entry) {
 	t.Entries = append(t.Entries, entries...)
 }
+
+// clone returns a copy of the entry that shares no map with the original.
+func (e Entry) clone() Entry {
+	e.Dimensions = maps.Clone(e.Dimensions)
+	e.Metadata = maps.Clone(e.Metadata)
+	return e
+}
+
+// cloneTransaction returns a copy of the transaction that shares nothing with the original,
+// so it can be stored or handed out without being changed by the callers.
+func cloneTransaction(t *Transaction) *Transaction {
+	c := *t
+	c.Entries = append([]Entry(nil), t.Entries...)
+	for i := range c.Entries {
+		c.Entries[i] = c.Entries[i].clone()
+	}
+	c.Metadata = maps.Clone(t.Metadata)
+	return &c
+}
@@ -1,6 +1,7 @@
 package ledger_test
 
 import (
+	"bytes"
 	"testing"
 	"time"
 
@@ -69,3 +70,46 @@ func Test_Transactions(t *testing.T) {
 		t.Errorf("total decreases should be %d but got %d", debit.Amount, total)
 	}
 }
+
+func Test_MarshalBinary(t *testing.T) {
+
+	a, b := uuid.New(), uuid.New()
+	build := func(entries []ledger.Entry, metadata map[string]string) []byte {
+		tx := ledger.NewTransaction(time.Unix(1700000000, 0))
+		tx.Id = uuid.MustParse("6f1c1d1e-0000-4000-8000-000000000001")
+		tx.AddEntries(entries)
+		tx.Metadata = metadata
+		data, err := tx.MarshalBinary()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return data
+	}
+
+	first := build([]ledger.Entry{{Account: a, Amount: 1}, {Account: b, Amount: -1}}, map[string]string{"x": "1", "y": "2"})
+	// the metadata is sorted, so the order of the map does not matter
+	for i := 0; i < 10; i++ {
+		if again := build([]ledger.Entry{{Account: a, Amount: 1}, {Account: b, Amount: -1}}, map[string]string{"y": "2", "x": "1"}); !bytes.Equal(first, again) {
+			t.Fatal("equal transactions should have the same encoding")
+		}
+	}
+	// the order of the entries matters
+	if swapped := build([]ledger.Entry{{Account: b, Amount: -1}, {Account: a, Amount: 1}}, map[string]string{"x": "1", "y": "2"}); bytes.Equal(first, swapped) {
+		t.Error("the order of the entries should change the encoding")
+	}
+	// the dimensions are part of the encoding
+	if tagged := build([]ledger.Entry{{Account: a, Amount: 1, Dimensions: ledger.Dimensions{ledger.DimensionProject: "apollo"}}, {Account: b, Amount: -1}}, map[string]string{"x": "1", "y": "2"}); bytes.Equal(first, tagged) {
+		t.Error("the dimensions should change the encoding")
+	}
+	// so are the description and the metadata of the entries
+	if noted := build([]ledger.Entry{{Account: a, Amount: 1, Description: "fee"}, {Account: b, Amount: -1}}, map[string]string{"x": "1", "y": "2"}); bytes.Equal(first, noted) {
+		t.Error("the entry description should change the encoding")
+	}
+	if noted := build([]ledger.Entry{{Account: a, Amount: 1, Metadata: map[string]string{"x": "1"}}, {Account: b, Amount: -1}}, map[string]string{"x": "1", "y": "2"}); bytes.Equal(first, noted) {
+		t.Error("the entry metadata should change the encoding")
+	}
+	// the strings are prefixed with their length
+	if shifted := build([]ledger.Entry{{Account: a, Amount: 1}, {Account: b, Amount: -1}}, map[string]string{"x": "12", "y": ""}); bytes.Equal(first, shifted) {
+		t.Error("different metadata should change the encoding")
+	}
+}
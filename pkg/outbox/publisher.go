@@ -0,0 +1,99 @@
+package outbox
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// FilePublisher appends every event to a file, one JSON encoded event per line.
+// It is meant for tests and local development.
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFilePublisher opens the file for appending, creating it if it does not exist.
+func OpenFilePublisher(path string) (*FilePublisher, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{file: f}, nil
+}
+
+func (p *FilePublisher) Publish(e ledger.TransactionPosted) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+// Close closes the underlying file.
+func (p *FilePublisher) Close() error {
+	return p.file.Close()
+}
+
+// DefaultWebhookTimeout is how long a WebhookPublisher waits for the endpoint when it has no Timeout.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// WebhookPublisher posts every event as JSON to an HTTP endpoint.
+// Any response status other than 2xx is an error, so the event is delivered again later.
+//
+// Every request has a deadline, so an endpoint that hangs fails the delivery instead of blocking the relay.
+type WebhookPublisher struct {
+	URL     string
+	Header  http.Header   // Extra headers sent with every request, such as authorization.
+	Client  *http.Client  // http.DefaultClient if nil.
+	Timeout time.Duration // The deadline of every request, DefaultWebhookTimeout if zero.
+}
+
+func (p *WebhookPublisher) Publish(e ledger.TransactionPosted) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(p.Timeout, DefaultWebhookTimeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for key, values := range p.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", p.URL, resp.Status)
+	}
+	return nil
+}
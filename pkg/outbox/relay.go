@@ -0,0 +1,102 @@
+// outbox package delivers the events written to the storage outbox by a ledger created with ledger.WithOutbox.
+//
+// The ledger writes the events in the same commit as the transactions, and a Relay delivers them afterwards:
+//   - Pending messages are published in order and marked as sent only after the publisher succeeds.
+//   - Delivery is at-least-once, a crash between publishing and marking sends the message again,
+//     so the receivers must ignore events of transactions they already know.
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// Outbox is the part of a ledger.Storage used by the relay.
+type Outbox interface {
+	PendingOutbox(max int) ([]ledger.OutboxMessage, error)
+	MarkOutboxSent(ids []uuid.UUID, at time.Time) error
+}
+
+const (
+	DefaultInterval  = time.Second
+	DefaultBatchSize = 100
+)
+
+// Relay delivers the pending outbox messages to a publisher.
+type Relay struct {
+	Interval  time.Duration // How long to wait between polls when the outbox is empty or the publisher fails.
+	BatchSize int           // How many messages are read from the outbox at once.
+
+	outbox    Outbox
+	publisher ledger.Publisher
+}
+
+// NewRelay creates a relay from the outbox to the publisher.
+func NewRelay(outbox Outbox, publisher ledger.Publisher) *Relay {
+	return &Relay{
+		Interval:  DefaultInterval,
+		BatchSize: DefaultBatchSize,
+		outbox:    outbox,
+		publisher: publisher,
+	}
+}
+
+// RelayOnce delivers one batch of pending messages and returns how many were sent.
+//
+// It stops at the first publisher error so the messages keep their order,
+// the messages sent before the error are still marked as sent.
+func (r *Relay) RelayOnce() (int, error) {
+	batch := r.BatchSize
+	if batch <= 0 {
+		batch = DefaultBatchSize
+	}
+	pending, err := r.outbox.PendingOutbox(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := make([]uuid.UUID, 0, len(pending))
+	var publishErr error
+	for _, m := range pending {
+		if publishErr = r.publisher.Publish(m.Event); publishErr != nil {
+			break
+		}
+		sent = append(sent, m.ID)
+	}
+
+	if len(sent) > 0 {
+		if err := r.outbox.MarkOutboxSent(sent, time.Now()); err != nil {
+			return 0, err
+		}
+	}
+	return len(sent), publishErr
+}
+
+// Run delivers the pending messages until the context is done.
+// Publisher errors are retried after the interval, Run only returns the context error.
+func (r *Relay) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	for {
+		n, err := r.RelayOnce()
+		if err == nil && n > 0 {
+			// there may be more messages waiting, do not sleep
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package outbox_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/outbox"
+)
+
+type failingPublisher struct{ after int }
+
+func (p *failingPublisher) Publish(e ledger.TransactionPosted) error {
+	if p.after == 0 {
+		return errors.New("broker is down")
+	}
+	p.after--
+	return nil
+}
+
+func Test_Relay(t *testing.T) {
+	storage := ledger.NewMemoryStorage()
+	l := ledger.New(storage, ledger.WithOutbox())
+	cash := &ledger.Account{Name: "Cash", AccountType: ledger.AccountTypeAsset}
+	equity := &ledger.Account{Name: "Capital", AccountType: ledger.AccountTypeEquity}
+	l.CreateAccount(cash)
+	l.CreateAccount(equity)
+
+	var posted []*ledger.Transaction
+	for i := 0; i < 3; i++ {
+		tx := ledger.NewTransaction(time.Now())
+		tx.AddEntries([]ledger.Entry{{Account: cash.ID, Amount: 10}, {Account: equity.ID, Amount: -10}})
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error posting: %v", err)
+		}
+		posted = append(posted, tx)
+	}
+
+	// the events are written to the outbox together with the transactions
+	if pending, _ := storage.PendingOutbox(0); len(pending) != 3 || pending[0].Event.Transaction.Id != posted[0].Id {
+		t.Fatalf("outbox should have 3 pending messages but got %d", len(pending))
+	}
+
+	// a failing publisher leaves the remaining messages pending
+	relay := outbox.NewRelay(storage, &failingPublisher{after: 1})
+	if n, err := relay.RelayOnce(); n != 1 || err == nil {
+		t.Errorf("relay should send 1 message and fail but got %d (%v)", n, err)
+	}
+	if pending, _ := storage.PendingOutbox(0); len(pending) != 2 || pending[0].Event.Transaction.Id != posted[1].Id {
+		t.Errorf("outbox should have 2 pending messages but got %d", len(pending))
+	}
+
+	// deliver the rest to a file
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	file, err := outbox.OpenFilePublisher(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer file.Close()
+
+	relay = outbox.NewRelay(storage, file)
+	if n, err := relay.RelayOnce(); n != 2 || err != nil {
+		t.Errorf("relay should send 2 messages but got %d (%v)", n, err)
+	}
+	if pending, _ := storage.PendingOutbox(0); len(pending) != 0 {
+		t.Errorf("outbox should be empty but got %d", len(pending))
+	}
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e ledger.TransactionPosted
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("unexpected error decoding event: %v", err)
+		}
+		ids = append(ids, e.Transaction.Id.String())
+	}
+	if len(ids) != 2 || ids[0] != posted[1].Id.String() || ids[1] != posted[2].Id.String() {
+		t.Errorf("unexpected events in file %v", ids)
+	}
+}
+
+func Test_WebhookPublisher(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first call fails, the relay must try again
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	storage := ledger.NewMemoryStorage()
+	l := ledger.New(storage, ledger.WithOutbox())
+	cash := &ledger.Account{Name: "Cash", AccountType: ledger.AccountTypeAsset}
+	equity := &ledger.Account{Name: "Capital", AccountType: ledger.AccountTypeEquity}
+	l.CreateAccount(cash)
+	l.CreateAccount(equity)
+	tx := ledger.NewTransaction(time.Now())
+	tx.AddEntries([]ledger.Entry{{Account: cash.ID, Amount: 10}, {Account: equity.ID, Amount: -10}})
+	l.Post(tx)
+
+	relay := outbox.NewRelay(storage, &outbox.WebhookPublisher{
+		URL:    server.URL,
+		Header: http.Header{"Authorization": {"Bearer secret"}},
+	})
+	relay.Interval = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go relay.Run(ctx)
+
+	for {
+		if pending, _ := storage.PendingOutbox(0); len(pending) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the relay")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("webhook should be called twice but got %d", calls.Load())
+	}
+
+	// an endpoint that hangs fails the delivery at the deadline
+	hang := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer hanging.Close()
+	defer close(hang)
+	start := time.Now()
+	if err := (&outbox.WebhookPublisher{URL: hanging.URL, Timeout: 20 * time.Millisecond}).Publish(ledger.TransactionPosted{}); err == nil || time.Since(start) > time.Second {
+		t.Errorf("hanging webhook should fail at the deadline but got %v after %s", err, time.Since(start))
+	}
+}
@@ -0,0 +1,206 @@
+// proof package builds Merkle sum trees over account balances, so customers can check that their balance
+// is included in a published total, such as the total of the liabilities towards them.
+//
+// The tree is built periodically from the balances at a point in time and only its Root is published:
+//   - Every leaf is an account balance hashed with a random nonce, so the hashes do not reveal the balances.
+//   - Every node has the hash and the sum of its children, and the sum of the root is the published total.
+//   - The Proof of an account is the path of siblings to the root, checked with Verify without the tree or the ledger.
+package proof
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+var (
+	ErrNotIncluded = errors.New("account is not included in the tree")
+	ErrInvalid     = errors.New("proof does not match the root")
+)
+
+// Domain separation prefixes of the leaf and node hashes, so a node can never be taken for a leaf.
+const (
+	leafPrefix = 0
+	nodePrefix = 1
+)
+
+// Node is a node of a Merkle sum tree.
+type Node struct {
+	Hash ledger.Hash
+	Sum  int
+}
+
+// Root is what is published after building a tree.
+type Root struct {
+	Node
+	Timestamp time.Time // The time of the balances.
+	Leaves    int
+}
+
+// Sibling is a node of the path from a leaf to the root.
+type Sibling struct {
+	Node
+	Left bool // True if the sibling is on the left of the path.
+}
+
+// Proof proves that the balance of an account is included in a root.
+type Proof struct {
+	Account   uuid.UUID
+	Balance   int
+	Nonce     [16]byte
+	Timestamp time.Time
+	Path      []Sibling // From the leaf to the root.
+}
+
+// Tree is a Merkle sum tree over the natural balances of accounts.
+type Tree struct {
+	timestamp time.Time
+	leaves    []leaf
+	index     map[uuid.UUID]int
+	levels    [][]Node // From the leaves to the root.
+}
+
+type leaf struct {
+	account uuid.UUID
+	balance int
+	nonce   [16]byte
+}
+
+// Build builds a tree from the given balances, all taken at the given time.
+//
+// The natural balances are used and they cannot be negative: a negative leaf would hide
+// other balances from the total. Every account can only appear once.
+func Build(at time.Time, balances []ledger.AccountBalance) (*Tree, error) {
+	if len(balances) == 0 {
+		return nil, errors.New("tree has no balances")
+	}
+
+	t := &Tree{timestamp: at, index: make(map[uuid.UUID]int, len(balances))}
+	nodes := make([]Node, 0, len(balances)+1)
+	for i, b := range balances {
+		if _, ok := t.index[b.AccountID]; ok {
+			return nil, fmt.Errorf("account %s is included twice", b.AccountID)
+		}
+		if b.Natural() < 0 {
+			return nil, fmt.Errorf("account %s has a negative balance %d", b.AccountID, b.Natural())
+		}
+
+		l := leaf{account: b.AccountID, balance: b.Natural()}
+		if _, err := rand.Read(l.nonce[:]); err != nil {
+			return nil, err
+		}
+		t.leaves = append(t.leaves, l)
+		t.index[b.AccountID] = i
+		nodes = append(nodes, Node{Hash: leafHash(l.account, l.balance, l.nonce), Sum: l.balance})
+	}
+
+	t.levels = append(t.levels, nodes)
+	for len(nodes) > 1 {
+		// an odd level is padded with an empty node, which adds nothing to the sum
+		if len(nodes)%2 == 1 {
+			nodes = append(nodes, Node{})
+			t.levels[len(t.levels)-1] = nodes
+		}
+		parents := make([]Node, 0, len(nodes)/2+1)
+		for i := 0; i < len(nodes); i += 2 {
+			parents = append(parents, parent(nodes[i], nodes[i+1]))
+		}
+		t.levels = append(t.levels, parents)
+		nodes = parents
+	}
+	return t, nil
+}
+
+// BuildAt builds a tree from the balances of the accounts at the given time.
+func BuildAt(l *ledger.Ledger, accounts []uuid.UUID, at time.Time) (*Tree, error) {
+	balances := make([]ledger.AccountBalance, 0, len(accounts))
+	for _, id := range accounts {
+		b, err := l.BalanceAt(id, at)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", id, err)
+		}
+		balances = append(balances, b)
+	}
+	return Build(at, balances)
+}
+
+// Root returns the root of the tree, to be published.
+func (t *Tree) Root() Root {
+	return Root{Node: t.levels[len(t.levels)-1][0], Timestamp: t.timestamp, Leaves: len(t.leaves)}
+}
+
+// Proof returns the inclusion proof of the account, to be handed to its owner.
+func (t *Tree) Proof(account uuid.UUID) (*Proof, error) {
+	i, ok := t.index[account]
+	if !ok {
+		return nil, ErrNotIncluded
+	}
+
+	l := t.leaves[i]
+	p := &Proof{Account: l.account, Balance: l.balance, Nonce: l.nonce, Timestamp: t.timestamp}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if i%2 == 0 {
+			p.Path = append(p.Path, Sibling{Node: level[i+1]})
+		} else {
+			p.Path = append(p.Path, Sibling{Node: level[i-1], Left: true})
+		}
+		i /= 2
+	}
+	return p, nil
+}
+
+// Verify checks that the proof leads to the published root.
+// It needs nothing but the proof and the root, so it can be run by the owner of the account.
+func Verify(root Root, p *Proof) error {
+	if !p.Timestamp.Equal(root.Timestamp) {
+		return fmt.Errorf("%w: proof is for %s and root for %s", ErrInvalid, p.Timestamp, root.Timestamp)
+	}
+	if p.Balance < 0 {
+		return fmt.Errorf("%w: negative balance", ErrInvalid)
+	}
+
+	n := Node{Hash: leafHash(p.Account, p.Balance, p.Nonce), Sum: p.Balance}
+	for _, s := range p.Path {
+		// a negative sibling could hide balances from the total
+		if s.Sum < 0 {
+			return fmt.Errorf("%w: negative sum in the path", ErrInvalid)
+		}
+		if s.Left {
+			n = parent(s.Node, n)
+		} else {
+			n = parent(n, s.Node)
+		}
+		if n.Sum < 0 {
+			return fmt.Errorf("%w: sum overflow in the path", ErrInvalid)
+		}
+	}
+	if n != root.Node {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func leafHash(account uuid.UUID, balance int, nonce [16]byte) ledger.Hash {
+	b := make([]byte, 0, 41)
+	b = append(b, leafPrefix)
+	b = append(b, account[:]...)
+	b = append(b, nonce[:]...)
+	b = binary.BigEndian.AppendUint64(b, uint64(balance))
+	return sha256.Sum256(b)
+}
+
+func parent(left, right Node) Node {
+	b := make([]byte, 0, 81)
+	b = append(b, nodePrefix)
+	b = append(b, left.Hash[:]...)
+	b = binary.BigEndian.AppendUint64(b, uint64(left.Sum))
+	b = append(b, right.Hash[:]...)
+	b = binary.BigEndian.AppendUint64(b, uint64(right.Sum))
+	return Node{Hash: sha256.Sum256(b), Sum: left.Sum + right.Sum}
+}
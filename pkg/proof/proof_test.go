@@ -0,0 +1,86 @@
+package proof_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/proof"
+)
+
+func Test_Proof(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	now := time.Now()
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(bank)
+	var wallets []uuid.UUID
+	total := 0
+	for i := 0; i < 5; i++ {
+		wallet := &ledger.Account{Name: "Wallet", AccountType: ledger.AccountTypeLiability}
+		l.CreateAccount(wallet)
+		wallets = append(wallets, wallet.ID)
+
+		deposit := ledger.NewTransaction(now.Add(-time.Hour))
+		deposit.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 100 * (i + 1)}, {Account: wallet.ID, Amount: -100 * (i + 1)}})
+		l.Post(deposit)
+		total += 100 * (i + 1)
+	}
+	// posted after the time of the tree, it is not included
+	late := ledger.NewTransaction(now.Add(time.Hour))
+	late.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 1}, {Account: wallets[0], Amount: -1}})
+	l.Post(late)
+
+	tree, err := proof.BuildAt(l, wallets, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	root := tree.Root()
+	if root.Sum != total || root.Leaves != 5 {
+		t.Errorf("root should have the total %d of 5 leaves but got %d of %d", total, root.Sum, root.Leaves)
+	}
+
+	for i, id := range wallets {
+		p, err := tree.Proof(id)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p.Balance != 100*(i+1) {
+			t.Errorf("proof should have balance %d but got %d", 100*(i+1), p.Balance)
+		}
+		if err := proof.Verify(root, p); err != nil {
+			t.Errorf("proof of wallet %d should be valid but got %v", i, err)
+		}
+	}
+
+	// a changed balance or path does not lead to the root
+	p, _ := tree.Proof(wallets[2])
+	p.Balance++
+	if err := proof.Verify(root, p); !errors.Is(err, proof.ErrInvalid) {
+		t.Errorf("proof with a changed balance should be invalid but got %v", err)
+	}
+	p.Balance--
+	p.Path[0].Sum = -p.Path[0].Sum
+	if err := proof.Verify(root, p); !errors.Is(err, proof.ErrInvalid) {
+		t.Errorf("proof with a negative sibling should be invalid but got %v", err)
+	}
+
+	// a root of another period does not match
+	other := root
+	other.Timestamp = now.Add(-24 * time.Hour)
+	p, _ = tree.Proof(wallets[2])
+	if err := proof.Verify(other, p); !errors.Is(err, proof.ErrInvalid) {
+		t.Errorf("proof for another root should be invalid but got %v", err)
+	}
+
+	if _, err := tree.Proof(bank.ID); !errors.Is(err, proof.ErrNotIncluded) {
+		t.Errorf("bank should not be included but got %v", err)
+	}
+	// a liability with a debit balance is negative in natural terms
+	if _, err := proof.Build(now, []ledger.AccountBalance{{AccountID: uuid.New(), AccountType: ledger.AccountTypeLiability, Balance: 10}}); err == nil {
+		t.Error("negative balance should be rejected")
+	}
+}
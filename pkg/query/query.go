@@ -0,0 +1,351 @@
+// query package compiles a small text language into a ledger.TransactionQuery, for analysts to write filters as text:
+//
+//	account:Assets:Bank and amount>1000 and meta.vendor="acme" and date:2026-Q3
+//
+// A filter is a list of terms joined by "and":
+//   - account:<path> selects the transactions of the account, given by its path of names from the root
+//     such as Assets:Bank, or by its code, including its descendants.
+//   - journal:<id> and type:<Regular|Closing> select the journal and the transaction type.
+//   - amount with >, >=, <, <= or = compares the amount of the transaction, in the minor units of the ledger.
+//   - date:<period> selects a year (2026), quarter (2026-Q3), month (2026-07) or day (2026-07-15),
+//     and date with >, >=, < or <= compares with the period as a whole: >= and < compare with its start,
+//     > and <= with its end, so date<=2026-07 includes the whole of July and date>2026-07 starts in August.
+//   - meta.<key> with =, != or ~ (contains) compares a metadata value, and meta.<key> alone requires the key.
+//   - A bare word or a quoted string searches the text of the metadata and the entry descriptions.
+//
+// Values with spaces or special characters are quoted with double quotes. Only one account, journal, type and
+// text term is allowed, since the transaction query cannot combine them. Errors report the position of the problem.
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// SyntaxError is an error in a filter, with the position where it was found.
+type SyntaxError struct {
+	Pos int // The 1-based position of the problem in the filter.
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("at %d: %s", e.Pos, e.Msg)
+}
+
+// Term is a condition of a filter.
+type Term struct {
+	Pos      int    // The 1-based position of the term.
+	Field    string // Empty for a text term.
+	Op       string // Empty for a text term or a metadata key alone.
+	Value    string
+	ValuePos int
+}
+
+var termPattern = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9_]*(?:\.[^=!<>~:\s"]+)?)(:|!=|>=|<=|=|>|<|~)?`)
+
+// Parse splits the filter in terms.
+func Parse(filter string) ([]Term, error) {
+	var terms []Term
+	expectTerm := true
+	for pos := 0; ; {
+		for pos < len(filter) && filter[pos] == ' ' {
+			pos++
+		}
+		if pos == len(filter) {
+			break
+		}
+
+		word, end, err := scan(filter, pos)
+		if err != nil {
+			return nil, err
+		}
+		if !expectTerm {
+			if strings.ToLower(word) != "and" {
+				return nil, &SyntaxError{Pos: pos + 1, Msg: fmt.Sprintf("expected and but got %q", word)}
+			}
+			expectTerm, pos = true, end
+			continue
+		}
+		if lower := strings.ToLower(word); lower == "and" || lower == "or" || lower == "not" {
+			return nil, &SyntaxError{Pos: pos + 1, Msg: fmt.Sprintf("expected a term but got %q", word)}
+		}
+
+		term, err := parseTerm(filter[pos:end], pos)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+		expectTerm, pos = false, end
+	}
+	if expectTerm && len(terms) > 0 {
+		return nil, &SyntaxError{Pos: len(filter) + 1, Msg: "expected a term after and"}
+	}
+	return terms, nil
+}
+
+// scan returns the word starting at pos, up to the next space that is not quoted.
+func scan(filter string, pos int) (string, int, error) {
+	quoted, quote := false, 0
+	start := pos
+	for ; pos < len(filter); pos++ {
+		switch c := filter[pos]; {
+		case c == '\\' && quoted:
+			pos++
+		case c == '"':
+			quoted, quote = !quoted, pos
+		case c == ' ' && !quoted:
+			return filter[start:pos], pos, nil
+		}
+	}
+	if quoted {
+		return "", 0, &SyntaxError{Pos: quote + 1, Msg: "unterminated string"}
+	}
+	return filter[start:pos], pos, nil
+}
+
+func parseTerm(word string, pos int) (Term, error) {
+	m := termPattern.FindStringSubmatch(word)
+	if m == nil || (m[2] == "" && !strings.HasPrefix(m[1], "meta.")) {
+		// a bare word or a quoted string is searched as text
+		text, err := unquote(word, pos)
+		return Term{Pos: pos + 1, Value: text, ValuePos: pos + 1}, err
+	}
+
+	t := Term{Pos: pos + 1, Field: m[1], Op: m[2], ValuePos: pos + len(m[0]) + 1}
+	if t.Op == "" {
+		if len(word) > len(m[0]) {
+			return Term{}, &SyntaxError{Pos: t.ValuePos, Msg: fmt.Sprintf("expected an operator after %s", t.Field)}
+		}
+		return t, nil
+	}
+	value, err := unquote(word[len(m[0]):], t.ValuePos-1)
+	if err != nil {
+		return Term{}, err
+	}
+	if value == "" {
+		return Term{}, &SyntaxError{Pos: t.ValuePos, Msg: fmt.Sprintf("expected a value after %s%s", t.Field, t.Op)}
+	}
+	t.Value = value
+	return t, nil
+}
+
+func unquote(s string, pos int) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		if strings.Contains(s, `"`) {
+			return "", &SyntaxError{Pos: pos + strings.Index(s, `"`) + 1, Msg: "unexpected quote"}
+		}
+		return s, nil
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		return "", &SyntaxError{Pos: pos + 1, Msg: "invalid quoted string " + s}
+	}
+	return v, nil
+}
+
+// Compile parses the filter and compiles it into a transaction query of the ledger.
+// The dates are in the given location, UTC if nil.
+func Compile(l *ledger.Ledger, filter string, loc *time.Location) (ledger.TransactionQuery, error) {
+	terms, err := Parse(filter)
+	if err != nil {
+		return ledger.TransactionQuery{}, err
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	c := &compiler{ledger: l, loc: loc, seen: make(map[string]bool)}
+	for _, t := range terms {
+		if err := c.term(t); err != nil {
+			return ledger.TransactionQuery{}, err
+		}
+	}
+	return c.q, nil
+}
+
+type compiler struct {
+	ledger   *ledger.Ledger
+	loc      *time.Location
+	q        ledger.TransactionQuery
+	seen     map[string]bool
+	accounts []ledger.Account
+}
+
+func (c *compiler) term(t Term) error {
+	errorf := func(format string, args ...any) error {
+		return &SyntaxError{Pos: t.ValuePos, Msg: fmt.Sprintf(format, args...)}
+	}
+	field := t.Field
+	if strings.HasPrefix(field, "meta.") {
+		field = "meta"
+	}
+	switch field {
+	case "", "account", "journal", "type":
+		if c.seen[field] {
+			return &SyntaxError{Pos: t.Pos, Msg: fmt.Sprintf("only one %s term is allowed", cmp.Or(field, "text"))}
+		}
+		c.seen[field] = true
+		if field != "" && t.Op != ":" && t.Op != "=" {
+			return &SyntaxError{Pos: t.ValuePos - len(t.Op), Msg: fmt.Sprintf("%s only supports :", field)}
+		}
+	case "amount", "date", "meta":
+	default:
+		return &SyntaxError{Pos: t.Pos, Msg: fmt.Sprintf("unknown field %s", t.Field)}
+	}
+
+	switch field {
+	case "":
+		c.q.Text = t.Value
+
+	case "account":
+		id, err := c.account(t.Value)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		c.q.Accounts = []uuid.UUID{id}
+
+	case "journal":
+		id, err := uuid.Parse(t.Value)
+		if err != nil {
+			return errorf("invalid journal id %q", t.Value)
+		}
+		c.q.Journals = []uuid.UUID{id}
+
+	case "type":
+		tt := ledger.TransactionType(t.Value)
+		if tt != ledger.TransactionTypeRegular && tt != ledger.TransactionTypeClosing {
+			return errorf("unknown transaction type %q", t.Value)
+		}
+		c.q.Types = []ledger.TransactionType{tt}
+
+	case "amount":
+		v, err := strconv.Atoi(t.Value)
+		if err != nil {
+			return errorf("invalid amount %q", t.Value)
+		}
+		var min, max *int
+		switch t.Op {
+		case ">":
+			if v == math.MaxInt {
+				return errorf("no amount is greater than %d", v)
+			}
+			min = ptr(v + 1)
+		case ">=":
+			min = ptr(v)
+		case "<":
+			if v == math.MinInt {
+				return errorf("no amount is less than %d", v)
+			}
+			max = ptr(v - 1)
+		case "<=":
+			max = ptr(v)
+		case "=", ":":
+			min, max = ptr(v), ptr(v)
+		default:
+			return errorf("amount does not support %s", t.Op)
+		}
+		if min != nil && (c.q.MinAmount == nil || *min > *c.q.MinAmount) {
+			c.q.MinAmount = min
+		}
+		if max != nil && (c.q.MaxAmount == nil || *max < *c.q.MaxAmount) {
+			c.q.MaxAmount = max
+		}
+
+	case "date":
+		from, to, err := period(t.Value, c.loc)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		switch t.Op {
+		case ":", "=":
+		case ">=":
+			to = time.Time{}
+		case ">":
+			from, to = to, time.Time{}
+		case "<":
+			from, to = time.Time{}, from
+		case "<=":
+			from = time.Time{}
+		default:
+			return errorf("date does not support %s", t.Op)
+		}
+		if !from.IsZero() && from.After(c.q.From) {
+			c.q.From = from
+		}
+		if !to.IsZero() && (c.q.To.IsZero() || to.Before(c.q.To)) {
+			c.q.To = to
+		}
+
+	case "meta":
+		key := strings.TrimPrefix(t.Field, "meta.")
+		op := map[string]ledger.MetadataOp{"": ledger.MetadataExists, "=": ledger.MetadataEquals, ":": ledger.MetadataEquals, "!=": ledger.MetadataNotEquals, "~": ledger.MetadataContains}[t.Op]
+		if op == "" {
+			return errorf("metadata does not support %s", t.Op)
+		}
+		c.q.Metadata = append(c.q.Metadata, ledger.MetadataPredicate{Key: key, Op: op, Value: t.Value})
+	}
+	return nil
+}
+
+// account resolves an account by its code or its path of names from the root.
+func (c *compiler) account(path string) (uuid.UUID, error) {
+	if a, err := c.ledger.AccountByCode(path); err == nil {
+		return a.ID, nil
+	}
+	if c.accounts == nil {
+		accounts, err := c.ledger.Accounts()
+		if err != nil {
+			return uuid.Nil, err
+		}
+		c.accounts = accounts
+	}
+
+	parent := uuid.Nil
+	for _, name := range strings.Split(path, ":") {
+		found := false
+		for _, a := range c.accounts {
+			if a.ParentID == parent && a.Name == name {
+				parent, found = a.ID, true
+				break
+			}
+		}
+		if !found {
+			return uuid.Nil, fmt.Errorf("account %s not found", path)
+		}
+	}
+	return parent, nil
+}
+
+// period returns the range [from, to) of a year, quarter, month or day.
+func period(s string, loc *time.Location) (time.Time, time.Time, error) {
+	if year, q, ok := strings.Cut(s, "-Q"); ok {
+		y, err1 := strconv.Atoi(year)
+		n, err2 := strconv.Atoi(q)
+		if err1 != nil || err2 != nil || len(year) != 4 || n < 1 || n > 4 {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid quarter %q", s)
+		}
+		from := time.Date(y, time.Month(3*n-2), 1, 0, 0, 0, 0, loc)
+		return from, from.AddDate(0, 3, 0), nil
+	}
+	for _, p := range []struct {
+		layout           string
+		years, months, d int
+	}{{"2006", 1, 0, 0}, {"2006-01", 0, 1, 0}, {"2006-01-02", 0, 0, 1}} {
+		if from, err := time.ParseInLocation(p.layout, s, loc); err == nil {
+			return from, from.AddDate(p.years, p.months, p.d), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, expected 2026, 2026-Q3, 2026-07 or 2026-07-15", s)
+}
+
+func ptr(v int) *int {
+	return &v
+}
@@ -0,0 +1,102 @@
+package query_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/query"
+)
+
+func Test_Compile(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	assets := &ledger.Account{Name: "Assets", AccountType: ledger.AccountTypeAsset}
+	l.CreateAccount(assets)
+	bank := &ledger.Account{Name: "Bank", ParentID: assets.ID, AccountType: ledger.AccountTypeAsset, Code: "1010"}
+	vendors := &ledger.Account{Name: "Vendors", AccountType: ledger.AccountTypeLiability}
+	l.CreateAccount(bank)
+	l.CreateAccount(vendors)
+
+	for i := 0; i < 12; i++ {
+		tx := ledger.NewTransaction(start.AddDate(0, 0, i*14))
+		vendor := []string{"acme", "globex"}[i%2]
+		tx.AddEntries([]ledger.Entry{
+			{Account: vendors.ID, Amount: 500 * (i + 1), Description: "Payout to " + vendor},
+			{Account: bank.ID, Amount: -500 * (i + 1)},
+		})
+		tx.Metadata = map[string]string{"vendor": vendor}
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	q, err := query.Compile(l, `account:Assets:Bank and amount>1000 and meta.vendor="acme" and date:2026-Q3`, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(q.Accounts) != 1 || q.Accounts[0] != bank.ID || *q.MinAmount != 1001 || q.MaxAmount != nil {
+		t.Errorf("unexpected query %+v", q)
+	}
+	if !q.From.Equal(start) || !q.To.Equal(start.AddDate(0, 3, 0)) {
+		t.Errorf("unexpected range %s to %s", q.From, q.To)
+	}
+	page, err := l.QueryTransactions(q)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the quarter has the first 7 payouts, acme has 1500, 2500 and 3500 over 1000
+	if len(page.Transactions) != 3 || page.Transactions[0].TotalIncreases() != 1500 {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	// the code, the ranges and the text
+	q, err = query.Compile(l, `account:1010 and amount>=1000 and amount<=3000 and amount<2500 and date>=2026-08 and "payout to" and meta.vendor`, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.Accounts[0] != bank.ID || *q.MinAmount != 1000 || *q.MaxAmount != 2499 || !q.From.Equal(start.AddDate(0, 1, 0)) || !q.To.IsZero() {
+		t.Errorf("unexpected query %+v", q)
+	}
+	if q.Text != "payout to" || len(q.Metadata) != 1 || q.Metadata[0].Op != ledger.MetadataExists {
+		t.Errorf("unexpected text or metadata %+v", q)
+	}
+
+	journal := uuid.New()
+	q, err = query.Compile(l, "journal:"+journal.String()+" and type:Closing and date<2026 and meta.memo~rent and meta.ref!=x", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.Journals[0] != journal || q.Types[0] != ledger.TransactionTypeClosing || !q.To.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) || len(q.Metadata) != 2 {
+		t.Errorf("unexpected query %+v", q)
+	}
+
+	// errors report where the problem is
+	for filter, pos := range map[string]int{
+		"account:Assets:Bnk":                  9,
+		"amount>1000 amount<2000":             13,
+		"amount>1000 and":                     16,
+		"amount>1000 or amount<2000":          13,
+		"amount>ten":                          8,
+		"amount>9223372036854775807":          8,
+		"amount<-9223372036854775808":         8,
+		"date:2026-Q5":                        6,
+		`meta.vendor="acme`:                   13,
+		"account:Assets and account:Expenses": 20,
+		"colour:red":                          1,
+		"date~2026":                           6,
+		"amount>1000 and and":                 17,
+		"type:Monthly":                        6,
+		"account>Assets":                      8,
+	} {
+		_, err := query.Compile(l, filter, nil)
+		var syntaxErr *query.SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Pos != pos {
+			t.Errorf("%q should fail at %d but got %v", filter, pos, err)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+// reconcile package matches bank statements against the entries posted to the ledger.
+//
+// A reconciliation starts from an imported statement and an asset account:
+//   - Statement lines are matched to posted entries automatically or manually.
+//   - Matched entries are marked as cleared, and as reconciled once the reconciliation is finished.
+//     The clearings are kept by the ledger, so a reconciliation can be resumed and audited later.
+//   - The report shows the difference between the bank and the ledger balances and what explains it.
+package reconcile
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/statement"
+)
+
+// Status represents the clearing status of a posted entry.
+type Status string
+
+const (
+	StatusUncleared  Status = "Uncleared"  // The entry was not seen in a bank statement yet.
+	StatusCleared    Status = "Cleared"    // The entry is matched to a statement line of an open reconciliation.
+	StatusReconciled Status = "Reconciled" // The entry is part of a finished reconciliation and cannot be unmatched.
+)
+
+// DefaultWindow is the date window used to auto-match when the Reconciler has none.
+const DefaultWindow = 3 * 24 * time.Hour
+
+// Reconciler reconciles the posted entries of a ledger, keeping their clearing status in the ledger.
+//
+// It is safe for concurrent use, an entry can only be matched by one reconciliation at a time.
+type Reconciler struct {
+	Window time.Duration // How far apart the statement and entry dates can be to auto-match.
+
+	ledger *ledger.Ledger
+}
+
+// New creates a reconciler for the given ledger.
+func New(l *ledger.Ledger) *Reconciler {
+	return &Reconciler{
+		Window: DefaultWindow,
+		ledger: l,
+	}
+}
+
+// Status returns the clearing status of the entry.
+func (r *Reconciler) Status(ref ledger.EntryRef) (Status, error) {
+	c, err := r.ledger.Clearing(ref)
+	switch {
+	case errors.Is(err, ledger.ErrClearingNotFound):
+		return StatusUncleared, nil
+	case err != nil:
+		return "", err
+	case c.Reconciled:
+		return StatusReconciled, nil
+	}
+	return StatusCleared, nil
+}
+
+// Start opens a reconciliation of the statement against the account.
+// The statement must have a closing balance, it is compared to the ledger balance in the report.
+func (r *Reconciler) Start(account uuid.UUID, s *statement.Statement) (*Reconciliation, error) {
+	a, err := r.ledger.Account(account)
+	if err != nil {
+		return nil, err
+	}
+	if a.AccountType != ledger.AccountTypeAsset {
+		return nil, errors.New("only asset accounts can be reconciled")
+	}
+	if s.ClosingBalance == nil {
+		return nil, errors.New("statement has no closing balance")
+	}
+
+	return &Reconciliation{
+		ID:         uuid.New(),
+		Account:    account,
+		Statement:  s,
+		reconciler: r,
+		matches:    make(map[int]ledger.EntryRef),
+	}, nil
+}
+
+// Resume reopens the reconciliation with the given id, with the matches kept by the ledger.
+// The statement must be the same one it was started with.
+// A reconciliation that was finished can be reported but not changed.
+func (r *Reconciler) Resume(id, account uuid.UUID, s *statement.Statement) (*Reconciliation, error) {
+	rc, err := r.Start(account, s)
+	if err != nil {
+		return nil, err
+	}
+	clearings, err := r.ledger.Clearings(id)
+	if err != nil {
+		return nil, err
+	}
+
+	finished, err := r.ledger.ReconciliationFinished(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.ID = id
+	rc.finished = !finished.IsZero()
+	for _, c := range clearings {
+		if c.Line < 0 || c.Line >= len(s.Lines) {
+			return nil, fmt.Errorf("reconciliation %s matched line %d, the statement has %d lines", id, c.Line, len(s.Lines))
+		}
+		rc.matches[c.Line] = c.Entry
+	}
+	return rc, nil
+}
+
+// Reconciliation matches the lines of one statement against the entries of one account.
+// It is meant to be driven by a single user, but it is safe for concurrent use.
+type Reconciliation struct {
+	ID        uuid.UUID // Identifies the clearings of the reconciliation in the ledger, see Reconciler.Resume.
+	Account   uuid.UUID
+	Statement *statement.Statement
+
+	reconciler *Reconciler
+	mu         sync.Mutex
+	matches    map[int]ledger.EntryRef // Statement line index to the matched entry.
+	finished   bool
+}
+
+// Matches returns the matched entries by statement line index.
+func (rc *Reconciliation) Matches() map[int]ledger.EntryRef {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	matches := make(map[int]ledger.EntryRef, len(rc.matches))
+	for i, ref := range rc.matches {
+		matches[i] = ref
+	}
+	return matches
+}
+
+// opening returns the start of the statement period, its opening date or else the date of its first line
+// less the matching window, as the entries are usually posted before the bank books them.
+func (rc *Reconciliation) opening() time.Time {
+	if rc.Statement.OpeningBalance != nil {
+		return rc.Statement.OpeningBalance.Date
+	}
+	var opening time.Time
+	for _, l := range rc.Statement.Lines {
+		if opening.IsZero() || l.Date.Before(opening) {
+			opening = l.Date
+		}
+	}
+	if opening.IsZero() {
+		return opening
+	}
+	return opening.Add(-rc.reconciler.Window)
+}
+
+// closing returns the end of the statement closing day, entries after it are not in the statement.
+func (rc *Reconciliation) closing() time.Time {
+	return rc.Statement.ClosingBalance.Date.AddDate(0, 0, 1)
+}
+
+// AutoMatch matches the unmatched statement lines to uncleared entries.
+//
+// An entry is a candidate for a line when it has the same amount and its date is inside the reconciler window.
+//   - Candidates whose transaction metadata holds one of the line references (FITID, end-to-end id, bank reference) win.
+//   - Otherwise the candidate with the closest date wins.
+//
+// It returns the number of new matches.
+func (rc *Reconciliation) AutoMatch() (int, error) {
+	r := rc.reconciler
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.finished {
+		return 0, ledger.ErrReconciliationFinished
+	}
+
+	from, to := time.Time{}, rc.closing()
+	for _, l := range rc.Statement.Lines {
+		if start := l.Date.Add(-r.Window); from.IsZero() || start.Before(from) {
+			from = start
+		}
+		if end := l.Date.Add(r.Window + time.Nanosecond); end.After(to) {
+			to = end
+		}
+	}
+	entries, err := r.ledger.Entries(rc.Account, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	// the references are the metadata values of the transaction and of the entry itself
+	references := make(map[uuid.UUID]map[string]bool)
+	uncleared := make(map[ledger.EntryRef]bool, len(entries))
+	for _, e := range entries {
+		status, err := r.Status(e.EntryRef)
+		if err != nil {
+			return 0, err
+		}
+		uncleared[e.EntryRef] = status == StatusUncleared
+
+		refs, ok := references[e.Transaction]
+		if !ok {
+			t, err := r.ledger.Transaction(e.Transaction)
+			if err != nil {
+				return 0, err
+			}
+			refs = make(map[string]bool, len(t.Metadata))
+			for _, v := range t.Metadata {
+				refs[v] = true
+			}
+			references[e.Transaction] = refs
+		}
+		for _, v := range e.Metadata {
+			refs[v] = true
+		}
+	}
+
+	matched := 0
+	for i, l := range rc.Statement.Lines {
+		if _, ok := rc.matches[i]; ok {
+			continue
+		}
+
+		best, bestScore := -1, time.Duration(-1)
+		for j, e := range entries {
+			if e.Amount != l.Amount || !uncleared[e.EntryRef] {
+				continue
+			}
+			distance := e.Timestamp.Sub(l.Date)
+			if distance < 0 {
+				distance = -distance
+			}
+			if distance > r.Window {
+				continue
+			}
+
+			// a matching reference always beats a closer date
+			score := distance
+			refs := references[e.Transaction]
+			if (l.FITID != "" && refs[l.FITID]) || (l.EndToEndID != "" && refs[l.EndToEndID]) || (l.BankReference != "" && refs[l.BankReference]) {
+				score = 0
+			}
+			if best < 0 || score < bestScore {
+				best, bestScore = j, score
+			}
+		}
+
+		if best < 0 {
+			continue
+		}
+		ref := entries[best].EntryRef
+		uncleared[ref] = false
+		// an entry cleared by another reconciliation in the meantime is left for the next run
+		switch err := rc.clear(i, ref); {
+		case err == nil:
+			matched++
+		case !errors.Is(err, ledger.ErrVersionConflict):
+			return matched, err
+		}
+	}
+	return matched, nil
+}
+
+// Match manually matches a statement line to a posted entry.
+// The entry must belong to the reconciled account, have the same amount as the line and be uncleared.
+func (rc *Reconciliation) Match(line int, ref ledger.EntryRef) error {
+	r := rc.reconciler
+	if line < 0 || line >= len(rc.Statement.Lines) {
+		return fmt.Errorf("statement has no line %d", line)
+	}
+
+	t, err := r.ledger.Transaction(ref.Transaction)
+	if err != nil {
+		return err
+	}
+	if ref.Index < 0 || ref.Index >= len(t.Entries) {
+		return fmt.Errorf("transaction %s has no entry %d", ref.Transaction, ref.Index)
+	}
+	e := t.Entries[ref.Index]
+	if e.Account != rc.Account {
+		return errors.New("entry does not belong to the reconciled account")
+	}
+	if l := rc.Statement.Lines[line]; e.Amount != l.Amount {
+		return fmt.Errorf("entry amount %d differs from statement line amount %d", e.Amount, l.Amount)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.finished {
+		return ledger.ErrReconciliationFinished
+	}
+	if _, ok := rc.matches[line]; ok {
+		return fmt.Errorf("statement line %d is already matched", line)
+	}
+	if s, err := r.Status(ref); err != nil || s != StatusUncleared {
+		return cmp.Or(err, fmt.Errorf("entry is already %s", s))
+	}
+	if err := rc.clear(line, ref); errors.Is(err, ledger.ErrVersionConflict) {
+		return errors.New("entry is already cleared")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// clear matches the line to the entry, which must still be uncleared in the ledger.
+func (rc *Reconciliation) clear(line int, ref ledger.EntryRef) error {
+	c := ledger.Clearing{Entry: ref, Reconciliation: rc.ID, Line: line, Timestamp: time.Now()}
+	if err := rc.reconciler.ledger.SaveClearings([]ledger.Clearing{c}); err != nil {
+		return err
+	}
+	rc.matches[line] = ref
+	return nil
+}
+
+// Unmatch removes the match of a statement line, the entry goes back to uncleared.
+func (rc *Reconciliation) Unmatch(line int) error {
+	l := rc.reconciler.ledger
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.finished {
+		return ledger.ErrReconciliationFinished
+	}
+	ref, ok := rc.matches[line]
+	if !ok {
+		return fmt.Errorf("statement line %d is not matched", line)
+	}
+
+	c, err := l.Clearing(ref)
+	if err != nil {
+		return err
+	}
+	if c.Reconciliation != rc.ID || c.Line != line {
+		return fmt.Errorf("entry of statement line %d is cleared by reconciliation %s", line, c.Reconciliation)
+	}
+	if err := l.DeleteClearing(c); err != nil {
+		return err
+	}
+	delete(rc.matches, line)
+	return nil
+}
+
+// Report represents the state of a reconciliation.
+//
+// The difference between the bank and the ledger balances should be explained by
+// the unmatched lines (in the bank but not in the ledger) and the uncleared entries (in the ledger but not in the bank).
+type Report struct {
+	Account          uuid.UUID
+	Date             time.Time // The statement closing date.
+	BankBalance      int
+	LedgerBalance    int
+	Difference       int // BankBalance - LedgerBalance.
+	Matched          int
+	UnmatchedLines   []statement.Line
+	UnclearedEntries []ledger.PostedEntry
+}
+
+// IsReconciled returns true if the bank and the ledger agree.
+func (rep *Report) IsReconciled() bool {
+	return rep.Difference == 0 && len(rep.UnmatchedLines) == 0 && len(rep.UnclearedEntries) == 0
+}
+
+// Report builds the reconciliation report at the statement closing date.
+//
+// The uncleared entries are the ones of the statement period, from its opening date or else around its first line.
+// Older uncleared entries belong to the reconciliations of the previous statements.
+func (rc *Reconciliation) Report() (*Report, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.report()
+}
+
+func (rc *Reconciliation) report() (*Report, error) {
+	r := rc.reconciler
+	closing := rc.closing()
+
+	balance, err := r.ledger.BalanceAt(rc.Account, closing)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := r.ledger.Entries(rc.Account, rc.opening(), closing)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &Report{
+		Account:       rc.Account,
+		Date:          rc.Statement.ClosingBalance.Date,
+		BankBalance:   rc.Statement.ClosingBalance.Amount,
+		LedgerBalance: balance.Balance,
+	}
+	rep.Difference = rep.BankBalance - rep.LedgerBalance
+
+	rep.Matched = len(rc.matches)
+	for i, l := range rc.Statement.Lines {
+		if _, ok := rc.matches[i]; !ok {
+			rep.UnmatchedLines = append(rep.UnmatchedLines, l)
+		}
+	}
+	for _, e := range entries {
+		status, err := r.Status(e.EntryRef)
+		if err != nil {
+			return nil, err
+		}
+		if status == StatusUncleared {
+			rep.UnclearedEntries = append(rep.UnclearedEntries, e)
+		}
+	}
+	return rep, nil
+}
+
+// Finish closes the reconciliation, the matched entries become reconciled and cannot be unmatched anymore.
+// It returns the final report.
+func (rc *Reconciliation) Finish() (*Report, error) {
+	l := rc.reconciler.ledger
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.finished {
+		return nil, ledger.ErrReconciliationFinished
+	}
+	rep, err := rc.report()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.FinishReconciliation(rc.ID, time.Now()); err != nil {
+		// finished in the meantime through another resumed copy
+		rc.finished = errors.Is(err, ledger.ErrReconciliationFinished)
+		return nil, err
+	}
+	rc.finished = true
+	return rep, nil
+}
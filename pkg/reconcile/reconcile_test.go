@@ -0,0 +1,170 @@
+package reconcile_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/reconcile"
+	"github.com/tarcisio/haya/pkg/statement"
+)
+
+func Test_Reconciliation(t *testing.T) {
+	l := ledger.New(ledger.NewMemoryStorage())
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	sales := &ledger.Account{Name: "Sales", AccountType: ledger.AccountTypeRevenue}
+	l.CreateAccount(bank)
+	l.CreateAccount(sales)
+
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+	post := func(d, amount int, metadata map[string]string) *ledger.Transaction {
+		tx := ledger.NewTransaction(day(d))
+		tx.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: amount}, {Account: sales.ID, Amount: -amount}})
+		tx.Metadata = metadata
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error posting: %v", err)
+		}
+		return tx
+	}
+
+	old := post(-20, 400, nil)
+	near := post(9, 1000, nil)
+	referenced := post(8, 1000, map[string]string{"invoice": "INV-1"})
+	late := post(20, 700, nil)
+	uncleared := post(28, 300, nil)
+
+	s := &statement.Statement{
+		ClosingBalance: &statement.Balance{Amount: 2700 + 50, Date: day(31)},
+		Lines: []statement.Line{
+			{FITID: "A", Date: day(10), Amount: 1000, EndToEndID: "INV-1"},
+			{FITID: "B", Date: day(10), Amount: 1000},
+			{FITID: "C", Date: day(30), Amount: 700},
+			{FITID: "D", Date: day(31), Amount: 50},
+		},
+	}
+
+	r := reconcile.New(l)
+	status := func(r *reconcile.Reconciler, ref ledger.EntryRef) reconcile.Status {
+		s, err := r.Status(ref)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return s
+	}
+	rc, err := r.Start(bank.ID, s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the referenced entry wins even if the other one is closer
+	if n, err := rc.AutoMatch(); err != nil || n != 2 {
+		t.Fatalf("should auto-match 2 lines but got %d (%v)", n, err)
+	}
+	matches := rc.Matches()
+	if matches[0].Transaction != referenced.Id || matches[1].Transaction != near.Id {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+	if status(r, matches[0]) != reconcile.StatusCleared {
+		t.Error("matched entry should be cleared")
+	}
+
+	// the late entry is outside the window, match it manually
+	if err := rc.Match(3, ledger.EntryRef{Transaction: late.Id}); err == nil {
+		t.Error("matching entries with different amounts should fail")
+	}
+	if err := rc.Match(2, ledger.EntryRef{Transaction: late.Id, Index: 1}); err == nil {
+		t.Error("matching entries of another account should fail")
+	}
+	if err := rc.Match(2, ledger.EntryRef{Transaction: late.Id}); err != nil {
+		t.Errorf("unexpected error matching: %v", err)
+	}
+
+	rep, err := rc.Report()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rep.BankBalance != 2750 || rep.LedgerBalance != 3400 || rep.Difference != -650 || rep.Matched != 3 {
+		t.Errorf("unexpected report %+v", rep)
+	}
+	if len(rep.UnmatchedLines) != 1 || rep.UnmatchedLines[0].FITID != "D" {
+		t.Errorf("unexpected unmatched lines %+v", rep.UnmatchedLines)
+	}
+	// the old entry belongs to a previous statement
+	if len(rep.UnclearedEntries) != 1 || rep.UnclearedEntries[0].Transaction != uncleared.Id || status(r, ledger.EntryRef{Transaction: old.Id}) != reconcile.StatusUncleared {
+		t.Errorf("unexpected uncleared entries %+v", rep.UnclearedEntries)
+	}
+	if rep.IsReconciled() {
+		t.Error("report should not be reconciled")
+	}
+
+	// unmatching puts the entry back to uncleared
+	if err := rc.Unmatch(2); err != nil || status(r, ledger.EntryRef{Transaction: late.Id}) != reconcile.StatusUncleared {
+		t.Errorf("unmatched entry should be uncleared (%v)", err)
+	}
+	rc.Match(2, ledger.EntryRef{Transaction: late.Id})
+
+	// the clearings are kept by the ledger, another reconciler resumes the reconciliation
+	other := reconcile.New(l)
+	if status(other, ledger.EntryRef{Transaction: late.Id}) != reconcile.StatusCleared {
+		t.Error("matched entry should be cleared for another reconciler")
+	}
+	resumed, err := other.Resume(rc.ID, bank.ID, s)
+	if err != nil {
+		t.Fatalf("unexpected error resuming: %v", err)
+	}
+	if m := resumed.Matches(); len(m) != 3 || m[2].Transaction != late.Id {
+		t.Errorf("unexpected resumed matches %+v", m)
+	}
+
+	// a stale copy cannot unmatch the entry once another reconciliation cleared it
+	if err := resumed.Unmatch(2); err != nil {
+		t.Fatalf("unexpected error unmatching: %v", err)
+	}
+	foreign, _ := other.Start(bank.ID, s)
+	if err := foreign.Match(2, ledger.EntryRef{Transaction: late.Id}); err != nil {
+		t.Fatalf("unexpected error matching: %v", err)
+	}
+	if err := rc.Unmatch(2); err == nil {
+		t.Error("entries cleared by another reconciliation cannot be unmatched")
+	}
+	if c, _ := l.Clearing(ledger.EntryRef{Transaction: late.Id}); c.Reconciliation != foreign.ID {
+		t.Errorf("entry should stay cleared by the other reconciliation but got %+v", c)
+	}
+	foreign.Unmatch(2)
+	resumed.Match(2, ledger.EntryRef{Transaction: late.Id})
+
+	if _, err := rc.Finish(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status(r, ledger.EntryRef{Transaction: late.Id}) != reconcile.StatusReconciled {
+		t.Error("matched entries should be reconciled after finishing")
+	}
+	if resumed, err := other.Resume(rc.ID, bank.ID, s); err != nil || resumed.Match(3, ledger.EntryRef{Transaction: uncleared.Id}) == nil {
+		t.Errorf("resumed finished reconciliation cannot be changed (%v)", err)
+	}
+	if err := rc.Unmatch(2); err == nil {
+		t.Error("finished reconciliations cannot be changed")
+	}
+
+	// a reconciled entry cannot be matched again
+	next, _ := r.Start(bank.ID, s)
+	if n, _ := next.AutoMatch(); n != 0 {
+		t.Errorf("reconciled entries should not be matched again but got %d matches", n)
+	}
+
+	// a reconciliation finished without matches is resumed finished
+	if _, err := next.Finish(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, err := other.Resume(next.ID, bank.ID, s)
+	if err != nil {
+		t.Fatalf("unexpected error resuming: %v", err)
+	}
+	if _, err := again.Finish(); !errors.Is(err, ledger.ErrReconciliationFinished) {
+		t.Errorf("finished reconciliation cannot be finished again but got %v", err)
+	}
+	if _, err := again.AutoMatch(); !errors.Is(err, ledger.ErrReconciliationFinished) {
+		t.Errorf("finished reconciliation cannot be changed but got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// report package presents the balances of a ledger as financial statements.
+//
+// The accounts are grouped by AccountType and, inside each type, in sections by AccountSubType:
+//   - The amounts are shown with the normal sign of the group, so a contra account is a deduction.
+//   - A contra account is shown in the section of the account it offsets, its nearest non-contra ancestor,
+//     so the accumulated depreciation is deducted right under the equipment it depreciates.
+//   - Accounts with no balance are left out.
+//
+// A report can be restricted to the entries with some dimension values, and an income statement
+// can be split in segments, one for each combination of values of some dimensions.
+package report
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// Line is an account of a report.
+type Line struct {
+	Account ledger.Account
+	Amount  int // With the normal sign of the group, negative for a contra account with its normal balance.
+}
+
+// Section is the accounts of a group with the same sub-type, an empty sub-type holds the unclassified accounts.
+type Section struct {
+	SubType ledger.AccountSubType
+	Lines   []Line
+	Total   int
+}
+
+// Group is the accounts of a report with the same AccountType.
+type Group struct {
+	Type     ledger.AccountType
+	Sections []Section
+	Total    int
+}
+
+// Report is a financial statement.
+type Report struct {
+	From      time.Time // Zero for a balance sheet.
+	To        time.Time // Exclusive.
+	Groups    []Group
+	NetIncome int // Revenue minus expenses, in the balance sheet the earnings not closed to equity yet.
+}
+
+// Option configures a report.
+type Option func(*options)
+
+type options struct {
+	filter ledger.Dimensions
+}
+
+// Where restricts the report to the entries with all the given dimension values.
+func Where(filter ledger.Dimensions) Option {
+	return func(o *options) {
+		o.filter = filter
+	}
+}
+
+// Group returns the group of the account type, or an empty one if the report does not have it.
+func (r *Report) Group(t ledger.AccountType) Group {
+	for _, g := range r.Groups {
+		if g.Type == t {
+			return g
+		}
+	}
+	return Group{Type: t}
+}
+
+// BalanceSheet reports the assets, liabilities and equity with the balances before the given time.
+func BalanceSheet(l *ledger.Ledger, at time.Time, opts ...Option) (*Report, error) {
+	return build(l, time.Time{}, at, []ledger.AccountType{ledger.AccountTypeAsset, ledger.AccountTypeLiability, ledger.AccountTypeEquity}, opts)
+}
+
+// IncomeStatement reports the revenues and expenses of the entries in the range [from, to).
+func IncomeStatement(l *ledger.Ledger, from, to time.Time, opts ...Option) (*Report, error) {
+	return build(l, from, to, []ledger.AccountType{ledger.AccountTypeRevenue, ledger.AccountTypeExpense}, opts)
+}
+
+// Segment is the income statement of a combination of dimension values.
+type Segment struct {
+	Dimensions ledger.Dimensions // An entry without one of the dimensions is in the segment with "".
+	Report     *Report
+}
+
+// Segments returns an income statement for each combination of values of the dimensions
+// that has revenue or expense entries in the range [from, to), ordered by the dimension values.
+func Segments(l *ledger.Ledger, from, to time.Time, dims ...ledger.Dimension) ([]Segment, error) {
+	balances, err := l.DimensionBalances(ledger.DimensionQuery{GroupBy: dims, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	seen := make(map[string]bool)
+	for _, b := range balances {
+		a, err := l.Account(b.Account)
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprint(valuesOf(b.Dimensions, dims))
+		if seen[key] || (a.AccountType != ledger.AccountTypeRevenue && a.AccountType != ledger.AccountTypeExpense) {
+			continue
+		}
+		seen[key] = true
+
+		r, err := IncomeStatement(l, from, to, Where(b.Dimensions))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, Segment{Dimensions: b.Dimensions, Report: r})
+	}
+	slices.SortFunc(segments, func(a, b Segment) int {
+		return slices.Compare(valuesOf(a.Dimensions, dims), valuesOf(b.Dimensions, dims))
+	})
+	return segments, nil
+}
+
+func valuesOf(d ledger.Dimensions, dims []ledger.Dimension) []string {
+	values := make([]string, len(dims))
+	for i, dim := range dims {
+		values[i] = d[dim]
+	}
+	return values
+}
+
+func build(l *ledger.Ledger, from, to time.Time, types []ledger.AccountType, opts []Option) (*Report, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	accounts, err := l.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]ledger.Account, len(accounts))
+	for _, a := range accounts {
+		byID[a.ID] = a
+	}
+
+	r := &Report{From: from, To: to}
+	totals := make(map[ledger.AccountType]int)
+	for _, a := range accounts {
+		entries, err := l.Entries(a.ID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		balance := 0
+		for _, e := range entries {
+			if e.Dimensions.Matches(o.filter) {
+				balance += e.Amount
+			}
+		}
+		amount := balance * a.AccountType.NormalSign()
+		totals[a.AccountType] += amount
+		if amount == 0 || !slices.Contains(types, a.AccountType) {
+			continue
+		}
+
+		g := r.group(a.AccountType)
+		s := g.section(sectionOf(a, byID))
+		s.Lines = append(s.Lines, Line{Account: a, Amount: amount})
+		s.Total += amount
+		g.Total += amount
+	}
+	r.NetIncome = totals[ledger.AccountTypeRevenue] - totals[ledger.AccountTypeExpense]
+
+	// groups and sections in presentation order, the unclassified accounts last
+	slices.SortFunc(r.Groups, func(a, b Group) int {
+		return slices.Index(types, a.Type) - slices.Index(types, b.Type)
+	})
+	for _, g := range r.Groups {
+		order := append(g.Type.SubTypes(), "")
+		slices.SortFunc(g.Sections, func(a, b Section) int {
+			return slices.Index(order, a.SubType) - slices.Index(order, b.SubType)
+		})
+	}
+	return r, nil
+}
+
+// sectionOf returns the sub-type of the section of the account,
+// the one of its nearest non-contra ancestor for a contra account.
+func sectionOf(a ledger.Account, accounts map[uuid.UUID]ledger.Account) ledger.AccountSubType {
+	if !a.SubType.IsContra() {
+		return a.SubType
+	}
+	for p, ok := accounts[a.ParentID]; ok; p, ok = accounts[p.ParentID] {
+		if !p.SubType.IsContra() {
+			return p.SubType
+		}
+	}
+	return a.SubType
+}
+
+func (r *Report) group(t ledger.AccountType) *Group {
+	for i := range r.Groups {
+		if r.Groups[i].Type == t {
+			return &r.Groups[i]
+		}
+	}
+	r.Groups = append(r.Groups, Group{Type: t})
+	return &r.Groups[len(r.Groups)-1]
+}
+
+func (g *Group) section(s ledger.AccountSubType) *Section {
+	for i := range g.Sections {
+		if g.Sections[i].SubType == s {
+			return &g.Sections[i]
+		}
+	}
+	g.Sections = append(g.Sections, Section{SubType: s})
+	return &g.Sections[len(g.Sections)-1]
+}
@@ -0,0 +1,194 @@
+package report_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/report"
+	"github.com/tarcisio/haya/pkg/statement"
+)
+
+func Test_Report(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	cash := &ledger.Account{Name: "Cash", AccountType: ledger.AccountTypeAsset, SubType: ledger.SubTypeCurrentAsset}
+	equipment := &ledger.Account{Name: "Equipment", AccountType: ledger.AccountTypeAsset, SubType: ledger.SubTypeNonCurrentAsset}
+	l.CreateAccount(cash)
+	l.CreateAccount(equipment)
+	depreciation := &ledger.Account{Name: "Accumulated depreciation", ParentID: equipment.ID, AccountType: ledger.AccountTypeAsset, SubType: ledger.SubTypeContraAsset}
+	capital := &ledger.Account{Name: "Capital", AccountType: ledger.AccountTypeEquity, SubType: ledger.SubTypeCapital}
+	sales := &ledger.Account{Name: "Sales", AccountType: ledger.AccountTypeRevenue, SubType: ledger.SubTypeOperatingRevenue}
+	l.CreateAccount(depreciation)
+	l.CreateAccount(capital)
+	l.CreateAccount(sales)
+	returns := &ledger.Account{Name: "Sales returns", ParentID: sales.ID, AccountType: ledger.AccountTypeRevenue, SubType: ledger.SubTypeContraRevenue}
+	expense := &ledger.Account{Name: "Depreciation expense", AccountType: ledger.AccountTypeExpense, SubType: ledger.SubTypeOperatingExpense}
+	other := &ledger.Account{Name: "Misc", AccountType: ledger.AccountTypeExpense}
+	l.CreateAccount(returns)
+	l.CreateAccount(expense)
+	l.CreateAccount(other)
+
+	if err := l.CreateAccount(&ledger.Account{Name: "Wrong", AccountType: ledger.AccountTypeAsset, SubType: ledger.SubTypeContraRevenue}); err == nil {
+		t.Error("sub-type of another account type should be rejected")
+	}
+
+	post := func(debit, credit *ledger.Account, amount int) {
+		tx := ledger.NewTransaction(day)
+		tx.AddEntries([]ledger.Entry{{Account: debit.ID, Amount: amount}, {Account: credit.ID, Amount: -amount}})
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	post(cash, capital, 20000)
+	post(equipment, cash, 10000)
+	post(expense, depreciation, 3000)
+	post(cash, sales, 5000)
+	post(returns, cash, 500)
+	post(other, cash, 100)
+
+	// a contra account has the normal balance opposite to its type
+	if b, _ := l.Balance(depreciation.ID); b.Natural() != 3000 {
+		t.Errorf("accumulated depreciation should have natural balance 3000 but got %d", b.Natural())
+	}
+	if b, _ := l.Balance(returns.ID); b.Natural() != 500 {
+		t.Errorf("sales returns should have natural balance 500 but got %d", b.Natural())
+	}
+
+	sheet, err := report.BalanceSheet(l, day.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assets := sheet.Group(ledger.AccountTypeAsset)
+	if len(assets.Sections) != 2 || assets.Sections[0].SubType != ledger.SubTypeCurrentAsset || assets.Total != 21400 {
+		t.Fatalf("unexpected assets %+v", assets)
+	}
+	// the depreciation is deducted in the section of the equipment
+	if fixed := assets.Sections[1]; fixed.SubType != ledger.SubTypeNonCurrentAsset || fixed.Total != 7000 || len(fixed.Lines) != 2 || fixed.Lines[1].Amount != -3000 {
+		t.Errorf("unexpected non-current assets %+v", fixed)
+	}
+	if equity := sheet.Group(ledger.AccountTypeEquity); equity.Total != 20000 || sheet.NetIncome != 1400 {
+		t.Errorf("unexpected equity %d and net income %d", equity.Total, sheet.NetIncome)
+	}
+	if sheet.Group(ledger.AccountTypeAsset).Total != sheet.Group(ledger.AccountTypeLiability).Total+sheet.Group(ledger.AccountTypeEquity).Total+sheet.NetIncome {
+		t.Error("balance sheet should balance")
+	}
+
+	income, _ := report.IncomeStatement(l, day, day.Add(time.Hour))
+	if revenue := income.Group(ledger.AccountTypeRevenue); revenue.Total != 4500 || revenue.Sections[0].Lines[1].Amount != -500 {
+		t.Errorf("unexpected revenue %+v", revenue)
+	}
+	expenses := income.Group(ledger.AccountTypeExpense)
+	if len(expenses.Sections) != 2 || expenses.Sections[1].SubType != "" || expenses.Total != 3100 || income.NetIncome != 1400 {
+		t.Errorf("unexpected expenses %+v", expenses)
+	}
+	if len(income.Groups) != 2 {
+		t.Errorf("income statement should only have revenue and expenses but got %d groups", len(income.Groups))
+	}
+
+	// the income statement only has the entries of the range
+	if empty, _ := report.IncomeStatement(l, day.Add(time.Hour), day.Add(2*time.Hour)); len(empty.Groups) != 0 || empty.NetIncome != 0 {
+		t.Errorf("unexpected income statement %+v", empty)
+	}
+}
+
+func Test_Segments(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	sales := &ledger.Account{Name: "Sales", AccountType: ledger.AccountTypeRevenue}
+	salaries := &ledger.Account{Name: "Salaries", AccountType: ledger.AccountTypeExpense}
+	for _, a := range []*ledger.Account{bank, sales, salaries} {
+		l.CreateAccount(a)
+	}
+	l.RegisterDimension(ledger.DimensionCatalog{Dimension: ledger.DimensionDepartment, Values: []string{"east", "west"}})
+
+	post := func(account *ledger.Account, amount int, department string) {
+		tx := ledger.NewTransaction(day)
+		tx.AddEntries([]ledger.Entry{
+			{Account: account.ID, Amount: amount, Dimensions: ledger.Dimensions{ledger.DimensionDepartment: department}},
+			{Account: bank.ID, Amount: -amount},
+		})
+		if err := l.Post(tx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	post(sales, -1000, "east")
+	post(salaries, 600, "east")
+	post(sales, -500, "west")
+	post(salaries, 700, "west")
+
+	west, _ := report.IncomeStatement(l, day, day.Add(time.Hour), report.Where(ledger.Dimensions{ledger.DimensionDepartment: "west"}))
+	if west.NetIncome != -200 || west.Group(ledger.AccountTypeRevenue).Total != 500 {
+		t.Errorf("unexpected income statement of west %+v", west)
+	}
+
+	segments, err := report.Segments(l, day, day.Add(time.Hour), ledger.DimensionDepartment)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(segments) != 2 || segments[0].Dimensions[ledger.DimensionDepartment] != "east" || segments[0].Report.NetIncome != 400 || segments[1].Report.NetIncome != -200 {
+		t.Errorf("unexpected segments %+v", segments)
+	}
+}
+
+func Test_Statement(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	sales := &ledger.Account{Name: "Sales", AccountType: ledger.AccountTypeRevenue}
+	l.CreateAccount(bank)
+	l.CreateAccount(sales)
+
+	for i, amount := range []int{10000, 2550, -1275} {
+		tx := ledger.NewTransaction(day.AddDate(0, 0, i))
+		tx.AddEntries([]ledger.Entry{
+			{Account: bank.ID, Amount: amount, Description: "Payment", Metadata: map[string]string{"fitid": "F" + string(rune('1'+i)), "payer": "acme; sons=co"}},
+			{Account: sales.ID, Amount: -amount},
+		})
+		l.Post(tx)
+	}
+
+	s, err := report.Statement(l, bank.ID, day.AddDate(0, 0, 1), day.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Opening != 10000 || s.Closing != 11275 || len(s.Lines) != 2 || s.Lines[0].Balance != 12550 || s.Lines[1].Metadata["fitid"] != "F3" {
+		t.Errorf("unexpected statement %+v", s)
+	}
+
+	// a backdated posting is listed by its date, the running balance follows it
+	backdated := ledger.NewTransaction(day.AddDate(0, 0, 1).Add(-time.Hour))
+	backdated.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 500}, {Account: sales.ID, Amount: -500}})
+	l.Post(backdated)
+	late := ledger.NewTransaction(day.AddDate(0, 0, 1).Add(time.Hour))
+	late.AddEntries([]ledger.Entry{{Account: bank.ID, Amount: 100}, {Account: sales.ID, Amount: -100}})
+	l.Post(late)
+	if s, _ := report.Statement(l, bank.ID, day, day.AddDate(0, 0, 3)); len(s.Lines) != 5 || s.Lines[1].Transaction != backdated.Id || s.Lines[2].Balance != 13050 || s.Lines[3].Transaction != late.Id || s.Lines[3].Balance != 13150 || s.Closing != 11875 {
+		t.Errorf("unexpected statement with a backdated posting %+v", s)
+	}
+
+	// the exported statement can be imported back, with the metadata values containing the separators
+	var buf bytes.Buffer
+	if err := s.WriteCSV(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	imported, err := statement.ParseCSV(&buf, report.StatementCSVLayout)
+	if err != nil {
+		t.Fatalf("unexpected error importing: %v", err)
+	}
+	if len(imported.Lines) != 2 || imported.Lines[1].Amount != -1275 || imported.Lines[1].Payee != "Payment" || imported.Lines[1].FITID != s.Lines[1].Transaction.String()+"/0" {
+		t.Errorf("unexpected imported lines %+v", imported.Lines)
+	}
+	metadata, err := report.ParseCSVMetadata(imported.Lines[1].Memo)
+	if err != nil || len(metadata) != 2 || metadata["fitid"] != "F3" || metadata["payer"] != "acme; sons=co" {
+		t.Errorf("unexpected imported metadata %v (%v)", metadata, err)
+	}
+}
@@ -0,0 +1,148 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/statement"
+)
+
+// AccountStatement lists the entries of an account in a date range with the running balance.
+type AccountStatement struct {
+	Account ledger.Account
+	From    time.Time
+	To      time.Time // Exclusive.
+	Opening int       // The natural balance before From.
+	Closing int       // The natural balance after the last line.
+	Lines   []StatementLine
+}
+
+// StatementLine is an entry of an account statement, the amounts have the normal sign of the account.
+type StatementLine struct {
+	ledger.EntryRef
+	Date        time.Time
+	Description string
+	Metadata    map[string]string
+	Amount      int
+	Balance     int // The running balance after the line.
+}
+
+// Statement returns the statement of the account for the entries in the range [from, to), in date order.
+func Statement(l *ledger.Ledger, account uuid.UUID, from, to time.Time) (*AccountStatement, error) {
+	a, err := l.Account(account)
+	if err != nil {
+		return nil, err
+	}
+	opening, err := l.BalanceAt(account, from)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := l.Entries(account, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// the entries are in posting order, a backdated one can come after later ones
+	slices.SortStableFunc(entries, func(x, y ledger.PostedEntry) int { return x.Timestamp.Compare(y.Timestamp) })
+
+	s := &AccountStatement{Account: a, From: from, To: to, Opening: opening.Natural(), Closing: opening.Natural()}
+	for _, e := range entries {
+		amount := e.Amount * a.NormalSign()
+		s.Closing += amount
+		s.Lines = append(s.Lines, StatementLine{
+			EntryRef:    e.EntryRef,
+			Date:        e.Timestamp,
+			Description: e.Description,
+			Metadata:    e.Metadata,
+			Amount:      amount,
+			Balance:     s.Closing,
+		})
+	}
+	return s, nil
+}
+
+// StatementCSVLayout is the layout to import back with statement.ParseCSV a statement written by WriteCSV.
+// The transaction column is the FITID, the description is the Payee and the metadata is the Memo, see ParseCSVMetadata.
+var StatementCSVLayout = statement.CSVLayout{
+	DateLayout: time.DateOnly,
+	Date:       "date",
+	Amount:     "amount",
+	Payee:      "description",
+	Memo:       "metadata",
+	FITID:      "transaction",
+}
+
+// WriteCSV exports the statement lines as CSV with a header.
+//
+// The columns are date, amount, balance, description, transaction and metadata.
+//   - The amounts are decimals with two places.
+//   - The transaction is the id of the transaction and the index of the entry, as "id/index".
+//   - The metadata is key=value pairs sorted by key and joined by ";", the keys and values are query escaped
+//     so they can contain any character, see ParseCSVMetadata.
+//
+// The file can be imported back with statement.ParseCSV and StatementCSVLayout.
+func (s *AccountStatement) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "amount", "balance", "description", "transaction", "metadata"})
+	for _, l := range s.Lines {
+		keys := make([]string, 0, len(l.Metadata))
+		for k := range l.Metadata {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		pairs := make([]string, len(keys))
+		for i, k := range keys {
+			pairs[i] = url.QueryEscape(k) + "=" + url.QueryEscape(l.Metadata[k])
+		}
+
+		cw.Write([]string{
+			l.Date.Format(time.DateOnly),
+			decimal(l.Amount),
+			decimal(l.Balance),
+			l.Description,
+			l.Transaction.String() + "/" + strconv.Itoa(l.Index),
+			strings.Join(pairs, ";"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ParseCSVMetadata decodes the metadata column written by WriteCSV.
+func ParseCSVMetadata(s string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if s == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(s, ";") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+		var err error
+		if k, err = url.QueryUnescape(k); err != nil {
+			return nil, err
+		}
+		if metadata[k], err = url.QueryUnescape(v); err != nil {
+			return nil, err
+		}
+	}
+	return metadata, nil
+}
+
+// decimal formats an amount in minor units with two decimal places.
+func decimal(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
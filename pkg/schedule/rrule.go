@@ -0,0 +1,331 @@
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frequency is the FREQ of a recurrence rule.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// maxEmptyPeriods is how many periods in a row may have no occurrence before the rule is considered exhausted,
+// so a rule like FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30 ends.
+const maxEmptyPeriods = 1000
+
+// RRule is a subset of the iCalendar (RFC 5545) recurrence rules.
+//
+// The supported parts are FREQ, INTERVAL, COUNT, UNTIL, BYMONTH (yearly), BYMONTHDAY (monthly and yearly)
+// and BYDAY (weekly, and monthly with an optional ordinal such as 1MO or -1FR).
+// The first occurrence is at Start, if it matches the rule, and all of them have the time of day of Start.
+// The rule must not be changed once Next was called.
+type RRule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int       // The maximum number of occurrences, 0 for no limit.
+	Until      time.Time // The last possible occurrence, zero for no limit.
+	ByMonth    []time.Month
+	ByMonthDay []int // Negative days count from the end of the month, -1 is the last day.
+	ByDay      []WeekdayNum
+	Start      time.Time
+
+	mu     sync.Mutex
+	cursor cursor // The last occurrence returned with a Count, Next resumes counting from it.
+}
+
+// cursor is an occurrence of a rule with the number of occurrences in the periods before its own.
+type cursor struct {
+	at     time.Time
+	period int
+	count  int
+}
+
+// WeekdayNum is a BYDAY value, the Nth weekday of the month or every one of them if N is 0.
+type WeekdayNum struct {
+	Weekday time.Weekday
+	N       int
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// ParseRRule parses a recurrence rule such as "FREQ=MONTHLY;BYMONTHDAY=-1" starting at the given time.
+// The "RRULE:" prefix is optional.
+func ParseRRule(rule string, start time.Time) (*RRule, error) {
+	r := &RRule{Interval: 1, Start: start}
+	for _, part := range strings.Split(strings.TrimPrefix(rule, "RRULE:"), ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		var err error
+		switch key {
+		case "FREQ":
+			r.Freq = Frequency(value)
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+		case "UNTIL":
+			r.Until, err = parseUntil(value, start.Location())
+		case "BYMONTH":
+			err = eachInt(value, func(v int) { r.ByMonth = append(r.ByMonth, time.Month(v)) })
+		case "BYMONTHDAY":
+			err = eachInt(value, func(v int) { r.ByMonthDay = append(r.ByMonthDay, v) })
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wd, ok := weekdays[d[max(len(d)-2, 0):]]
+				if !ok {
+					return nil, fmt.Errorf("invalid weekday %q", d)
+				}
+				n := 0
+				if len(d) > 2 {
+					if n, err = strconv.Atoi(d[:len(d)-2]); err != nil {
+						return nil, fmt.Errorf("invalid weekday %q", d)
+					}
+				}
+				r.ByDay = append(r.ByDay, WeekdayNum{Weekday: wd, N: n})
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return r, r.validate()
+}
+
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if len(value) == 8 {
+		// a date is inclusive, the whole day is allowed
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), err
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+func eachInt(value string, f func(int)) error {
+	for _, s := range strings.Split(value, ",") {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f(v)
+	}
+	return nil
+}
+
+func (r *RRule) validate() error {
+	switch r.Freq {
+	case Daily, Weekly, Monthly, Yearly:
+	default:
+		return fmt.Errorf("unsupported frequency %q", r.Freq)
+	}
+	if r.Interval <= 0 || r.Count < 0 {
+		return fmt.Errorf("invalid interval %d or count %d", r.Interval, r.Count)
+	}
+	if r.Start.IsZero() {
+		return fmt.Errorf("rule has no start")
+	}
+	for _, m := range r.ByMonth {
+		if m < time.January || m > time.December {
+			return fmt.Errorf("invalid month %d", m)
+		}
+	}
+	for _, d := range r.ByMonthDay {
+		if d == 0 || d < -31 || d > 31 {
+			return fmt.Errorf("invalid day of month %d", d)
+		}
+	}
+	for _, d := range r.ByDay {
+		if d.N < -5 || d.N > 5 || (d.N != 0 && r.Freq != Monthly) {
+			return fmt.Errorf("invalid weekday ordinal %d for %s", d.N, r.Freq)
+		}
+	}
+	if len(r.ByMonth) > 0 && r.Freq != Yearly {
+		return fmt.Errorf("BYMONTH is only supported with %s", Yearly)
+	}
+	if len(r.ByMonthDay) > 0 && r.Freq != Monthly && r.Freq != Yearly {
+		return fmt.Errorf("BYMONTHDAY is only supported with %s and %s", Monthly, Yearly)
+	}
+	if len(r.ByDay) > 0 && len(r.ByMonthDay) > 0 {
+		return fmt.Errorf("BYDAY and BYMONTHDAY cannot be combined")
+	}
+	if len(r.ByDay) > 0 && r.Freq != Weekly && r.Freq != Monthly {
+		return fmt.Errorf("BYDAY is only supported with %s and %s", Weekly, Monthly)
+	}
+	return nil
+}
+
+// Next returns the first occurrence after the given time, or the zero time if there is none.
+//
+// Without a Count, it starts from the period of the given time. With a Count, the occurrences are counted
+// from the last one returned if the given time is not before it, or from the start otherwise.
+// Either way, iterating the occurrences by passing the previous one is linear.
+func (r *RRule) Next(after time.Time) time.Time {
+	first, count := 0, 0
+	if r.Count == 0 {
+		first = r.periodOf(after)
+	} else {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if c := r.cursor; !c.at.IsZero() && !after.Before(c.at) {
+			first, count = c.period, c.count
+		}
+	}
+
+	empty := 0
+	for period := first; empty < maxEmptyPeriods; period++ {
+		days := r.period(period)
+		if len(days) == 0 {
+			empty++
+			continue
+		}
+		empty = 0
+
+		before := count
+		for _, t := range days {
+			if t.Before(r.Start) {
+				continue
+			}
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return time.Time{}
+			}
+			if count++; r.Count > 0 && count > r.Count {
+				return time.Time{}
+			}
+			if t.After(after) {
+				if r.Count > 0 {
+					r.cursor = cursor{at: t, period: period, count: before}
+				}
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// periodOf returns the period since the start the given time is in, or an earlier one.
+// It is 0 for the times before the start.
+func (r *RRule) periodOf(t time.Time) int {
+	s := r.Start
+	if !t.After(s) {
+		return 0
+	}
+	t = t.In(s.Location())
+
+	// the dates are compared in UTC so the days all have 24 hours
+	days := int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Sub(time.Date(s.Year(), s.Month(), s.Day(), 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+	var units int
+	switch r.Freq {
+	case Daily:
+		units = days
+	case Weekly:
+		units = days / 7
+	case Monthly:
+		units = (t.Year()-s.Year())*12 + int(t.Month()-s.Month())
+	case Yearly:
+		units = t.Year() - s.Year()
+	}
+	// one period back, as the time of the day of the start can be after the given time
+	return max(units/r.Interval-1, 0)
+}
+
+// period returns the sorted candidate occurrences of the nth period since the start.
+func (r *RRule) period(n int) []time.Time {
+	s := r.Start
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, s.Hour(), s.Minute(), s.Second(), s.Nanosecond(), s.Location())
+	}
+	step := n * r.Interval
+
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		days = append(days, at(s.Year(), s.Month(), s.Day()+step))
+
+	case Weekly:
+		monday := s.Day() - (int(s.Weekday())+6)%7 + 7*step
+		byDay := r.ByDay
+		if len(byDay) == 0 {
+			byDay = []WeekdayNum{{Weekday: s.Weekday()}}
+		}
+		for _, d := range byDay {
+			days = append(days, at(s.Year(), s.Month(), monday+(int(d.Weekday)+6)%7))
+		}
+
+	case Monthly:
+		first := at(s.Year(), s.Month()+time.Month(step), 1)
+		days = r.monthDays(first, len(r.ByDay) == 0)
+		for _, d := range r.ByDay {
+			days = append(days, nthWeekdays(first, d)...)
+		}
+
+	case Yearly:
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{s.Month()}
+		}
+		for _, m := range months {
+			days = append(days, r.monthDays(at(s.Year()+step, m, 1), true)...)
+		}
+	}
+
+	slices.SortFunc(days, func(a, b time.Time) int { return a.Compare(b) })
+	return slices.CompactFunc(days, time.Time.Equal)
+}
+
+// monthDays returns the BYMONTHDAY days of the month, or the day of the start if there are none and byDefault is set.
+// Days that the month does not have are skipped.
+func (r *RRule) monthDays(first time.Time, byDefault bool) []time.Time {
+	byMonthDay := r.ByMonthDay
+	if len(byMonthDay) == 0 && byDefault {
+		byMonthDay = []int{r.Start.Day()}
+	}
+
+	last := first.AddDate(0, 1, -1).Day()
+	var days []time.Time
+	for _, d := range byMonthDay {
+		if d < 0 {
+			d = last + d + 1
+		}
+		if d >= 1 && d <= last {
+			days = append(days, first.AddDate(0, 0, d-1))
+		}
+	}
+	return days
+}
+
+// nthWeekdays returns the days of the month matching the weekday, all of them if N is 0.
+func nthWeekdays(first time.Time, d WeekdayNum) []time.Time {
+	var all []time.Time
+	for t := first.AddDate(0, 0, (int(d.Weekday)-int(first.Weekday())+7)%7); t.Month() == first.Month(); t = t.AddDate(0, 0, 7) {
+		all = append(all, t)
+	}
+	switch {
+	case d.N == 0:
+		return all
+	case d.N > 0 && d.N <= len(all):
+		return all[d.N-1 : d.N]
+	case d.N < 0 && -d.N <= len(all):
+		return all[len(all)+d.N : len(all)+d.N+1]
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// schedule package posts recurring transactions, such as rent, subscriptions and depreciation, when they are due.
+//
+// A Recurring transaction is a set of entries with a Schedule, either a cron expression or a recurrence rule:
+//   - Each occurrence is materialized as a regular transaction with an id derived from the occurrence,
+//     so running the scheduler again, even after a restart, never posts the same occurrence twice.
+//   - The occurrences of a date range can be previewed without posting anything.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// Metadata keys set on the materialized transactions.
+const (
+	MetadataRecurring  = "recurring"  // The id of the recurring transaction.
+	MetadataOccurrence = "occurrence" // The occurrence time in RFC 3339.
+)
+
+var ErrRecurringNotFound = errors.New("recurring transaction not found")
+
+// Schedule returns the occurrences of a recurring transaction.
+type Schedule interface {
+	// Next returns the first occurrence after the given time, or the zero time if there is none.
+	Next(after time.Time) time.Time
+}
+
+// Recurring is a transaction posted on a schedule between Start and End.
+type Recurring struct {
+	ID       uuid.UUID
+	Name     string
+	Journal  uuid.UUID
+	Entries  []ledger.Entry
+	Metadata map[string]string // Copied to every occurrence.
+	Schedule Schedule
+	Start    time.Time // The first possible occurrence, its location is used to evaluate the schedule.
+	End      time.Time // The last possible occurrence, zero for no end.
+}
+
+func (r *Recurring) validate() error {
+	if r.Name == "" {
+		return errors.New("recurring transaction has no name")
+	}
+	if r.Schedule == nil {
+		return errors.New("recurring transaction has no schedule")
+	}
+	if r.Start.IsZero() {
+		return errors.New("recurring transaction has no start")
+	}
+	if !r.End.IsZero() && r.End.Before(r.Start) {
+		return errors.New("recurring transaction ends before it starts")
+	}
+	t := &ledger.Transaction{Entries: r.Entries}
+	if _, err := t.IsBalanced(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// occurrences returns the occurrences after the first time and until the second, inclusive.
+func (r *Recurring) occurrences(after, until time.Time) []time.Time {
+	if !r.End.IsZero() && until.After(r.End) {
+		until = r.End
+	}
+	if start := r.Start.Add(-time.Nanosecond); after.Before(start) {
+		after = start
+	}
+
+	var times []time.Time
+	for t := r.Schedule.Next(after.In(r.Start.Location())); !t.IsZero() && !t.After(until); t = r.Schedule.Next(t) {
+		times = append(times, t)
+	}
+	return times
+}
+
+// Transaction returns the transaction of the occurrence at the given time.
+// Its id only depends on the recurring transaction and the time, so it is the same every time it is built.
+func (r *Recurring) Transaction(at time.Time) *ledger.Transaction {
+	occurrence := at.UTC().Format(time.RFC3339Nano)
+
+	t := ledger.NewRegularTransaction(at)
+	t.Id = uuid.NewSHA1(r.ID, []byte(occurrence))
+	t.Journal = r.Journal
+	t.AddEntries(r.Entries)
+	t.Metadata = maps.Clone(r.Metadata)
+	if t.Metadata == nil {
+		t.Metadata = make(map[string]string, 2)
+	}
+	t.Metadata[MetadataRecurring] = r.ID.String()
+	t.Metadata[MetadataOccurrence] = occurrence
+	return t
+}
+
+// Scheduler materializes the due occurrences of its recurring transactions into a ledger.
+//
+// It is safe for concurrent use. It remembers up to when each recurring transaction was materialized,
+// a new scheduler starts from the Start of each one and skips the occurrences already posted.
+type Scheduler struct {
+	ledger *ledger.Ledger
+
+	mu        sync.Mutex
+	recurring map[uuid.UUID]*Recurring
+	done      map[uuid.UUID]time.Time // Up to when the occurrences were posted.
+}
+
+// New creates a scheduler that posts to the given ledger.
+func New(l *ledger.Ledger) *Scheduler {
+	return &Scheduler{
+		ledger:    l,
+		recurring: make(map[uuid.UUID]*Recurring),
+		done:      make(map[uuid.UUID]time.Time),
+	}
+}
+
+// Add validates the recurring transaction and adds it to the scheduler, assigning an ID if it has none.
+// The entries are not checked against the ledger accounts until the occurrences are posted.
+func (s *Scheduler) Add(r *Recurring) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.recurring[r.ID]; ok {
+		return fmt.Errorf("recurring transaction %s already exists", r.ID)
+	}
+	s.recurring[r.ID] = r
+	return nil
+}
+
+// Remove removes the recurring transaction, its posted occurrences are kept.
+func (s *Scheduler) Remove(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.recurring[id]; !ok {
+		return ErrRecurringNotFound
+	}
+	delete(s.recurring, id)
+	delete(s.done, id)
+	return nil
+}
+
+// Preview returns the transactions of all the occurrences in the range [from, to), ordered by timestamp.
+// Nothing is posted, the occurrences already posted are included too.
+func (s *Scheduler) Preview(from, to time.Time) []*ledger.Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ts []*ledger.Transaction
+	for _, r := range s.recurring {
+		for _, at := range r.occurrences(from.Add(-time.Nanosecond), to.Add(-time.Nanosecond)) {
+			ts = append(ts, r.Transaction(at))
+		}
+	}
+	slices.SortStableFunc(ts, func(a, b *ledger.Transaction) int { return a.Timestamp.Compare(b.Timestamp) })
+	return ts
+}
+
+// Run posts every occurrence due up to now, inclusive, and returns the posted transactions.
+//
+// The occurrences that were already posted are skipped. If posting one fails,
+// the later occurrences of the same recurring transaction are tried again in the next run.
+// It is meant to be called periodically.
+func (s *Scheduler) Run(now time.Time) ([]*ledger.Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var posted []*ledger.Transaction
+	var errs []error
+	for id, r := range s.recurring {
+		after, ok := s.done[id]
+		if !ok {
+			after = r.Start.Add(-time.Nanosecond)
+		}
+
+		for _, at := range r.occurrences(after, now) {
+			t := r.Transaction(at)
+			err := s.ledger.Post(t)
+			if err != nil && !errors.Is(err, ledger.ErrDuplicateTransaction) {
+				errs = append(errs, fmt.Errorf("%s at %s: %w", r.Name, at, err))
+				break
+			}
+			if err == nil {
+				posted = append(posted, t)
+			}
+			s.done[id] = at
+		}
+	}
+	slices.SortStableFunc(posted, func(a, b *ledger.Transaction) int { return a.Timestamp.Compare(b.Timestamp) })
+	return posted, errors.Join(errs...)
+}
@@ -0,0 +1,202 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/schedule"
+)
+
+func Test_ParseCron(t *testing.T) {
+
+	tests := []struct {
+		expr  string
+		after time.Time
+		want  []time.Time
+	}{
+		{
+			expr:  "0 9 1 * *",
+			after: time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC),
+			want:  []time.Time{time.Date(2026, 2, 1, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)},
+		},
+		{
+			expr:  "*/30 8-9 * * 1-5",
+			after: time.Date(2026, 10, 16, 9, 10, 0, 0, time.UTC), // a Friday
+			want:  []time.Time{time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC), time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)},
+		},
+		{
+			// the day of month or the day of week
+			expr:  "0 0 13 * 5",
+			after: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			want:  []time.Time{time.Date(2026, 2, 6, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 13, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 20, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			// a step over * does not restrict the day of month, only the odd Mondays match
+			expr:  "0 0 */2 * 1",
+			after: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			want:  []time.Time{time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 23, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			expr:  "0 0 30 2 *",
+			after: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:  nil,
+		},
+	}
+	for _, test := range tests {
+		s, err := schedule.ParseCron(test.expr)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", test.expr, err)
+		}
+		next := test.after
+		for _, want := range test.want {
+			if next = s.Next(next); !next.Equal(want) {
+				t.Errorf("%q: expected %s but got %s", test.expr, want, next)
+			}
+		}
+		if test.want == nil && !s.Next(next).IsZero() {
+			t.Errorf("%q: expected no occurrence", test.expr)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := schedule.ParseCron(expr); err == nil {
+			t.Errorf("%q should be rejected", expr)
+		}
+	}
+}
+
+func Test_ParseRRule(t *testing.T) {
+
+	start := time.Date(2026, 1, 31, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		rule string
+		want []string
+	}{
+		{"FREQ=DAILY;INTERVAL=10;COUNT=3", []string{"2026-01-31", "2026-02-10", "2026-02-20"}},
+		{"FREQ=WEEKLY;BYDAY=MO,FR;UNTIL=20260209", []string{"2026-02-02", "2026-02-06", "2026-02-09"}},
+		// months without the 31st are skipped
+		{"RRULE:FREQ=MONTHLY;COUNT=3", []string{"2026-01-31", "2026-03-31", "2026-05-31"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", []string{"2026-01-31", "2026-02-28", "2026-03-31"}},
+		// the last Friday of January is before the start
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=2", []string{"2026-02-27", "2026-03-27"}},
+		{"FREQ=YEARLY;BYMONTH=6,12;BYMONTHDAY=15;COUNT=3", []string{"2026-06-15", "2026-12-15", "2027-06-15"}},
+	}
+	for _, test := range tests {
+		r, err := schedule.ParseRRule(test.rule, start)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", test.rule, err)
+		}
+		var got []string
+		for next := r.Next(start.AddDate(0, -1, 0)); !next.IsZero(); next = r.Next(next) {
+			if next.Hour() != 8 {
+				t.Errorf("%q: occurrence %s should keep the time of the start", test.rule, next)
+			}
+			got = append(got, next.Format(time.DateOnly))
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q: expected %v but got %v", test.rule, test.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: expected %v but got %v", test.rule, test.want, got)
+				break
+			}
+		}
+	}
+
+	// resuming from the period of the given time finds the same occurrences as counting them from the start
+	for _, rule := range []string{"FREQ=DAILY;INTERVAL=3", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU", "FREQ=MONTHLY;BYDAY=-1FR", "FREQ=MONTHLY;INTERVAL=5", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29"} {
+		resumed, _ := schedule.ParseRRule(rule, start)
+		counted, _ := schedule.ParseRRule(rule+";COUNT=100000", start)
+		for after := start.Add(-time.Hour); after.Before(start.AddDate(10, 0, 0)); after = after.Add(37 * time.Hour) {
+			if got, want := resumed.Next(after), counted.Next(after); !got.Equal(want) {
+				t.Errorf("%q: expected %s after %s but got %s", rule, want, after, got)
+			}
+		}
+	}
+
+	// iterating a counted rule resumes from the previous occurrence instead of counting again from the start
+	counted, _ := schedule.ParseRRule("FREQ=DAILY;COUNT=100000", start)
+	n, last := 0, time.Time{}
+	for next := counted.Next(start.Add(-time.Hour)); !next.IsZero(); next = counted.Next(next) {
+		n, last = n+1, next
+	}
+	if n != 100000 || !last.Equal(start.AddDate(0, 0, 99999)) {
+		t.Errorf("expected 100000 occurrences until %s but got %d until %s", start.AddDate(0, 0, 99999), n, last)
+	}
+	if first := counted.Next(start.Add(-time.Hour)); !first.Equal(start) {
+		t.Errorf("expected %s to be the first occurrence again but got %s", start, first)
+	}
+
+	for _, rule := range []string{"FREQ=HOURLY", "FREQ=DAILY;INTERVAL=0", "FREQ=WEEKLY;BYDAY=1MO", "FREQ=DAILY;BYSETPOS=1", "FREQ=MONTHLY;BYDAY=XX"} {
+		if _, err := schedule.ParseRRule(rule, start); err == nil {
+			t.Errorf("%q should be rejected", rule)
+		}
+	}
+}
+
+func Test_Scheduler(t *testing.T) {
+
+	l := ledger.New(ledger.NewMemoryStorage())
+	bank := &ledger.Account{Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	rent := &ledger.Account{Name: "Rent", AccountType: ledger.AccountTypeExpense}
+	l.CreateAccount(bank)
+	l.CreateAccount(rent)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	monthly, _ := schedule.ParseCron("0 0 1 * *")
+	recurring := &schedule.Recurring{
+		Name:     "Office rent",
+		Entries:  []ledger.Entry{{Account: rent.ID, Amount: 1000}, {Account: bank.ID, Amount: -1000}},
+		Metadata: map[string]string{"vendor": "landlord"},
+		Schedule: monthly,
+		Start:    start,
+		End:      time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	s := schedule.New(l)
+	if err := s.Add(recurring); err != nil {
+		t.Fatalf("unexpected error adding: %v", err)
+	}
+	unbalanced := &schedule.Recurring{Name: "Broken", Entries: []ledger.Entry{{Account: rent.ID, Amount: 1}, {Account: bank.ID, Amount: 1}}, Schedule: monthly, Start: start}
+	if err := s.Add(unbalanced); err == nil {
+		t.Error("unbalanced recurring transaction should be rejected")
+	}
+
+	// the preview covers the whole range and posts nothing
+	preview := s.Preview(start, time.Date(2027, 6, 1, 0, 0, 0, 0, time.UTC))
+	if len(preview) != 12 || !preview[0].Timestamp.Equal(start) || preview[0].Metadata["vendor"] != "landlord" {
+		t.Fatalf("preview should have 12 occurrences but got %d", len(preview))
+	}
+	if b, _ := l.Balance(rent.ID); b.Balance != 0 {
+		t.Errorf("preview should not post but rent balance is %d", b.Balance)
+	}
+
+	// the due occurrences are posted with the same ids as in the preview
+	posted, err := s.Run(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(posted) != 3 {
+		t.Fatalf("3 occurrences should be posted but got %d (%v)", len(posted), err)
+	}
+	if posted[2].Id != preview[2].Id || posted[2].TransactionType != ledger.TransactionTypeRegular {
+		t.Errorf("posted occurrence should match the preview %+v", posted[2])
+	}
+	if posted, _ := s.Run(time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)); len(posted) != 0 {
+		t.Errorf("no occurrence should be due but got %d", len(posted))
+	}
+
+	// a new scheduler, as after a restart, does not post the same occurrences again
+	s = schedule.New(l)
+	s.Add(recurring)
+	if posted, err := s.Run(time.Date(2026, 4, 2, 0, 0, 0, 0, time.UTC)); err != nil || len(posted) != 1 {
+		t.Errorf("only the April occurrence should be posted but got %d (%v)", len(posted), err)
+	}
+	if b, _ := l.Balance(rent.ID); b.Balance != 4000 {
+		t.Errorf("rent balance should be 4000 but got %d", b.Balance)
+	}
+
+	// nothing is posted after the end
+	if posted, _ := s.Run(time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC)); len(posted) != 8 {
+		t.Errorf("8 occurrences should be posted until the end but got %d", len(posted))
+	}
+}
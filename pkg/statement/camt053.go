@@ -0,0 +1,308 @@
+package statement
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseCAMT053 parses an ISO 20022 camt.053 (BankToCustomerStatement) document.
+//
+// A document can hold several statements, one is returned for each of them.
+//   - Only booked entries (status BOOK) are read, pending and informational entries are ignored.
+//   - An entry that batches several transaction details with their own amounts becomes one line per detail.
+//   - The opening balance is read from OPBD (or PRCD) and the closing balance from CLBD.
+//
+// Element names are matched regardless of the namespace, so every camt.053 version is accepted.
+func ParseCAMT053(r io.Reader) ([]*Statement, error) {
+	var doc camtDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("camt.053: %w", err)
+	}
+	if len(doc.Statements) == 0 {
+		return nil, fmt.Errorf("camt.053: no statements found")
+	}
+
+	var statements []*Statement
+	for _, st := range doc.Statements {
+		s := &Statement{
+			BankAccount: st.Account.ID(),
+			Currency:    st.Account.Currency,
+		}
+
+		for _, b := range st.Balances {
+			balance, err := b.balance()
+			if err != nil {
+				return nil, err
+			}
+			switch b.Code {
+			case "OPBD":
+				s.OpeningBalance = balance
+			case "PRCD":
+				if s.OpeningBalance == nil {
+					s.OpeningBalance = balance
+				}
+			case "CLBD":
+				s.ClosingBalance = balance
+			}
+		}
+
+		ids := newFITIDGenerator("camt")
+		for _, e := range st.Entries {
+			if e.Status.code() != "BOOK" {
+				continue
+			}
+			lines, err := e.lines()
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range lines {
+				if l.FITID == "" {
+					l.FITID = ids.next(l)
+				}
+				if s.Currency == "" {
+					s.Currency = e.Amount.Currency
+				}
+				s.Lines = append(s.Lines, l)
+			}
+		}
+
+		statements = append(statements, s)
+	}
+	return statements, nil
+}
+
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	Account  camtAccount   `xml:"Acct"`
+	Balances []camtBalance `xml:"Bal"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtAccount struct {
+	IBAN     string `xml:"Id>IBAN"`
+	Other    string `xml:"Id>Othr>Id"`
+	Currency string `xml:"Ccy"`
+}
+
+// ID returns the IBAN of the account or its proprietary identification.
+func (a camtAccount) ID() string {
+	if a.IBAN != "" {
+		return a.IBAN
+	}
+	return a.Other
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+// camtLocalDateTime is the layout of a date time without a zone, which the ISO 20022 schemas also allow.
+const camtLocalDateTime = "2006-01-02T15:04:05.999999999"
+
+// time returns the date, the zero time if none is informed.
+// A date time without a zone is taken as UTC.
+func (d camtDate) time() (time.Time, error) {
+	switch {
+	case d.DateTime != "":
+		t, err := time.Parse(time.RFC3339, d.DateTime)
+		if err != nil {
+			if local, err2 := time.Parse(camtLocalDateTime, d.DateTime); err2 == nil {
+				return local, nil
+			}
+		}
+		return t, err
+	case d.Date != "":
+		return time.Parse(time.DateOnly, d.Date)
+	}
+	return time.Time{}, nil
+}
+
+type camtBalance struct {
+	Code      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	Indicator string     `xml:"CdtDbtInd"`
+	Date      camtDate   `xml:"Dt"`
+}
+
+func (b camtBalance) balance() (*Balance, error) {
+	amount, err := camtSigned(b.Amount, b.Indicator)
+	if err != nil {
+		return nil, err
+	}
+	date, err := b.Date.time()
+	if err != nil {
+		return nil, fmt.Errorf("camt.053: balance %s: %w", b.Code, err)
+	}
+	return &Balance{Amount: amount, Date: date}, nil
+}
+
+// camtStatus is the entry status, it is a plain text up to version 8 and a code from version 9 on.
+type camtStatus struct {
+	Text string `xml:",chardata"`
+	Code string `xml:"Cd"`
+}
+
+func (s camtStatus) code() string {
+	if s.Code != "" {
+		return s.Code
+	}
+	return strings.TrimSpace(s.Text)
+}
+
+type camtEntry struct {
+	Reference     string            `xml:"NtryRef"`
+	Amount        camtAmount        `xml:"Amt"`
+	Indicator     string            `xml:"CdtDbtInd"`
+	Status        camtStatus        `xml:"Sts"`
+	BookingDate   camtDate          `xml:"BookgDt"`
+	ServicerRef   string            `xml:"AcctSvcrRef"`
+	Details       []camtTransaction `xml:"NtryDtls>TxDtls"`
+	AdditionalInf string            `xml:"AddtlNtryInf"`
+}
+
+type camtTransaction struct {
+	ServicerRef  string      `xml:"Refs>AcctSvcrRef"`
+	EndToEndID   string      `xml:"Refs>EndToEndId"`
+	Amount       *camtAmount `xml:"Amt"`
+	Indicator    string      `xml:"CdtDbtInd"`
+	Unstructured []string    `xml:"RmtInf>Ustrd"`
+	CreditorRef  string      `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	Creditor     camtParty   `xml:"RltdPties>Cdtr"`
+	Debtor       camtParty   `xml:"RltdPties>Dbtr"`
+}
+
+// camtParty holds the party name, directly under the party up to version 7 and under Pty from version 8 on.
+type camtParty struct {
+	Name      string `xml:"Nm"`
+	PartyName string `xml:"Pty>Nm"`
+}
+
+func (p camtParty) name() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.PartyName
+}
+
+// lines returns the statement lines of the entry.
+func (e camtEntry) lines() ([]Line, error) {
+	amount, err := camtSigned(e.Amount, e.Indicator)
+	if err != nil {
+		return nil, err
+	}
+	date, err := e.BookingDate.time()
+	if err != nil {
+		return nil, fmt.Errorf("camt.053: entry %s: %w", e.Reference, err)
+	}
+
+	base := Line{
+		FITID:         e.ServicerRef,
+		Date:          date,
+		Amount:        amount,
+		Memo:          e.AdditionalInf,
+		BankReference: e.ServicerRef,
+	}
+	if base.FITID == "" {
+		base.FITID = e.Reference
+	}
+
+	// without amounts the details cannot be split, the line takes the first one and the remittance of all of them
+	withAmount := 0
+	for _, d := range e.Details {
+		if d.Amount != nil {
+			withAmount++
+		}
+	}
+	if len(e.Details) <= 1 || withAmount == 0 {
+		if len(e.Details) == 0 {
+			return []Line{base}, nil
+		}
+		e.Details[0].fill(&base)
+		remittances := []string{base.Remittance}
+		for _, d := range e.Details[1:] {
+			var l Line
+			d.fill(&l)
+			remittances = append(remittances, l.Remittance)
+		}
+		base.Remittance = strings.Join(slices.DeleteFunc(remittances, func(r string) bool { return r == "" }), "; ")
+		return []Line{base}, nil
+	}
+
+	// a split entry must be split in full, the details cannot import more or less than was booked
+	if withAmount < len(e.Details) {
+		return nil, fmt.Errorf("camt.053: entry %s: %d of its %d details have no amount", e.Reference, len(e.Details)-withAmount, len(e.Details))
+	}
+	lines := make([]Line, 0, len(e.Details))
+	sum := 0
+	for i, d := range e.Details {
+		l := base
+		indicator := d.Indicator
+		if indicator == "" {
+			indicator = e.Indicator
+		}
+		if l.Amount, err = camtSigned(*d.Amount, indicator); err != nil {
+			return nil, err
+		}
+		sum += l.Amount
+		if l.FITID != "" {
+			l.FITID += "/" + strconv.Itoa(i+1)
+		}
+		d.fill(&l)
+		lines = append(lines, l)
+	}
+	if sum != amount {
+		return nil, fmt.Errorf("camt.053: entry %s: its details add up to %d instead of %d", e.Reference, sum, amount)
+	}
+	return lines, nil
+}
+
+// fill copies the transaction details into the line.
+func (d camtTransaction) fill(l *Line) {
+	if d.ServicerRef != "" {
+		l.BankReference = d.ServicerRef
+	}
+	if d.EndToEndID != "NOTPROVIDED" {
+		l.EndToEndID = d.EndToEndID
+	}
+
+	l.Remittance = strings.Join(d.Unstructured, " ")
+	if l.Remittance == "" {
+		l.Remittance = d.CreditorRef
+	}
+
+	// the counterparty is the debtor of money coming in and the creditor of money going out
+	if l.Amount >= 0 {
+		l.Payee = d.Debtor.name()
+	} else {
+		l.Payee = d.Creditor.name()
+	}
+}
+
+// camtSigned returns the amount with the sign given by the credit/debit indicator.
+func camtSigned(a camtAmount, indicator string) (int, error) {
+	amount, err := parseAmount(a.Value, '.')
+	if err != nil {
+		return 0, fmt.Errorf("camt.053: %w", err)
+	}
+	switch indicator {
+	case "CRDT":
+		return amount, nil
+	case "DBIT":
+		return -amount, nil
+	}
+	return 0, fmt.Errorf("camt.053: invalid credit/debit indicator %q", indicator)
+}
@@ -0,0 +1,149 @@
+package statement
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// CSVLayout describes how a bank lays out its CSV statements.
+//
+// Columns are referenced by the name they have in the header row.
+//   - Amount is used when the bank informs a single signed amount column.
+//   - Debit and Credit are used when the bank informs money out and money in in separate columns.
+//   - When FITID is empty an identifier is derived from the line contents, so re-importing the same file is still detected.
+type CSVLayout struct {
+	Comma        rune   // The field delimiter, ',' if zero.
+	Skip         int    // Number of lines before the header row.
+	DateLayout   string // The time.Parse layout of the date column.
+	DecimalComma bool   // Amounts use ',' as decimal separator.
+	Negate       bool   // Amounts are informed from the bank perspective and must have the sign inverted.
+
+	Date   string
+	Amount string
+	Debit  string
+	Credit string
+	Payee  string
+	Memo   string
+	FITID  string
+}
+
+// ParseCSV parses a CSV bank statement with the given layout.
+func ParseCSV(r io.Reader, layout CSVLayout) (*Statement, error) {
+	if layout.Date == "" || layout.DateLayout == "" {
+		return nil, errors.New("csv: layout must define the date column and layout")
+	}
+	if layout.Amount == "" && layout.Debit == "" && layout.Credit == "" {
+		return nil, errors.New("csv: layout must define an amount column or debit and credit columns")
+	}
+
+	cr := csv.NewReader(r)
+	if layout.Comma != 0 {
+		cr.Comma = layout.Comma
+	}
+	cr.FieldsPerRecord = -1
+
+	for i := 0; i < layout.Skip; i++ {
+		if _, err := cr.Read(); err != nil {
+			return nil, fmt.Errorf("csv: skipping line %d: %w", i+1, err)
+		}
+	}
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv: reading header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{layout.Date, layout.Amount, layout.Debit, layout.Credit, layout.Payee, layout.Memo, layout.FITID} {
+		if _, ok := columns[name]; name != "" && !ok {
+			return nil, fmt.Errorf("csv: column %q not found in header", name)
+		}
+	}
+
+	decimal := byte('.')
+	if layout.DecimalComma {
+		decimal = ','
+	}
+
+	s := &Statement{}
+	ids := newFITIDGenerator("csv")
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: %w", err)
+		}
+		row, _ := cr.FieldPos(0)
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if name == "" || !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		var l Line
+		if l.Date, err = time.Parse(layout.DateLayout, field(layout.Date)); err != nil {
+			return nil, fmt.Errorf("csv: line %d: %w", row, err)
+		}
+		if l.Amount, err = csvAmount(field(layout.Amount), field(layout.Debit), field(layout.Credit), decimal); err != nil {
+			return nil, fmt.Errorf("csv: line %d: %w", row, err)
+		}
+		if layout.Negate {
+			l.Amount = -l.Amount
+		}
+		l.Payee = field(layout.Payee)
+		l.Memo = field(layout.Memo)
+		l.FITID = field(layout.FITID)
+
+		if l.FITID == "" {
+			l.FITID = ids.next(l)
+		}
+
+		s.Lines = append(s.Lines, l)
+	}
+	return s, nil
+}
+
+// csvAmount returns the signed amount of a line from either the amount column or the debit and credit columns.
+func csvAmount(amount, debit, credit string, decimal byte) (int, error) {
+	if amount != "" {
+		return parseAmount(amount, decimal)
+	}
+
+	var total int
+	if debit != "" {
+		v, err := parseAmount(debit, decimal)
+		if err != nil {
+			return 0, err
+		}
+		total -= abs(v)
+	}
+	if credit != "" {
+		v, err := parseAmount(credit, decimal)
+		if err != nil {
+			return 0, err
+		}
+		total += abs(v)
+	}
+	if debit == "" && credit == "" {
+		return 0, errors.New("line has no amount")
+	}
+	return total, nil
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
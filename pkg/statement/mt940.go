@@ -0,0 +1,257 @@
+package statement
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ParseMT940 parses a SWIFT MT940 customer statement file.
+//
+// A file can hold several statements (each one starting with a :20: field), one is returned for each of them.
+//   - Every :61: statement line is booked, MT940 does not carry pending items.
+//   - The opening balance is read from :60F: (or the first :60M:) and the closing balance from the last :62F: or :62M:.
+//   - The :86: information is read in the structured German (?20 EREF+...) or slash coded (/EREF/...) forms,
+//     falling back to use the whole text as remittance information.
+func ParseMT940(r io.Reader) ([]*Statement, error) {
+	fields, err := mt940Fields(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []*Statement
+	var s *Statement
+	var ids *fitidGenerator
+	var line *Line
+	flush := func() {
+		if line != nil {
+			if line.FITID == "" {
+				line.FITID = ids.next(*line)
+			}
+			s.Lines = append(s.Lines, *line)
+			line = nil
+		}
+	}
+
+	for _, f := range fields {
+		if f.tag == "20" {
+			if s != nil {
+				flush()
+			}
+			s = &Statement{}
+			ids = newFITIDGenerator("mt940")
+			statements = append(statements, s)
+			continue
+		}
+		if s == nil {
+			return nil, fmt.Errorf("mt940: field :%s: before the :20: field", f.tag)
+		}
+
+		switch f.tag {
+		case "25":
+			s.BankAccount = strings.TrimSpace(f.value)
+		case "60F", "60M":
+			if s.OpeningBalance != nil {
+				continue
+			}
+			if s.OpeningBalance, s.Currency, err = mt940Balance(f.value); err != nil {
+				return nil, err
+			}
+		case "62F", "62M":
+			if s.ClosingBalance, s.Currency, err = mt940Balance(f.value); err != nil {
+				return nil, err
+			}
+		case "61":
+			flush()
+			l, err := mt940Line(f.value)
+			if err != nil {
+				return nil, err
+			}
+			line = &l
+		case "86":
+			if line != nil {
+				mt940Information(line, f.value)
+				flush()
+			}
+		}
+	}
+	if s != nil {
+		flush()
+	}
+
+	if len(statements) == 0 {
+		return nil, errors.New("mt940: no statements found")
+	}
+	return statements, nil
+}
+
+type mt940Field struct {
+	tag   string
+	value string // Continuation lines are kept, separated by '\n'.
+}
+
+var mt940Tag = regexp.MustCompile(`^:([0-9]{2}[A-Z]?):(.*)$`)
+
+// mt940Fields splits the file in tagged fields.
+// The SWIFT block headers ({1:...}{4:) and trailers (-}) are ignored.
+func mt940Fields(r io.Reader) ([]mt940Field, error) {
+	var fields []mt940Field
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r ")
+		if m := mt940Tag.FindStringSubmatch(text); m != nil {
+			fields = append(fields, mt940Field{tag: m[1], value: m[2]})
+			continue
+		}
+		if text == "" || text == "-" || text == "-}" || strings.HasPrefix(text, "{") {
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		fields[len(fields)-1].value += "\n" + text
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("mt940: %w", err)
+	}
+	return fields, nil
+}
+
+var mt940BalanceField = regexp.MustCompile(`^([CD])([0-9]{6})([A-Z]{3})([0-9,]+)$`)
+
+// mt940Balance parses a balance field: D/C mark, YYMMDD date, currency and amount.
+func mt940Balance(value string) (*Balance, string, error) {
+	m := mt940BalanceField.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return nil, "", fmt.Errorf("mt940: invalid balance %q", value)
+	}
+	date, err := time.Parse("060102", m[2])
+	if err != nil {
+		return nil, "", fmt.Errorf("mt940: invalid balance date %q", value)
+	}
+	amount, err := parseAmount(m[4], ',')
+	if err != nil {
+		return nil, "", fmt.Errorf("mt940: %w", err)
+	}
+	if m[1] == "D" {
+		amount = -amount
+	}
+	return &Balance{Amount: amount, Date: date}, m[3], nil
+}
+
+// mt940StatementLine matches the :61: field: value date, optional entry date, D/C mark (or reversal RD/RC),
+// optional funds code, amount, transaction type, customer reference and optional bank reference.
+var mt940StatementLine = regexp.MustCompile(`^([0-9]{6})([0-9]{4})?(RD|RC|D|C)([A-Z])?([0-9,]+)([NSF][A-Z0-9]{3})([^/\n]{0,16})(?://([^\n]{0,16}))?(?:\n(.*))?`)
+
+// mt940Line parses a :61: statement line.
+func mt940Line(value string) (Line, error) {
+	m := mt940StatementLine.FindStringSubmatch(value)
+	if m == nil {
+		return Line{}, fmt.Errorf("mt940: invalid statement line %q", value)
+	}
+
+	date, err := time.Parse("060102", m[1])
+	if err != nil {
+		return Line{}, fmt.Errorf("mt940: invalid statement line date %q", value)
+	}
+	amount, err := parseAmount(m[5], ',')
+	if err != nil {
+		return Line{}, fmt.Errorf("mt940: %w", err)
+	}
+	// a reversal of a credit takes money out, a reversal of a debit brings it back
+	if m[3] == "D" || m[3] == "RC" {
+		amount = -amount
+	}
+
+	l := Line{
+		Date:   date,
+		Amount: amount,
+		Memo:   strings.TrimSpace(m[9]),
+	}
+	if ref := strings.TrimSpace(m[8]); ref != "" && ref != "NONREF" {
+		l.FITID = ref
+		l.BankReference = ref
+	}
+	return l, nil
+}
+
+var (
+	mt940Subfield  = regexp.MustCompile(`\?([0-9]{2})`)
+	mt940SlashCode = regexp.MustCompile(`/([A-Z]{3,4})/`)
+)
+
+// mt940Information reads the :86: information to account owner into the line.
+//
+// The continuation lines are joined directly in the structured form, where the subfields are cut at a fixed width,
+// and with a space otherwise, where they are cut between words.
+func mt940Information(l *Line, value string) {
+	if mt940Subfield.MatchString(value) {
+		value = strings.ReplaceAll(value, "\n", "")
+	} else {
+		value = strings.ReplaceAll(value, "\n", " ")
+	}
+
+	switch {
+	case mt940Subfield.MatchString(value):
+		// German structured form: ?20-?29 and ?60-?63 hold the purpose, ?32-?33 the counterparty name.
+		var purpose, name []string
+		parts := mt940Subfield.Split(value, -1)
+		codes := mt940Subfield.FindAllStringSubmatch(value, -1)
+		for i, c := range codes {
+			text := parts[i+1]
+			switch {
+			case c[1] >= "20" && c[1] <= "29", c[1] >= "60" && c[1] <= "63":
+				purpose = append(purpose, text)
+			case c[1] == "32" || c[1] == "33":
+				name = append(name, text)
+			}
+		}
+		l.Payee = strings.Join(name, "")
+
+		// the purpose is split in 27 characters chunks, SEPA qualifiers such as EREF+ and SVWZ+ mark the fields
+		text := strings.Join(purpose, "")
+		remittance := text
+		if i := strings.Index(text, "SVWZ+"); i >= 0 {
+			remittance = text[i+len("SVWZ+"):]
+		}
+		if i := strings.Index(text, "EREF+"); i >= 0 {
+			ref := text[i+len("EREF+"):]
+			if j := sepaQualifier.FindStringIndex(ref); j != nil {
+				ref = ref[:j[0]]
+			}
+			if ref = strings.TrimSpace(ref); ref != "NOTPROVIDED" {
+				l.EndToEndID = ref
+			}
+		}
+		if j := sepaQualifier.FindStringIndex(remittance); j != nil {
+			remittance = remittance[:j[0]]
+		}
+		l.Remittance = strings.TrimSpace(remittance)
+
+	case mt940SlashCode.MatchString(value):
+		parts := mt940SlashCode.Split(value, -1)
+		codes := mt940SlashCode.FindAllStringSubmatch(value, -1)
+		for i, c := range codes {
+			text := strings.Trim(parts[i+1], "/ ")
+			switch c[1] {
+			case "EREF":
+				if text != "NOTPROVIDED" {
+					l.EndToEndID = text
+				}
+			case "REMI":
+				l.Remittance = strings.TrimPrefix(text, "USTD//")
+			case "NAME":
+				l.Payee = text
+			}
+		}
+
+	default:
+		l.Remittance = strings.TrimSpace(value)
+	}
+}
+
+var sepaQualifier = regexp.MustCompile(`[A-Z]{4}\+`)
@@ -0,0 +1,194 @@
+package statement
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseOFX parses an OFX or QFX bank statement.
+//
+// Both the SGML (OFX 1.x) and the XML (OFX 2.x) flavours are supported.
+//   - The SGML flavour does not close the elements that hold values, so the parser does not rely on closing tags
+//     except for the aggregates it cares about (STMTTRN).
+//   - Only the first statement of the file is read.
+//   - The ledger balance (LEDGERBAL) is read as the closing balance, OFX does not inform an opening balance.
+func ParseOFX(r io.Reader) (*Statement, error) {
+	tokens, err := tokenizeOFX(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Statement{}
+	var line *Line
+	var ledgerBal *Balance
+tokens:
+	for _, tok := range tokens {
+		if tok.closing {
+			switch {
+			case tok.name == "STMTRS":
+				break tokens
+			case tok.name == "STMTTRN" && line != nil:
+				s.Lines = append(s.Lines, *line)
+				line = nil
+			case tok.name == "LEDGERBAL" && ledgerBal != nil:
+				s.ClosingBalance = ledgerBal
+				ledgerBal = nil
+			}
+			continue
+		}
+
+		switch tok.name {
+		case "STMTTRN":
+			line = &Line{}
+			continue
+		case "LEDGERBAL":
+			ledgerBal = &Balance{}
+			continue
+		case "BALAMT":
+			if ledgerBal != nil {
+				if ledgerBal.Amount, err = parseAmount(tok.value, '.'); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case "DTASOF":
+			if ledgerBal != nil {
+				if ledgerBal.Date, err = parseOFXDate(tok.value); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case "CURDEF":
+			if s.Currency == "" {
+				s.Currency = tok.value
+			}
+			continue
+		case "ACCTID":
+			if s.BankAccount == "" {
+				s.BankAccount = tok.value
+			}
+			continue
+		}
+
+		if line == nil {
+			continue
+		}
+		switch tok.name {
+		case "FITID":
+			line.FITID = tok.value
+		case "DTPOSTED":
+			if line.Date, err = parseOFXDate(tok.value); err != nil {
+				return nil, err
+			}
+		case "TRNAMT":
+			if line.Amount, err = parseAmount(tok.value, '.'); err != nil {
+				return nil, err
+			}
+		case "NAME", "PAYEE":
+			if line.Payee == "" {
+				line.Payee = tok.value
+			}
+		case "MEMO":
+			line.Memo = tok.value
+		}
+	}
+
+	if line != nil {
+		return nil, errors.New("ofx: unterminated STMTTRN")
+	}
+	return s, nil
+}
+
+// ofxToken is an element found in an OFX document.
+type ofxToken struct {
+	name    string
+	value   string // The text that follows the opening tag, empty for aggregates.
+	closing bool
+}
+
+var ofxEntities = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'")
+
+// tokenizeOFX splits the document in tags and their values.
+// Headers, processing instructions and declarations are skipped.
+func tokenizeOFX(r *bufio.Reader) ([]ofxToken, error) {
+	// skip the OFX 1.x header, everything until the first tag
+	if _, err := r.ReadString('<'); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("ofx: no elements found")
+		}
+		return nil, err
+	}
+
+	var tokens []ofxToken
+	for {
+		tag, err := r.ReadString('>')
+		if err != nil {
+			return nil, errors.New("ofx: unterminated tag")
+		}
+		tag = strings.TrimSpace(strings.TrimSuffix(tag, ">"))
+
+		text, err := r.ReadString('<')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		last := err == io.EOF
+		text = strings.TrimSpace(strings.TrimSuffix(text, "<"))
+
+		switch {
+		case tag == "" || tag[0] == '?' || tag[0] == '!':
+		case tag[0] == '/':
+			tokens = append(tokens, ofxToken{name: strings.ToUpper(tag[1:]), closing: true})
+		default:
+			tokens = append(tokens, ofxToken{name: strings.ToUpper(tag), value: ofxEntities.Replace(text)})
+		}
+
+		if last {
+			return tokens, nil
+		}
+	}
+}
+
+// parseOFXDate parses dates in the OFX format YYYYMMDD[HHMMSS[.XXX]][[offset:TZ]].
+// Dates without an offset are assumed to be in UTC.
+func parseOFXDate(s string) (time.Time, error) {
+	value, tz, _ := strings.Cut(s, "[")
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+
+	var layout string
+	switch len(value) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("ofx: invalid date %q", s)
+	}
+
+	loc := time.UTC
+	if tz != "" {
+		offset, name, _ := strings.Cut(strings.TrimSuffix(tz, "]"), ":")
+		hours, err := strconv.ParseFloat(offset, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("ofx: invalid date offset %q", s)
+		}
+		if name == "" {
+			name = offset
+		}
+		loc = time.FixedZone(name, int(hours*3600))
+	}
+
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ofx: invalid date %q", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,59 @@
+package statement
+
+import (
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// AmountRange represents an inclusive range of signed amounts.
+type AmountRange struct {
+	Min int
+	Max int
+}
+
+// Contains returns true if the amount is inside the range.
+func (r AmountRange) Contains(amount int) bool {
+	return amount >= r.Min && amount <= r.Max
+}
+
+// Rule picks the counter-account of a statement line.
+//
+//   - A nil Payee matches any payee.
+//   - A nil Amount matches any amount.
+//   - A rule with both nil matches every line, it is useful as the last rule of a list.
+type Rule struct {
+	Payee   *regexp.Regexp // Matched against the payee and, if the payee is empty, against the memo.
+	Amount  *AmountRange
+	Account uuid.UUID // The counter-account used when the rule matches.
+}
+
+// Matches returns true if the line satisfies the rule.
+func (r Rule) Matches(l Line) bool {
+	if r.Payee != nil {
+		text := l.Payee
+		if text == "" {
+			text = l.Memo
+		}
+		if !r.Payee.MatchString(text) {
+			return false
+		}
+	}
+	if r.Amount != nil && !r.Amount.Contains(l.Amount) {
+		return false
+	}
+	return true
+}
+
+// Rules is an ordered list of rules, the first matching rule wins.
+type Rules []Rule
+
+// Match returns the counter-account of the first rule that matches the line.
+func (rs Rules) Match(l Line) (uuid.UUID, bool) {
+	for _, r := range rs {
+		if r.Matches(l) {
+			return r.Account, true
+		}
+	}
+	return uuid.Nil, false
+}
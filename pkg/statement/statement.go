@@ -0,0 +1,278 @@
+// statement package imports bank statements into the ledger.
+// It parses the files delivered by banks and turns their lines into draft transactions
+// that can be reviewed before being posted.
+package statement
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// Metadata keys written by the importer in the draft transactions.
+const (
+	MetadataFITID = "fitid" // The bank's unique identifier of the statement line.
+	MetadataPayee = "payee" // The payee or counterparty name as informed by the bank.
+	MetadataMemo  = "memo"  // The free-form memo of the statement line.
+
+	MetadataEndToEndID    = "end_to_end_id"   // The end-to-end identifier set by the payment initiator.
+	MetadataRemittance    = "remittance_info" // The unstructured remittance information.
+	MetadataBankReference = "bank_reference"  // The reference given to the line by the bank.
+)
+
+// Statement represents a bank statement for a single bank account.
+//
+// The opening and closing balances are only available when the bank informs them.
+type Statement struct {
+	BankAccount    string // The account number as informed by the bank.
+	Currency       string
+	OpeningBalance *Balance
+	ClosingBalance *Balance
+	Lines          []Line
+}
+
+// Balance represents a balance informed by the bank, it has the same sign convention as the lines.
+type Balance struct {
+	Amount int
+	Date   time.Time
+}
+
+// Difference returns the difference between the statement closing balance and the ledger balance.
+//
+//   - A zero difference means the ledger agrees with the bank.
+//   - The ledger balance should be taken at the closing balance date.
+//   - It returns an error if the statement has no closing balance or the account is not an asset account.
+func (s *Statement) Difference(b ledger.AccountBalance) (int, error) {
+	if s.ClosingBalance == nil {
+		return 0, errors.New("statement has no closing balance")
+	}
+	if b.AccountType != ledger.AccountTypeAsset {
+		return 0, errors.New("statements can only be reconciled against asset accounts")
+	}
+	return s.ClosingBalance.Amount - b.Balance, nil
+}
+
+// Line represents a single line of a bank statement.
+//
+// The amount is seen from the account holder perspective:
+//   - A positive amount is money coming into the bank account.
+//   - A negative amount is money leaving the bank account.
+type Line struct {
+	FITID  string // The bank's unique identifier of the line.
+	Date   time.Time
+	Amount int
+	Payee  string
+	Memo   string
+
+	EndToEndID    string
+	Remittance    string
+	BankReference string
+}
+
+// FITIDSet holds the bank identifiers already imported into the ledger.
+// It is used to detect duplicated lines when the same statement period is imported twice.
+type FITIDSet map[string]struct{}
+
+// NewFITIDSet creates a set with the identifiers stored in the metadata of the given transactions.
+func NewFITIDSet(transactions []*ledger.Transaction) FITIDSet {
+	s := make(FITIDSet)
+	for _, t := range transactions {
+		if id := t.Metadata[MetadataFITID]; id != "" {
+			s[id] = struct{}{}
+		}
+	}
+	return s
+}
+
+// Has returns true if the identifier is in the set.
+func (s FITIDSet) Has(id string) bool {
+	_, ok := s[id]
+	return ok
+}
+
+// Importer turns statement lines into draft transactions.
+//
+//   - Every draft has one entry against Account and one against the counter-account picked by the Rules.
+//   - Both entries are described by the payee, or the memo, and the bank entry has the FITID in its metadata.
+//   - Lines not matched by any rule go to Suspense, or are reported as unmatched if Suspense is not set.
+//   - Lines whose FITID is already in Seen are reported as duplicates and are not drafted.
+type Importer struct {
+	Account  ledger.Account // The Asset account the statement belongs to.
+	Journal  uuid.UUID
+	Rules    Rules
+	Suspense uuid.UUID
+	Seen     FITIDSet
+}
+
+// Result is the outcome of an import.
+type Result struct {
+	Transactions []*ledger.Transaction
+	Duplicates   []Line
+	Unmatched    []Line
+}
+
+// Import creates the draft transactions for the statement lines.
+// The drafts are not posted, it is up to the caller to review and post them.
+func (im *Importer) Import(s *Statement) (*Result, error) {
+	if im.Account.AccountType != ledger.AccountTypeAsset {
+		return nil, errors.New("statements can only be imported into asset accounts")
+	}
+	if im.Seen == nil {
+		im.Seen = make(FITIDSet)
+	}
+
+	r := &Result{}
+	for _, line := range s.Lines {
+		if line.FITID == "" {
+			return nil, fmt.Errorf("statement line of %s has no FITID", line.Date.Format(time.DateOnly))
+		}
+		if im.Seen.Has(line.FITID) {
+			r.Duplicates = append(r.Duplicates, line)
+			continue
+		}
+
+		counter, ok := im.Rules.Match(line)
+		if !ok {
+			counter = im.Suspense
+		}
+		if counter == uuid.Nil {
+			r.Unmatched = append(r.Unmatched, line)
+			continue
+		}
+
+		t := ledger.NewRegularTransaction(line.Date)
+		t.Journal = im.Journal
+		description := line.Payee
+		if description == "" {
+			description = line.Memo
+		}
+		t.AddEntries([]ledger.Entry{
+			{Account: im.Account.ID, Amount: line.Amount, Description: description, Metadata: map[string]string{MetadataFITID: line.FITID}},
+			{Account: counter, Amount: -line.Amount, Description: description},
+		})
+		t.Metadata = map[string]string{MetadataFITID: line.FITID}
+		for key, value := range map[string]string{
+			MetadataPayee:         line.Payee,
+			MetadataMemo:          line.Memo,
+			MetadataEndToEndID:    line.EndToEndID,
+			MetadataRemittance:    line.Remittance,
+			MetadataBankReference: line.BankReference,
+		} {
+			if value != "" {
+				t.Metadata[key] = value
+			}
+		}
+
+		im.Seen[line.FITID] = struct{}{}
+		r.Transactions = append(r.Transactions, t)
+	}
+	return r, nil
+}
+
+// fitidGenerator derives stable identifiers for the lines the bank did not identify.
+// Identical lines in the same file are told apart by their occurrence number.
+type fitidGenerator struct {
+	prefix      string
+	occurrences map[string]int
+}
+
+func newFITIDGenerator(prefix string) *fitidGenerator {
+	return &fitidGenerator{prefix: prefix, occurrences: make(map[string]int)}
+}
+
+// next returns the identifier of the line.
+func (g *fitidGenerator) next(l Line) string {
+	key := strings.Join([]string{l.Date.Format(time.DateOnly), strconv.Itoa(l.Amount), l.Payee, l.Memo, l.Remittance}, "\x00")
+	g.occurrences[key]++
+	sum := sha1.Sum([]byte(key + "\x00" + strconv.Itoa(g.occurrences[key])))
+	return g.prefix + "-" + hex.EncodeToString(sum[:])
+}
+
+// parseAmount parses a decimal amount into minor units (cents).
+//
+//   - decimal is the character used as decimal separator, the other one of '.' and ',' is the thousands separator.
+//     It may only split the units in groups of three digits, so "12,34" is rejected when decimal is '.'.
+//   - Amounts with more than two significant decimal places are rejected.
+//   - Amounts that do not fit in an int are rejected.
+func parseAmount(s string, decimal byte) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty amount")
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	var units, cents, places, digits int
+	group := -1 // The digits since the last thousands separator, -1 before the first one.
+	seenDecimal := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == decimal && !seenDecimal:
+			if group >= 0 && group != 3 {
+				return 0, fmt.Errorf("amount %q has a misplaced thousands separator", s)
+			}
+			seenDecimal = true
+		case (c == '.' || c == ',') && !seenDecimal:
+			if digits == 0 || group >= 0 && group != 3 || group < 0 && digits > 3 {
+				return 0, fmt.Errorf("amount %q has a misplaced thousands separator", s)
+			}
+			group = 0
+		case c >= '0' && c <= '9':
+			digits++
+			if !seenDecimal {
+				if units > (math.MaxInt-int(c-'0'))/10 {
+					return 0, fmt.Errorf("amount %q is too large", s)
+				}
+				units = units*10 + int(c-'0')
+				if group >= 0 {
+					group++
+				}
+				continue
+			}
+			places++
+			if places > 2 {
+				if c != '0' {
+					return 0, fmt.Errorf("amount %q has more than two decimal places", s)
+				}
+				continue
+			}
+			cents = cents*10 + int(c-'0')
+		default:
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+	if digits == 0 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if !seenDecimal && group >= 0 && group != 3 {
+		return 0, fmt.Errorf("amount %q has a misplaced thousands separator", s)
+	}
+	if places == 1 {
+		cents *= 10
+	}
+
+	if units > (math.MaxInt-cents)/100 {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+	amount := units*100 + cents
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
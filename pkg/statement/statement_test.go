@@ -0,0 +1,375 @@
+package statement_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/statement"
+)
+
+const sgmlOFX = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>USD
+<BANKACCTFROM><BANKID>121000248<ACCTID>0012345678<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20260101<DTEND>20260131
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20260105120000.000[-3:BRT]
+<TRNAMT>-42.50
+<FITID>T-1
+<NAME>COFFEE &amp; CO
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20260110
+<TRNAMT>1500.00
+<FITID>T-2
+<NAME>ACME PAYROLL
+<MEMO>January salary
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+func Test_ParseOFX(t *testing.T) {
+	s, err := statement.ParseOFX(strings.NewReader(sgmlOFX))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.BankAccount != "0012345678" || s.Currency != "USD" {
+		t.Errorf("unexpected statement header %q %q", s.BankAccount, s.Currency)
+	}
+	if len(s.Lines) != 2 {
+		t.Fatalf("statement should have 2 lines but got %d", len(s.Lines))
+	}
+
+	first := s.Lines[0]
+	if first.FITID != "T-1" || first.Amount != -4250 || first.Payee != "COFFEE & CO" {
+		t.Errorf("unexpected first line %+v", first)
+	}
+	if want := time.Date(2026, 1, 5, 15, 0, 0, 0, time.UTC); !first.Date.Equal(want) {
+		t.Errorf("first line date should be %s but got %s", want, first.Date)
+	}
+	if second := s.Lines[1]; second.Amount != 150000 || second.Memo != "January salary" {
+		t.Errorf("unexpected second line %+v", second)
+	}
+
+	// test the XML flavour, where every element is closed
+	xml := `<?xml version="1.0"?><?OFX OFXHEADER="200"?><OFX><STMTTRN><DTPOSTED>20260201</DTPOSTED>` +
+		`<TRNAMT>-1,250.5</TRNAMT><FITID>X-1</FITID><NAME>RENT</NAME></STMTTRN></OFX>`
+	s, err = statement.ParseOFX(strings.NewReader(xml))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Lines) != 1 || s.Lines[0].Amount != -125050 || s.Lines[0].Payee != "RENT" {
+		t.Errorf("unexpected xml lines %+v", s.Lines)
+	}
+
+	// test that invalid amounts are rejected
+	if _, err := statement.ParseOFX(strings.NewReader(`<OFX><STMTTRN><TRNAMT>1.234</STMTTRN></OFX>`)); err == nil {
+		t.Error("amount with three decimal places should be rejected")
+	}
+}
+
+func Test_ParseCSV(t *testing.T) {
+	data := "Bank export\n" +
+		"Date;Description;Out;In\n" +
+		"05/01/2026;Coffee;4,50;\n" +
+		"05/01/2026;Coffee;4,50;\n" +
+		"06/01/2026;Salary;;1.500,00\n"
+
+	layout := statement.CSVLayout{
+		Comma:        ';',
+		Skip:         1,
+		DateLayout:   "02/01/2006",
+		DecimalComma: true,
+		Date:         "Date",
+		Debit:        "Out",
+		Credit:       "In",
+		Payee:        "Description",
+	}
+	s, err := statement.ParseCSV(strings.NewReader(data), layout)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Lines) != 3 {
+		t.Fatalf("statement should have 3 lines but got %d", len(s.Lines))
+	}
+	if s.Lines[0].Amount != -450 || s.Lines[2].Amount != 150000 {
+		t.Errorf("unexpected amounts %d and %d", s.Lines[0].Amount, s.Lines[2].Amount)
+	}
+
+	// identical lines must still receive distinct and stable identifiers
+	if s.Lines[0].FITID == s.Lines[1].FITID {
+		t.Error("identical lines should have different FITIDs")
+	}
+	again, _ := statement.ParseCSV(strings.NewReader(data), layout)
+	if again.Lines[1].FITID != s.Lines[1].FITID {
+		t.Error("FITIDs should be stable across parses")
+	}
+
+	// thousands separators only split the units in groups of three, and amounts must fit in an int
+	for amount, valid := range map[string]bool{
+		"1.234.567,89":          true,
+		"12,34":                 true,
+		"-1.000":                true,
+		"12.34":                 false,
+		"1.2345,00":             false,
+		"1234.567,00":           false,
+		".123,00":               false,
+		"1.234,5.6":             false,
+		"92233720368547758,07":  true,
+		"92233720368547758,08":  false,
+		"922337203685477580,00": false,
+	} {
+		csv := "Bank export\nDate;Description;Out;In\n05/01/2026;Coffee;;" + amount + "\n"
+		if _, err := statement.ParseCSV(strings.NewReader(csv), layout); (err == nil) != valid {
+			t.Errorf("amount %q should be valid %v but got %v", amount, valid, err)
+		}
+	}
+
+	// test missing columns
+	layout.Memo = "Notes"
+	if _, err := statement.ParseCSV(strings.NewReader(data), layout); err == nil {
+		t.Error("unknown column should be rejected")
+	}
+}
+
+func Test_Import(t *testing.T) {
+	bank := ledger.Account{ID: uuid.New(), Name: "Bank", AccountType: ledger.AccountTypeAsset}
+	coffee, salary, suspense := uuid.New(), uuid.New(), uuid.New()
+
+	s, _ := statement.ParseOFX(strings.NewReader(sgmlOFX))
+	s.Lines = append(s.Lines, statement.Line{FITID: "T-3", Date: time.Now(), Amount: -999, Payee: "UNKNOWN"})
+
+	im := &statement.Importer{
+		Account: bank,
+		Rules: statement.Rules{
+			{Payee: regexp.MustCompile(`(?i)coffee`), Account: coffee},
+			{Amount: &statement.AmountRange{Min: 100000, Max: 1000000}, Account: salary},
+		},
+		Seen: statement.FITIDSet{"T-1": {}},
+	}
+
+	r, err := im.Import(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// T-1 was already imported and T-3 has no rule nor suspense account
+	if len(r.Duplicates) != 1 || r.Duplicates[0].FITID != "T-1" {
+		t.Errorf("unexpected duplicates %+v", r.Duplicates)
+	}
+	if len(r.Unmatched) != 1 || r.Unmatched[0].FITID != "T-3" {
+		t.Errorf("unexpected unmatched %+v", r.Unmatched)
+	}
+	if len(r.Transactions) != 1 {
+		t.Fatalf("should draft 1 transaction but got %d", len(r.Transactions))
+	}
+
+	tx := r.Transactions[0]
+	if ok, err := tx.IsBalanced(); !ok || err != nil {
+		t.Error("draft transaction should be balanced")
+	}
+	if tx.Entries[0].Account != bank.ID || tx.Entries[0].Amount != 150000 || tx.Entries[1].Account != salary {
+		t.Errorf("unexpected entries %+v", tx.Entries)
+	}
+	if tx.Metadata[statement.MetadataFITID] != "T-2" {
+		t.Errorf("draft should carry the FITID but got %q", tx.Metadata[statement.MetadataFITID])
+	}
+	if e := tx.Entries[0]; e.Description == "" || e.Description != tx.Entries[1].Description || e.Metadata[statement.MetadataFITID] != "T-2" {
+		t.Errorf("bank entry should be described and carry the FITID but got %+v", e)
+	}
+
+	// importing again with a suspense account drafts only the line left behind
+	im.Suspense = suspense
+	r, _ = im.Import(s)
+	if len(r.Transactions) != 1 || r.Transactions[0].Entries[1].Account != suspense {
+		t.Errorf("unmatched line should go to the suspense account")
+	}
+	if len(r.Duplicates) != 2 {
+		t.Errorf("should detect 2 duplicates but got %d", len(r.Duplicates))
+	}
+
+	// only asset accounts can receive statements
+	im.Account.AccountType = ledger.AccountTypeRevenue
+	if _, err := im.Import(s); err == nil {
+		t.Error("importing into a revenue account should fail")
+	}
+}
+
+const camt053 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.08">
+<BkToCstmrStmt><Stmt>
+  <Id>STMT-1</Id>
+  <Acct><Id><IBAN>DE89370400440532013000</IBAN></Id><Ccy>EUR</Ccy></Acct>
+  <Bal><Tp><CdOrPrtry><Cd>OPBD</Cd></CdOrPrtry></Tp><Amt Ccy="EUR">1000.00</Amt><CdtDbtInd>CRDT</CdtDbtInd><Dt><Dt>2026-01-01</Dt></Dt></Bal>
+  <Bal><Tp><CdOrPrtry><Cd>CLBD</Cd></CdOrPrtry></Tp><Amt Ccy="EUR">1450.00</Amt><CdtDbtInd>CRDT</CdtDbtInd><Dt><Dt>2026-01-31</Dt></Dt></Bal>
+  <Ntry>
+    <Amt Ccy="EUR">500.00</Amt><CdtDbtInd>CRDT</CdtDbtInd><Sts><Cd>BOOK</Cd></Sts>
+    <BookgDt><Dt>2026-01-10</Dt></BookgDt><AcctSvcrRef>REF-1</AcctSvcrRef>
+    <NtryDtls><TxDtls>
+      <Refs><EndToEndId>E2E-1</EndToEndId></Refs>
+      <RltdPties><Dbtr><Pty><Nm>ACME GmbH</Nm></Pty></Dbtr></RltdPties>
+      <RmtInf><Ustrd>Invoice 2026-001</Ustrd></RmtInf>
+    </TxDtls></NtryDtls>
+  </Ntry>
+  <Ntry>
+    <Amt Ccy="EUR">50.00</Amt><CdtDbtInd>DBIT</CdtDbtInd><Sts><Cd>BOOK</Cd></Sts>
+    <BookgDt><Dt>2026-01-12</Dt></BookgDt><AcctSvcrRef>REF-2</AcctSvcrRef>
+    <NtryDtls>
+      <TxDtls><Refs><EndToEndId>E2E-2</EndToEndId></Refs><Amt Ccy="EUR">20.00</Amt><CdtDbtInd>DBIT</CdtDbtInd></TxDtls>
+      <TxDtls><Refs><EndToEndId>E2E-3</EndToEndId></Refs><Amt Ccy="EUR">30.00</Amt><CdtDbtInd>DBIT</CdtDbtInd></TxDtls>
+    </NtryDtls>
+  </Ntry>
+  <Ntry>
+    <Amt Ccy="EUR">10.00</Amt><CdtDbtInd>CRDT</CdtDbtInd><Sts><Cd>BOOK</Cd></Sts>
+    <BookgDt><DtTm>2026-01-15T10:30:00</DtTm></BookgDt><AcctSvcrRef>REF-4</AcctSvcrRef>
+    <NtryDtls>
+      <TxDtls><RmtInf><Ustrd>Refund A</Ustrd></RmtInf></TxDtls>
+      <TxDtls><Refs><EndToEndId>E2E-5</EndToEndId></Refs></TxDtls>
+      <TxDtls><RmtInf><Strd><CdtrRefInf><Ref>RF18539007547034</Ref></CdtrRefInf></Strd></RmtInf></TxDtls>
+    </NtryDtls>
+  </Ntry>
+  <Ntry>
+    <Amt Ccy="EUR">99.00</Amt><CdtDbtInd>DBIT</CdtDbtInd><Sts><Cd>PDNG</Cd></Sts>
+    <BookgDt><Dt>2026-01-31</Dt></BookgDt>
+  </Ntry>
+</Stmt></BkToCstmrStmt>
+</Document>`
+
+func Test_ParseCAMT053(t *testing.T) {
+	statements, err := statement.ParseCAMT053(strings.NewReader(camt053))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(statements) != 1 {
+		t.Fatalf("should parse 1 statement but got %d", len(statements))
+	}
+
+	s := statements[0]
+	if s.BankAccount != "DE89370400440532013000" || s.Currency != "EUR" {
+		t.Errorf("unexpected statement header %q %q", s.BankAccount, s.Currency)
+	}
+	if s.OpeningBalance == nil || s.OpeningBalance.Amount != 100000 || s.ClosingBalance == nil || s.ClosingBalance.Amount != 145000 {
+		t.Errorf("unexpected balances %+v %+v", s.OpeningBalance, s.ClosingBalance)
+	}
+
+	// the pending entry is ignored and the batched entry is split by its details
+	if len(s.Lines) != 4 {
+		t.Fatalf("statement should have 4 lines but got %d", len(s.Lines))
+	}
+	first := s.Lines[0]
+	if first.Amount != 50000 || first.EndToEndID != "E2E-1" || first.Remittance != "Invoice 2026-001" || first.Payee != "ACME GmbH" || first.FITID != "REF-1" {
+		t.Errorf("unexpected first line %+v", first)
+	}
+	if s.Lines[1].Amount != -2000 || s.Lines[2].Amount != -3000 || s.Lines[2].EndToEndID != "E2E-3" {
+		t.Errorf("unexpected batched lines %+v %+v", s.Lines[1], s.Lines[2])
+	}
+	if s.Lines[1].FITID == s.Lines[2].FITID {
+		t.Error("batched lines should have different FITIDs")
+	}
+
+	// details without amounts keep a single line with the remittance of all of them, the date time has no zone
+	refund := s.Lines[3]
+	if refund.Amount != 1000 || refund.Remittance != "Refund A; RF18539007547034" || !refund.Date.Equal(time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected refund line %+v", refund)
+	}
+
+	// a split entry must have an amount in every detail, adding up to the entry amount
+	missing := strings.Replace(camt053, `<Amt Ccy="EUR">30.00</Amt><CdtDbtInd>DBIT</CdtDbtInd>`, "", 1)
+	if _, err := statement.ParseCAMT053(strings.NewReader(missing)); err == nil {
+		t.Error("split entry with a detail without amount should be rejected")
+	}
+	exceeding := strings.Replace(camt053, `<Amt Ccy="EUR">30.00</Amt>`, `<Amt Ccy="EUR">40.00</Amt>`, 1)
+	if _, err := statement.ParseCAMT053(strings.NewReader(exceeding)); err == nil {
+		t.Error("split entry with details adding up to more than the entry should be rejected")
+	}
+
+	// test the reconciliation against the ledger balance
+	balance := ledger.AccountBalance{AccountType: ledger.AccountTypeAsset, Balance: 145000 - 1000}
+	if diff, err := s.Difference(balance); err != nil || diff != 1000 {
+		t.Errorf("difference should be 1000 but got %d (%v)", diff, err)
+	}
+}
+
+const mt940 = `{1:F01BANKDEFFXXXX0000000000}{2:O9400000000000BANKDEFFXXXX00000000000000000000N}{4:
+:20:STARTUMSE
+:25:10020030/1234567
+:28C:00001/001
+:60F:C260101EUR1000,00
+:61:2601050105D42,50NTRFNONREF//B123456
+:86:166?00SEPA-UEBERWEISUNG?20EREF+E2E-1 SVWZ+Invoice?21 2026-001?32ACME
+:61:2601100110C500,NMSCCUST-1
+:86:/EREF/E2E-2/REMI/USTD//Salary January/NAME/Employer Inc/
+:62F:C260131EUR1457,50
+-}`
+
+func Test_ParseMT940(t *testing.T) {
+	statements, err := statement.ParseMT940(strings.NewReader(mt940))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(statements) != 1 {
+		t.Fatalf("should parse 1 statement but got %d", len(statements))
+	}
+
+	s := statements[0]
+	if s.BankAccount != "10020030/1234567" || s.Currency != "EUR" {
+		t.Errorf("unexpected statement header %q %q", s.BankAccount, s.Currency)
+	}
+	if s.OpeningBalance.Amount != 100000 || s.ClosingBalance.Amount != 145750 {
+		t.Errorf("unexpected balances %+v %+v", s.OpeningBalance, s.ClosingBalance)
+	}
+	if len(s.Lines) != 2 {
+		t.Fatalf("statement should have 2 lines but got %d", len(s.Lines))
+	}
+
+	first := s.Lines[0]
+	if first.Amount != -4250 || first.FITID != "B123456" || first.EndToEndID != "E2E-1" || first.Remittance != "Invoice 2026-001" || first.Payee != "ACME" {
+		t.Errorf("unexpected first line %+v", first)
+	}
+	second := s.Lines[1]
+	if second.Amount != 50000 || second.EndToEndID != "E2E-2" || second.Remittance != "Salary January" || second.Payee != "Employer Inc" {
+		t.Errorf("unexpected second line %+v", second)
+	}
+	if second.FITID == "" {
+		t.Error("lines without bank reference should receive a derived FITID")
+	}
+
+	// the continuation lines of a free text are joined with a space
+	free := strings.Replace(mt940, ":86:/EREF/E2E-2/REMI/USTD//Salary January/NAME/Employer Inc/", ":86:Refund of order\n12345 thank you", 1)
+	if statements, err := statement.ParseMT940(strings.NewReader(free)); err != nil || statements[0].Lines[1].Remittance != "Refund of order 12345 thank you" {
+		t.Errorf("unexpected free text line %+v (%v)", statements, err)
+	}
+
+	// the opening balance plus the lines must give the closing balance
+	sum := s.OpeningBalance.Amount
+	for _, l := range s.Lines {
+		sum += l.Amount
+	}
+	if sum != s.ClosingBalance.Amount {
+		t.Errorf("lines should add up to the closing balance, got %d", sum)
+	}
+
+	// the end-to-end id and remittance information are carried into the drafts
+	im := &statement.Importer{
+		Account:  ledger.Account{ID: uuid.New(), AccountType: ledger.AccountTypeAsset},
+		Suspense: uuid.New(),
+	}
+	r, _ := im.Import(s)
+	if md := r.Transactions[0].Metadata; md[statement.MetadataEndToEndID] != "E2E-1" || md[statement.MetadataRemittance] != "Invoice 2026-001" {
+		t.Errorf("unexpected draft metadata %v", md)
+	}
+}
@@ -0,0 +1,417 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Formula is a parsed amount formula.
+//
+// It supports integer and decimal numbers, percentages (10% is 0.1), parameters and variables by name,
+// the + - * / operators with parentheses, and the round, floor and ceil functions.
+// round rounds half away from zero. A formula is evaluated with exact rational arithmetic.
+type Formula struct {
+	source string
+	root   node
+}
+
+// ParseFormula parses the formula, the errors report the position of the problem.
+func ParseFormula(source string) (*Formula, error) {
+	p := &parser{source: source}
+	p.next()
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &Formula{source: source, root: root}, nil
+}
+
+func (f *Formula) String() string {
+	return f.source
+}
+
+// names returns the parameters and variables used by the formula.
+func (f *Formula) names() []string {
+	var names []string
+	walk(f.root, func(n node) {
+		if r, ok := n.(ref); ok {
+			names = append(names, string(r))
+		}
+	})
+	return names
+}
+
+// node is a node of the formula syntax tree.
+//
+// Besides its value, every node has a linear form over the parameters,
+// which is how a template is checked to balance for any parameters.
+type node interface {
+	eval(env map[string]*big.Rat) (*big.Rat, error)
+	linear(env map[string]linear) (linear, error)
+}
+
+type number struct{ v *big.Rat }
+
+type ref string
+
+type unary struct{ x node }
+
+type binary struct {
+	op   byte
+	x, y node
+}
+
+type call struct {
+	name string
+	x    node
+}
+
+func walk(n node, f func(node)) {
+	f(n)
+	switch n := n.(type) {
+	case unary:
+		walk(n.x, f)
+	case binary:
+		walk(n.x, f)
+		walk(n.y, f)
+	case *call:
+		walk(n.x, f)
+	}
+}
+
+func (n number) eval(map[string]*big.Rat) (*big.Rat, error) { return n.v, nil }
+
+func (n ref) eval(env map[string]*big.Rat) (*big.Rat, error) {
+	v, ok := env[string(n)]
+	if !ok {
+		return nil, fmt.Errorf("%s is not defined", string(n))
+	}
+	return v, nil
+}
+
+func (n unary) eval(env map[string]*big.Rat) (*big.Rat, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).Neg(x), nil
+}
+
+func (n binary) eval(env map[string]*big.Rat) (*big.Rat, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case '+':
+		return new(big.Rat).Add(x, y), nil
+	case '-':
+		return new(big.Rat).Sub(x, y), nil
+	case '*':
+		return new(big.Rat).Mul(x, y), nil
+	default:
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return new(big.Rat).Quo(x, y), nil
+	}
+}
+
+func (n *call) eval(env map[string]*big.Rat) (*big.Rat, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	q, r := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int)) // truncated towards zero
+	switch {
+	case r.Sign() == 0:
+	case n.name == "floor" && x.Sign() < 0:
+		q.Sub(q, big.NewInt(1))
+	case n.name == "ceil" && x.Sign() > 0:
+		q.Add(q, big.NewInt(1))
+	case n.name == "round" && new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(x.Denom()) >= 0:
+		q.Add(q, big.NewInt(int64(x.Sign())))
+	}
+	return new(big.Rat).SetInt(q), nil
+}
+
+// errNonLinear is returned by the linear form of a product or a quotient of parameters.
+var errNonLinear = errors.New("formula is not linear")
+
+// linear is a linear combination of symbols, the empty symbol is the constant term.
+type linear map[string]*big.Rat
+
+func (l linear) constant() (*big.Rat, bool) {
+	for s, c := range l {
+		if s != "" && c.Sign() != 0 {
+			return nil, false
+		}
+	}
+	if c, ok := l[""]; ok {
+		return c, true
+	}
+	return new(big.Rat), true
+}
+
+func (l linear) add(o linear, sign int) linear {
+	sum := make(linear, len(l)+len(o))
+	for s, c := range l {
+		sum[s] = new(big.Rat).Set(c)
+	}
+	for s, c := range o {
+		if sum[s] == nil {
+			sum[s] = new(big.Rat)
+		}
+		if sign < 0 {
+			sum[s].Sub(sum[s], c)
+		} else {
+			sum[s].Add(sum[s], c)
+		}
+	}
+	return sum
+}
+
+func (l linear) scale(k *big.Rat) linear {
+	scaled := make(linear, len(l))
+	for s, c := range l {
+		scaled[s] = new(big.Rat).Mul(c, k)
+	}
+	return scaled
+}
+
+// isZero returns true if the combination is zero whatever the values of the symbols.
+func (l linear) isZero() bool {
+	for _, c := range l {
+		if c.Sign() != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (n number) linear(map[string]linear) (linear, error) {
+	return linear{"": n.v}, nil
+}
+
+func (n ref) linear(env map[string]linear) (linear, error) {
+	l, ok := env[string(n)]
+	if !ok {
+		return linear{string(n): big.NewRat(1, 1)}, nil
+	}
+	return l, nil
+}
+
+func (n unary) linear(env map[string]linear) (linear, error) {
+	x, err := n.x.linear(env)
+	if err != nil {
+		return nil, err
+	}
+	return x.scale(big.NewRat(-1, 1)), nil
+}
+
+func (n binary) linear(env map[string]linear) (linear, error) {
+	x, err := n.x.linear(env)
+	if err != nil {
+		return nil, err
+	}
+	y, err := n.y.linear(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case '+':
+		return x.add(y, 1), nil
+	case '-':
+		return x.add(y, -1), nil
+	case '*':
+		if k, ok := y.constant(); ok {
+			return x.scale(k), nil
+		}
+		if k, ok := x.constant(); ok {
+			return y.scale(k), nil
+		}
+		return nil, fmt.Errorf("%w: cannot multiply two parameters, use a variable for the product", errNonLinear)
+	default:
+		k, ok := y.constant()
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot divide by a parameter, use a variable for the quotient", errNonLinear)
+		}
+		if k.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return x.scale(new(big.Rat).Inv(k)), nil
+	}
+}
+
+// A rounding function is not linear, so every call is a symbol of its own.
+// The same rounded amount used in many lines must be a variable to cancel out.
+func (n *call) linear(env map[string]linear) (linear, error) {
+	if _, err := n.x.linear(env); err != nil {
+		return nil, err
+	}
+	return linear{fmt.Sprintf("%s@%p", n.name, n): big.NewRat(1, 1)}, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type parser struct {
+	source string
+	pos    int
+	tok    token
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("formula %q at %d: %s", p.source, p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) next() {
+	for p.pos < len(p.source) && p.source[p.pos] == ' ' {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.source) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.source[p.pos]
+	switch {
+	case isDigit(c):
+		for p.pos < len(p.source) && (isDigit(p.source[p.pos]) || p.source[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.source) && p.source[p.pos] == '%' {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.source[start:p.pos], pos: start}
+	case isLetter(c):
+		for p.pos < len(p.source) && (isLetter(p.source[p.pos]) || isDigit(p.source[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.source[start:p.pos], pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokOp, text: p.source[start:p.pos], pos: start}
+	}
+}
+
+func (p *parser) is(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+// expr parses a sum of terms.
+func (p *parser) expr() (node, error) {
+	x, err := p.term()
+	for err == nil && (p.is("+") || p.is("-")) {
+		op := p.tok.text[0]
+		p.next()
+		var y node
+		if y, err = p.term(); err == nil {
+			x = binary{op: op, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+// term parses a product of factors.
+func (p *parser) term() (node, error) {
+	x, err := p.factor()
+	for err == nil && (p.is("*") || p.is("/")) {
+		op := p.tok.text[0]
+		p.next()
+		var y node
+		if y, err = p.factor(); err == nil {
+			x = binary{op: op, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (p *parser) factor() (node, error) {
+	tok := p.tok
+	switch {
+	case p.is("-"):
+		p.next()
+		x, err := p.factor()
+		return unary{x: x}, err
+
+	case p.is("("):
+		p.next()
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.errorf("expected )")
+		}
+		p.next()
+		return x, nil
+
+	case tok.kind == tokNumber:
+		text, percent := strings.CutSuffix(tok.text, "%")
+		v, ok := new(big.Rat).SetString(text)
+		if !ok || strings.Count(text, ".") > 1 {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		if percent {
+			v.Quo(v, big.NewRat(100, 1))
+		}
+		p.next()
+		return number{v: v}, nil
+
+	case tok.kind == tokIdent:
+		p.next()
+		if !p.is("(") {
+			return ref(tok.text), nil
+		}
+		if tok.text != "round" && tok.text != "floor" && tok.text != "ceil" {
+			p.tok = tok
+			return nil, p.errorf("unknown function %s", tok.text)
+		}
+		p.next()
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.errorf("expected )")
+		}
+		p.next()
+		return &call{name: tok.text, x: x}, nil
+
+	case tok.kind == tokEOF:
+		return nil, p.errorf("unexpected end of formula")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
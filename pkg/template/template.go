@@ -0,0 +1,260 @@
+// template package builds balanced transactions from named templates.
+//
+// A template is the shape of a transaction posted again and again, such as a sale with a fee and a tax:
+//   - Its lines are against account roles instead of accounts, the roles are bound to accounts on every use.
+//   - The amounts are formulas over parameters, such as "round(amount * 10%)", and variables defined by the template.
+//   - A template is checked to balance for any parameters when it is created, so its transactions always balance.
+package template
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+)
+
+// MetadataTemplate is the metadata key with the name of the template that built a transaction.
+const MetadataTemplate = "template"
+
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrUnbalanced       = errors.New("template does not balance")
+)
+
+// Variable is an intermediate amount of a template, it can use the parameters and the variables defined before it.
+type Variable struct {
+	Name    string
+	Formula string
+}
+
+// Line is an entry of a template.
+type Line struct {
+	Role   string // The role of the account, such as "receivable" or "revenue".
+	Amount string // The formula of the amount, with the same sign convention as ledger.Entry.
+}
+
+// Template is a compiled transaction template, it is immutable and safe for concurrent use.
+type Template struct {
+	name      string
+	variables []Variable
+	lines     []Line
+	formulas  map[string]*Formula // The formulas of the variables.
+	amounts   []*Formula          // The formulas of the lines.
+	params    []string
+	roles     []string
+}
+
+// New compiles a template. It returns ErrUnbalanced if the sum of the lines is not zero for every parameter value.
+//
+// Rounded amounts and products or quotients of parameters are opaque for the balance check,
+// such an amount used in many lines must be a variable, as in "fee = round(amount * 2.5%)"
+// or "total = qty * price", for the lines to cancel out.
+func New(name string, variables []Variable, lines []Line) (*Template, error) {
+	if name == "" {
+		return nil, errors.New("template has no name")
+	}
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("template %s must have at least two lines", name)
+	}
+
+	t := &Template{
+		name:      name,
+		variables: slices.Clone(variables),
+		lines:     slices.Clone(lines),
+		formulas:  make(map[string]*Formula, len(variables)),
+	}
+	defined := make(map[string]bool, len(variables))
+	for _, v := range variables {
+		defined[v.Name] = true
+	}
+	params := make(map[string]bool)
+	use := func(f *Formula, declared map[string]bool) error {
+		for _, n := range f.names() {
+			switch {
+			case declared[n]:
+			case defined[n]:
+				return fmt.Errorf("%s is used before it is defined", n)
+			default:
+				params[n] = true
+			}
+		}
+		return nil
+	}
+
+	// the linear forms of the variables are shared by all the lines
+	env := make(map[string]linear, len(variables))
+	declared := make(map[string]bool, len(variables))
+	for _, v := range variables {
+		if v.Name == "" || declared[v.Name] {
+			return nil, fmt.Errorf("template %s: invalid or duplicate variable %q", name, v.Name)
+		}
+		f, err := ParseFormula(v.Formula)
+		if err != nil {
+			return nil, fmt.Errorf("template %s, variable %s: %w", name, v.Name, err)
+		}
+		if err := use(f, declared); err != nil {
+			return nil, fmt.Errorf("template %s, variable %s: %w", name, v.Name, err)
+		}
+		// a variable that is not linear, such as "amount * rate", is a symbol of its own as a rounded amount
+		switch env[v.Name], err = f.root.linear(env); {
+		case errors.Is(err, errNonLinear):
+			env[v.Name] = linear{"var:" + v.Name: big.NewRat(1, 1)}
+		case err != nil:
+			return nil, fmt.Errorf("template %s, variable %s: %w", name, v.Name, err)
+		}
+		t.formulas[v.Name] = f
+		declared[v.Name] = true
+	}
+
+	sum := linear{}
+	roles := make(map[string]bool)
+	for i, line := range lines {
+		if line.Role == "" {
+			return nil, fmt.Errorf("template %s, line %d: no role", name, i+1)
+		}
+		f, err := ParseFormula(line.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("template %s, line %d: %w", name, i+1, err)
+		}
+		if err := use(f, declared); err != nil {
+			return nil, fmt.Errorf("template %s, line %d: %w", name, i+1, err)
+		}
+		l, err := f.root.linear(env)
+		if err != nil {
+			return nil, fmt.Errorf("template %s, line %d: %w", name, i+1, err)
+		}
+		sum = sum.add(l, 1)
+		t.amounts = append(t.amounts, f)
+		roles[line.Role] = true
+	}
+	if !sum.isZero() {
+		return nil, fmt.Errorf("%w: %s", ErrUnbalanced, name)
+	}
+
+	t.params = sortedKeys(params)
+	t.roles = sortedKeys(roles)
+	return t, nil
+}
+
+func (t *Template) Name() string {
+	return t.name
+}
+
+// Params returns the names of the parameters of the template, sorted.
+func (t *Template) Params() []string {
+	return slices.Clone(t.params)
+}
+
+// Roles returns the account roles of the template, sorted.
+func (t *Template) Roles() []string {
+	return slices.Clone(t.roles)
+}
+
+// Instantiate builds a regular transaction from the template with the given timestamp.
+//
+// Every role must be bound to an account and every parameter must have a value, nothing else is accepted.
+// Every line amount must be a whole number, lines with a zero amount are left out and it is an error if all are zero.
+func (t *Template) Instantiate(at time.Time, accounts map[string]uuid.UUID, params map[string]int) (*ledger.Transaction, error) {
+	if err := sameKeys("role", t.roles, accounts); err != nil {
+		return nil, fmt.Errorf("template %s: %w", t.name, err)
+	}
+	if err := sameKeys("parameter", t.params, params); err != nil {
+		return nil, fmt.Errorf("template %s: %w", t.name, err)
+	}
+
+	env := make(map[string]*big.Rat, len(params)+len(t.variables))
+	for name, v := range params {
+		env[name] = big.NewRat(int64(v), 1)
+	}
+	for _, v := range t.variables {
+		value, err := t.formulas[v.Name].root.eval(env)
+		if err != nil {
+			return nil, fmt.Errorf("template %s, variable %s: %w", t.name, v.Name, err)
+		}
+		env[v.Name] = value
+	}
+
+	tx := ledger.NewRegularTransaction(at)
+	for i, f := range t.amounts {
+		value, err := f.root.eval(env)
+		if err != nil {
+			return nil, fmt.Errorf("template %s, line %d: %w", t.name, i+1, err)
+		}
+		if !value.IsInt() || !value.Num().IsInt64() {
+			return nil, fmt.Errorf("template %s, line %d: amount %s is not a whole number", t.name, i+1, value.FloatString(2))
+		}
+		if amount := int(value.Num().Int64()); amount != 0 {
+			tx.AddEntry(ledger.Entry{Account: accounts[t.lines[i].Role], Amount: amount})
+		}
+	}
+	if _, err := tx.IsBalanced(); err != nil {
+		return nil, fmt.Errorf("template %s: %w", t.name, err)
+	}
+	tx.Metadata = map[string]string{MetadataTemplate: t.name}
+	return tx, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func sameKeys[V any](kind string, names []string, values map[string]V) error {
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return fmt.Errorf("missing %s %s", kind, name)
+		}
+	}
+	for name := range values {
+		if _, ok := slices.BinarySearch(names, name); !ok {
+			return fmt.Errorf("unknown %s %s", kind, name)
+		}
+	}
+	return nil
+}
+
+// Registry keeps templates by name, it is safe for concurrent use.
+type Registry struct {
+	mu        sync.RWMutex
+	templates map[string]*Template
+}
+
+func NewRegistry() *Registry {
+	return &Registry{templates: make(map[string]*Template)}
+}
+
+// Add adds the template, replacing the one with the same name if any.
+func (r *Registry) Add(t *Template) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templates[t.name] = t
+}
+
+// Template returns the template with the given name.
+func (r *Registry) Template(name string) (*Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return t, nil
+}
+
+// Instantiate builds a transaction from the template with the given name, see Template.Instantiate.
+func (r *Registry) Instantiate(name string, at time.Time, accounts map[string]uuid.UUID, params map[string]int) (*ledger.Transaction, error) {
+	t, err := r.Template(name)
+	if err != nil {
+		return nil, err
+	}
+	return t.Instantiate(at, accounts, params)
+}
@@ -0,0 +1,151 @@
+package template_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tarcisio/haya/pkg/ledger"
+	"github.com/tarcisio/haya/pkg/template"
+)
+
+func Test_Template(t *testing.T) {
+
+	sale, err := template.New("sale",
+		[]template.Variable{
+			{Name: "tax", Formula: "round(amount * 8.5%)"},
+			{Name: "fee", Formula: "round(amount * 10%)"},
+		},
+		[]template.Line{
+			{Role: "receivable", Amount: "amount + tax - fee"},
+			{Role: "fees", Amount: "fee"},
+			{Role: "revenue", Amount: "-amount"},
+			{Role: "tax", Amount: "-tax"},
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params := sale.Params(); len(params) != 1 || params[0] != "amount" {
+		t.Errorf("unexpected params %v", params)
+	}
+
+	accounts := map[string]uuid.UUID{"receivable": uuid.New(), "fees": uuid.New(), "revenue": uuid.New(), "tax": uuid.New()}
+	now := time.Now()
+	tx, err := sale.Instantiate(now, accounts, map[string]int{"amount": 1999})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, _ := tx.IsBalanced(); !ok || tx.TransactionType != ledger.TransactionTypeRegular || tx.Metadata[template.MetadataTemplate] != "sale" {
+		t.Errorf("unexpected transaction %+v", tx)
+	}
+	// tax is 169.915 rounded to 170, fee is 199.9 rounded to 200
+	want := map[uuid.UUID]int{accounts["receivable"]: 1969, accounts["fees"]: 200, accounts["revenue"]: -1999, accounts["tax"]: -170}
+	for _, e := range tx.Entries {
+		if want[e.Account] != e.Amount {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	}
+
+	// zero amounts are left out, a transaction with no entries is an error
+	if tx, err := sale.Instantiate(now, accounts, map[string]int{"amount": 0}); err == nil {
+		t.Errorf("a sale of 0 should be rejected but got %+v", tx.Entries)
+	}
+
+	// every role and parameter must be given, and nothing else
+	if _, err := sale.Instantiate(now, accounts, map[string]int{"amount": 1, "discount": 1}); err == nil {
+		t.Error("unknown parameter should be rejected")
+	}
+	if _, err := sale.Instantiate(now, map[string]uuid.UUID{"receivable": uuid.New()}, map[string]int{"amount": 1}); err == nil {
+		t.Error("missing role should be rejected")
+	}
+
+	// amounts must be whole numbers
+	split, _ := template.New("split", nil, []template.Line{{Role: "a", Amount: "amount / 2"}, {Role: "b", Amount: "-amount / 2"}})
+	if _, err := split.Instantiate(now, map[string]uuid.UUID{"a": uuid.New(), "b": uuid.New()}, map[string]int{"amount": 3}); err == nil {
+		t.Error("fractional amount should be rejected")
+	}
+
+	registry := template.NewRegistry()
+	registry.Add(sale)
+	if _, err := registry.Instantiate("sale", now, accounts, map[string]int{"amount": 100}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := registry.Template("refund"); !errors.Is(err, template.ErrTemplateNotFound) {
+		t.Errorf("unknown template should not be found but got %v", err)
+	}
+}
+
+func Test_TemplateUnbalanced(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		lines []template.Line
+	}{
+		{"missing fee", []template.Line{{Role: "cash", Amount: "amount"}, {Role: "revenue", Amount: "-amount * 90%"}}},
+		{"two parameters", []template.Line{{Role: "cash", Amount: "amount + tip"}, {Role: "revenue", Amount: "-amount"}}},
+		// the same rounding in two lines is not known to cancel out, it must be a variable
+		{"inline rounding", []template.Line{{Role: "cash", Amount: "round(amount / 3)"}, {Role: "revenue", Amount: "-round(amount / 3)"}}},
+	}
+	for _, test := range tests {
+		if _, err := template.New(test.name, nil, test.lines); !errors.Is(err, template.ErrUnbalanced) {
+			t.Errorf("%s: expected unbalanced template but got %v", test.name, err)
+		}
+	}
+
+	invalid := [][]template.Line{
+		{{Role: "cash", Amount: "amount * price"}, {Role: "revenue", Amount: "-amount * price"}},
+		{{Role: "cash", Amount: "amount"}},
+		{{Role: "", Amount: "amount"}, {Role: "revenue", Amount: "-amount"}},
+	}
+	for _, lines := range invalid {
+		if _, err := template.New("invalid", nil, lines); err == nil {
+			t.Errorf("template %v should be rejected", lines)
+		}
+	}
+
+	// a product of parameters can be a variable, whose lines are still checked for balance
+	product := []template.Variable{{Name: "total", Formula: "qty * price"}, {Name: "tax", Formula: "round(total * 10%)"}}
+	order, err := template.New("order", product, []template.Line{
+		{Role: "receivable", Amount: "total + tax"},
+		{Role: "revenue", Amount: "-total"},
+		{Role: "tax", Amount: "-tax"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	accounts := map[string]uuid.UUID{"receivable": uuid.New(), "revenue": uuid.New(), "tax": uuid.New()}
+	tx, err := order.Instantiate(time.Now(), accounts, map[string]int{"qty": 3, "price": 250})
+	if err != nil || tx.Entries[0].Amount != 825 {
+		t.Errorf("expected a receivable of 825 but got %+v, %v", tx, err)
+	}
+	if _, err := template.New("order", product, []template.Line{{Role: "receivable", Amount: "total"}, {Role: "revenue", Amount: "-qty * price"}}); err == nil {
+		t.Error("a line repeating the product of the variable should not be known to balance")
+	}
+	if _, err := template.New("order", product, []template.Line{{Role: "receivable", Amount: "total + 1"}, {Role: "revenue", Amount: "-total"}}); !errors.Is(err, template.ErrUnbalanced) {
+		t.Errorf("expected unbalanced template but got %v", err)
+	}
+	if _, err := template.New("zero", []template.Variable{{Name: "x", Formula: "amount / 0"}}, []template.Line{{Role: "a", Amount: "x"}, {Role: "b", Amount: "-x"}}); err == nil {
+		t.Error("division by zero should be rejected")
+	}
+
+	if _, err := template.New("later", []template.Variable{{Name: "a", Formula: "b"}, {Name: "b", Formula: "x"}}, []template.Line{{Role: "x", Amount: "a"}, {Role: "y", Amount: "-a"}}); err == nil {
+		t.Error("variable used before it is defined should be rejected")
+	}
+}
+
+func Test_ParseFormula(t *testing.T) {
+
+	for _, test := range []struct{ formula, position string }{
+		{"amount * (2 +", "at 14"},
+		{"amount $ 2", "at 8"},
+		{"sqrt(amount)", "at 1"},
+		{"1.2.3", "at 1"},
+	} {
+		_, err := template.ParseFormula(test.formula)
+		if err == nil || !strings.Contains(err.Error(), test.position) {
+			t.Errorf("%q: expected error %s but got %v", test.formula, test.position, err)
+		}
+	}
+}